@@ -0,0 +1,89 @@
+package frisbii
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/ipfs/go-cid"
+)
+
+// Authorizer can be supplied with WithAuthorizer to decide, per request,
+// whether content may be retrieved, e.g. by consulting an external policy
+// service with the root CID and the client's address or headers.
+//
+// Authorize is called at most once per request, with the root CID of the
+// request, once the root has been checked against the denylist and servable
+// roots, and before any of the content is streamed. A request for a root that
+// isn't present may be refused with a 404 Not Found before it's called. Where
+// allow is false, the request is refused with status, e.g.
+// http.StatusPaymentRequired or http.StatusForbidden, or with a 403 Forbidden
+// where status isn't a 4xx or 5xx error. Where err is non-nil, the request is
+// refused with a 500 Internal Server Error. In both cases the client is only
+// told that it isn't authorized, while the request log records the decision.
+//
+// Authorize is called synchronously on the request goroutine, so a slow
+// decision delays the response; ctx is cancelled where the request is.
+type Authorizer interface {
+	Authorize(ctx context.Context, req *http.Request, root cid.Cid) (allow bool, status int, err error)
+}
+
+// AuthorizerFunc is an adapter to allow the use of an ordinary function as an
+// Authorizer.
+type AuthorizerFunc func(ctx context.Context, req *http.Request, root cid.Cid) (bool, int, error)
+
+// Authorize calls f(ctx, req, root).
+func (f AuthorizerFunc) Authorize(ctx context.Context, req *http.Request, root cid.Cid) (bool, int, error) {
+	return f(ctx, req, root)
+}
+
+// authorizationError is the error for a request that an Authorizer refused,
+// or failed to decide on. The client is only told that it isn't authorized,
+// while the request log records the status or error of the decision.
+type authorizationError struct {
+	root   cid.Cid
+	status int
+	err    error
+}
+
+func (e *authorizationError) Error() string {
+	if e.err != nil {
+		return "authorization failed"
+	}
+	return "not authorized"
+}
+
+func (e *authorizationError) logMessage() string {
+	if e.err != nil {
+		return fmt.Sprintf("authorization failed for %s: %s", e.root, e.err)
+	}
+	return fmt.Sprintf("not authorized: %s refused by authorizer with status %d", e.root, e.status)
+}
+
+// newAuthorize returns a function that consults authorizer, where there is
+// one, for a request for root, responding to the request with logError and
+// returning false where it's refused. A request is only authorized once, the
+// decision is reused where it's checked again, e.g. where a directory index
+// falls through to another response.
+func newAuthorize(ctx context.Context, req *http.Request, authorizer Authorizer, logError func(int, error)) func(root cid.Cid) bool {
+	var authorized bool
+	return func(root cid.Cid) bool {
+		if authorizer == nil || authorized {
+			return true
+		}
+		allow, status, err := authorizer.Authorize(ctx, req, root)
+		switch {
+		case err != nil:
+			logError(http.StatusInternalServerError, &authorizationError{root: root, err: err})
+			return false
+		case !allow:
+			if status < 400 || status > 599 {
+				status = http.StatusForbidden
+			}
+			logError(status, &authorizationError{root: root, status: status})
+			return false
+		}
+		authorized = true
+		return true
+	}
+}
@@ -0,0 +1,159 @@
+package frisbii_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipld/frisbii"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+	trustlesstestutil "github.com/ipld/go-trustless-utils/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHttpIpfsAuthorizer(t *testing.T) {
+	store := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	unixfsnode.AddUnixFSReificationToLinkSystem(&lsys)
+
+	fileCid := mkUnixfsFile(t, lsys, []byte("hello world")).(cidlink.Link).Cid
+	missing := cid.MustParse("bafkreiepinbumzepnoln7co5vea4kf3lcctnqolb3u6bvsellgznymt2uq")
+	carType := trustlesshttp.DefaultContentType().String()
+
+	type decision struct {
+		allow  bool
+		status int
+		err    error
+	}
+	for _, tc := range []struct {
+		name         string
+		decision     *decision // no authorizer if nil
+		path         string
+		accept       string
+		expectStatus int
+		expectCalls  int
+		expectLog    string
+	}{
+		{
+			name:         "no authorizer",
+			path:         "/ipfs/" + fileCid.String(),
+			accept:       carType,
+			expectStatus: http.StatusOK,
+		},
+		{
+			name:         "allowed",
+			decision:     &decision{allow: true},
+			path:         "/ipfs/" + fileCid.String(),
+			accept:       carType,
+			expectStatus: http.StatusOK,
+			expectCalls:  1,
+		},
+		{
+			name:         "payment required",
+			decision:     &decision{status: http.StatusPaymentRequired},
+			path:         "/ipfs/" + fileCid.String(),
+			accept:       carType,
+			expectStatus: http.StatusPaymentRequired,
+			expectCalls:  1,
+			expectLog:    "not authorized: " + fileCid.String() + " refused by authorizer with status 402",
+		},
+		{
+			name:         "refused without an error status",
+			decision:     &decision{status: http.StatusOK},
+			path:         "/ipfs/" + fileCid.String() + "?format=raw",
+			expectStatus: http.StatusForbidden,
+			expectCalls:  1,
+			expectLog:    "not authorized: " + fileCid.String() + " refused by authorizer with status 403",
+		},
+		{
+			name:         "authorizer error",
+			decision:     &decision{err: errors.New("policy service unavailable")},
+			path:         "/ipfs/" + fileCid.String(),
+			accept:       carType,
+			expectStatus: http.StatusInternalServerError,
+			expectCalls:  1,
+			expectLog:    "authorization failed for " + fileCid.String() + ": policy service unavailable",
+		},
+		{
+			name:         "probe refused",
+			decision:     &decision{status: http.StatusForbidden},
+			path:         "/ipfs/" + fileCid.String() + "?probe=true",
+			expectStatus: http.StatusForbidden,
+			expectCalls:  1,
+		},
+		{
+			name:         "file refused",
+			decision:     &decision{status: http.StatusForbidden},
+			path:         "/ipfs/" + fileCid.String(),
+			accept:       "text/html",
+			expectStatus: http.StatusForbidden,
+			expectCalls:  1,
+		},
+		{
+			// a file isn't a directory, so the request falls through from the
+			// directory index to the file, authorized only the once
+			name:         "file allowed",
+			decision:     &decision{allow: true},
+			path:         "/ipfs/" + fileCid.String(),
+			accept:       "text/html",
+			expectStatus: http.StatusOK,
+			expectCalls:  1,
+		},
+		{
+			name:         "missing root isn't authorized",
+			decision:     &decision{status: http.StatusForbidden},
+			path:         "/ipfs/" + missing.String(),
+			accept:       carType,
+			expectStatus: http.StatusNotFound,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+
+			var calls int
+			var logBuf bytes.Buffer
+			opts := []frisbii.HttpOption{
+				frisbii.WithLogWriter(&logBuf),
+				frisbii.WithPresenceCheck(store),
+				frisbii.WithDirectoryIndex(true),
+				frisbii.WithDeserialized(true),
+			}
+			if tc.decision != nil {
+				opts = append(opts, frisbii.WithAuthorizer(frisbii.AuthorizerFunc(func(ctx context.Context, r *http.Request, root cid.Cid) (bool, int, error) {
+					calls++
+					if !root.Equals(fileCid) {
+						return false, http.StatusTeapot, nil
+					}
+					return tc.decision.allow, tc.decision.status, tc.decision.err
+				})))
+			}
+			handler := frisbii.NewLogMiddleware(frisbii.NewHttpIpfs(context.Background(), lsys, opts...), opts...)
+			request := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.accept != "" {
+				request.Header.Set("Accept", tc.accept)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, request)
+
+			req.Equal(tc.expectStatus, rec.Code)
+			req.Equal(tc.expectCalls, calls)
+			if tc.expectStatus == http.StatusForbidden || tc.expectStatus == http.StatusPaymentRequired {
+				// the client isn't told why
+				req.Equal("not authorized", rec.Body.String())
+			}
+			if tc.expectLog != "" {
+				req.Contains(logBuf.String(), strconv.Quote(tc.expectLog))
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package frisbii
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+)
+
+// Query parameters that may be used alongside format=car, as an alternative to
+// the equivalent Accept header media type parameters, to negotiate the
+// specifics of a CAR response.
+const (
+	QueryCarVersion = "car-version"
+	QueryCarOrder   = "car-order"
+	QueryCarDups    = "car-dups"
+)
+
+// checkCarAccept inspects the CAR media types in the Accept header and returns
+// an error describing the first unsupported version or order parameter found.
+// It is used to provide a more useful error than a generic "unsupported Accept
+// header" when no acceptable media type could be negotiated.
+func checkCarAccept(req *http.Request) error {
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		parts := strings.Split(accept, ";")
+		if strings.TrimSpace(parts[0]) != trustlesshttp.MimeTypeCar {
+			continue
+		}
+		for _, part := range parts[1:] {
+			attr, value, _ := strings.Cut(part, "=")
+			if err := checkCarParam(strings.TrimSpace(attr), strings.TrimSpace(value)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func checkCarParam(attr, value string) error {
+	switch attr {
+	case "version":
+		if value != trustlesshttp.MimeTypeCarVersion {
+			return fmt.Errorf("unsupported CAR version: %q, only version %s is supported", value, trustlesshttp.MimeTypeCarVersion)
+		}
+	case "order":
+		switch trustlesshttp.ContentTypeOrder(value) {
+		case trustlesshttp.ContentTypeOrderDfs, trustlesshttp.ContentTypeOrderUnk:
+		default:
+			return fmt.Errorf("unsupported CAR order: %q, only %s is supported", value, trustlesshttp.ContentTypeOrderDfs)
+		}
+	case "dups":
+		if value != "y" && value != "n" {
+			return fmt.Errorf("invalid CAR dups: %q, must be y or n", value)
+		}
+	}
+	return nil
+}
+
+// negotiateCarParams applies the car-version, car-order and car-dups query
+// parameters, and returns the content type that describes the CAR that will
+// actually be produced. car-dups applies where the Accept header doesn't
+// specify dups itself, e.g. for "*/*", and it's an error for the two to
+// conflict. Blocks are always emitted in depth-first order, which also
+// satisfies a request for an unknown ("unk") order, so the response always
+// declares order=dfs.
+func negotiateCarParams(req *http.Request, accept trustlesshttp.ContentType) (trustlesshttp.ContentType, error) {
+	query := req.URL.Query()
+	for _, param := range []string{QueryCarVersion, QueryCarOrder, QueryCarDups} {
+		if !query.Has(param) {
+			continue
+		}
+		value := query.Get(param)
+		if err := checkCarParam(strings.TrimPrefix(param, "car-"), value); err != nil {
+			return accept, err
+		}
+		if param == QueryCarDups {
+			if acceptDups, ok := acceptCarDups(req); ok && acceptDups != value {
+				return accept, fmt.Errorf("conflicting CAR dups: %q in the Accept header, %q in the %s query parameter", acceptDups, value, QueryCarDups)
+			}
+			accept = accept.WithDuplicates(value == "y")
+		}
+	}
+	return accept.WithOrder(trustlesshttp.ContentTypeOrderDfs), nil
+}
+
+// acceptCarDups returns the dups parameter of the first CAR media type in the
+// Accept header that has one, and false where none do.
+func acceptCarDups(req *http.Request) (string, bool) {
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		parts := strings.Split(accept, ";")
+		if strings.TrimSpace(parts[0]) != trustlesshttp.MimeTypeCar {
+			continue
+		}
+		for _, part := range parts[1:] {
+			if attr, value, _ := strings.Cut(part, "="); strings.TrimSpace(attr) == "dups" {
+				return strings.TrimSpace(value), true
+			}
+		}
+	}
+	return "", false
+}
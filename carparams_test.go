@@ -0,0 +1,244 @@
+package frisbii_test
+
+import (
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipld/frisbii"
+	"github.com/ipld/go-car/v2"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+	trustlesstestutil "github.com/ipld/go-trustless-utils/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHttpIpfsCarParams(t *testing.T) {
+	store := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	unixfsnode.AddUnixFSReificationToLinkSystem(&lsys)
+
+	// a small nested DAG so depth-first order is distinguishable from
+	// breadth-first order: root -> [a -> [a1], b]
+	a1 := mkUnixfsFile(t, lsys, []byte("a1"))
+	a := mkUnixfsDir(t, lsys, map[string]datamodel.Link{"a1": a1})
+	b := mkUnixfsFile(t, lsys, []byte("b"))
+	root := mkUnixfsDir(t, lsys, map[string]datamodel.Link{"a": a, "b": b})
+	dfsOrder := []cid.Cid{
+		root.(cidlink.Link).Cid,
+		a.(cidlink.Link).Cid,
+		a1.(cidlink.Link).Cid,
+		b.(cidlink.Link).Cid,
+	}
+
+	handler := frisbii.NewHttpIpfs(context.Background(), lsys)
+	testServer := httptest.NewServer(handler)
+	defer testServer.Close()
+
+	for _, tc := range []struct {
+		name                string
+		query               string
+		accept              string
+		expectedStatusCode  int
+		expectedContentType string
+		expectedBody        string
+	}{
+		{
+			name:                "explicit dfs",
+			accept:              trustlesshttp.MimeTypeCar + ";version=1;order=dfs;dups=n",
+			expectedStatusCode:  http.StatusOK,
+			expectedContentType: trustlesshttp.DefaultContentType().WithDuplicates(false).String(),
+		},
+		{
+			name:                "unknown order is served as dfs",
+			accept:              trustlesshttp.MimeTypeCar + ";order=unk;dups=n",
+			expectedStatusCode:  http.StatusOK,
+			expectedContentType: trustlesshttp.DefaultContentType().WithDuplicates(false).String(),
+		},
+		{
+			name:                "query parameters",
+			query:               "?format=car&car-version=1&car-order=dfs&car-dups=n",
+			expectedStatusCode:  http.StatusOK,
+			expectedContentType: trustlesshttp.DefaultContentType().WithDuplicates(false).String(),
+		},
+		{
+			name:                "query parameters, any type accepted",
+			query:               "?format=car&car-dups=n",
+			accept:              "*/*",
+			expectedStatusCode:  http.StatusOK,
+			expectedContentType: trustlesshttp.DefaultContentType().WithDuplicates(false).String(),
+		},
+		{
+			name:                "query parameters, CAR without dups accepted",
+			query:               "?car-dups=n",
+			accept:              trustlesshttp.MimeTypeCar + ";version=1",
+			expectedStatusCode:  http.StatusOK,
+			expectedContentType: trustlesshttp.DefaultContentType().WithDuplicates(false).String(),
+		},
+		{
+			name:                "query parameters, agreeing with Accept",
+			query:               "?car-dups=n",
+			accept:              trustlesshttp.MimeTypeCar + ";dups=n",
+			expectedStatusCode:  http.StatusOK,
+			expectedContentType: trustlesshttp.DefaultContentType().WithDuplicates(false).String(),
+		},
+		{
+			name:               "query parameters, conflicting with Accept",
+			query:              "?car-dups=n",
+			accept:             trustlesshttp.MimeTypeCar + ";dups=y",
+			expectedStatusCode: http.StatusBadRequest,
+			expectedBody:       `conflicting CAR dups: "y" in the Accept header, "n" in the car-dups query parameter`,
+		},
+		{
+			name:               "unsupported version",
+			accept:             trustlesshttp.MimeTypeCar + ";version=2",
+			expectedStatusCode: http.StatusBadRequest,
+			expectedBody:       `unsupported CAR version: "2", only version 1 is supported`,
+		},
+		{
+			name:               "unsupported order",
+			accept:             trustlesshttp.MimeTypeCar + ";order=bfs",
+			expectedStatusCode: http.StatusBadRequest,
+			expectedBody:       `unsupported CAR order: "bfs", only dfs is supported`,
+		},
+		{
+			name:               "unsupported query version",
+			query:              "?format=car&car-version=2",
+			expectedStatusCode: http.StatusBadRequest,
+			expectedBody:       `unsupported CAR version: "2", only version 1 is supported`,
+		},
+		{
+			name:               "unsupported query order",
+			query:              "?format=car&car-order=bfs",
+			expectedStatusCode: http.StatusBadRequest,
+			expectedBody:       `unsupported CAR order: "bfs", only dfs is supported`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+
+			request, err := http.NewRequest(http.MethodGet, testServer.URL+"/ipfs/"+dfsOrder[0].String()+tc.query, nil)
+			req.NoError(err)
+			if tc.accept != "" {
+				request.Header.Set("Accept", tc.accept)
+			}
+			res, err := http.DefaultClient.Do(request)
+			req.NoError(err)
+			req.Equal(tc.expectedStatusCode, res.StatusCode)
+
+			if tc.expectedStatusCode != http.StatusOK {
+				body, err := io.ReadAll(res.Body)
+				req.NoError(err)
+				req.Equal(tc.expectedBody, string(body))
+				return
+			}
+
+			req.Equal(tc.expectedContentType, res.Header.Get("Content-Type"))
+			carReader, err := car.NewBlockReader(res.Body)
+			req.NoError(err)
+			gotCids := make([]cid.Cid, 0)
+			for {
+				blk, err := carReader.Next()
+				if err != nil {
+					req.ErrorIs(err, io.EOF)
+					break
+				}
+				gotCids = append(gotCids, blk.Cid())
+			}
+			req.Equal(dfsOrder, gotCids)
+		})
+	}
+}
+
+func TestHttpIpfsCarContentTypeParams(t *testing.T) {
+	store := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	unixfsnode.AddUnixFSReificationToLinkSystem(&lsys)
+	root := mkUnixfsFile(t, lsys, []byte("content type params"))
+
+	handler := frisbii.NewHttpIpfs(context.Background(), lsys)
+	testServer := httptest.NewServer(handler)
+	defer testServer.Close()
+
+	for _, tc := range []struct {
+		name         string
+		query        string
+		accept       string
+		expectedDups string
+	}{
+		{
+			name:         "bare media type",
+			accept:       trustlesshttp.MimeTypeCar,
+			expectedDups: "y",
+		},
+		{
+			name:         "dups=y",
+			accept:       trustlesshttp.MimeTypeCar + ";version=1;order=dfs;dups=y",
+			expectedDups: "y",
+		},
+		{
+			name:         "dups=n",
+			accept:       trustlesshttp.MimeTypeCar + ";version=1;order=dfs;dups=n",
+			expectedDups: "n",
+		},
+		{
+			name:         "spaced parameters",
+			accept:       trustlesshttp.MimeTypeCar + "; version=1; dups=n",
+			expectedDups: "n",
+		},
+		{
+			name:         "order=unk",
+			accept:       trustlesshttp.MimeTypeCar + ";order=unk",
+			expectedDups: "y",
+		},
+		{
+			name:         "wildcard",
+			accept:       "*/*",
+			expectedDups: "y",
+		},
+		{
+			name:         "query car-dups=y",
+			query:        "?format=car&car-dups=y",
+			expectedDups: "y",
+		},
+		{
+			name:         "query car-dups=n",
+			query:        "?format=car&car-dups=n",
+			expectedDups: "n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+
+			request, err := http.NewRequest(http.MethodGet, testServer.URL+"/ipfs/"+root.String()+tc.query, nil)
+			req.NoError(err)
+			if tc.accept != "" {
+				request.Header.Set("Accept", tc.accept)
+			}
+			res, err := http.DefaultClient.Do(request)
+			req.NoError(err)
+			defer res.Body.Close()
+			req.Equal(http.StatusOK, res.StatusCode)
+
+			mediaType, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+			req.NoError(err)
+			req.Equal(trustlesshttp.MimeTypeCar, mediaType)
+			req.Equal(map[string]string{
+				"version": trustlesshttp.MimeTypeCarVersion,
+				"order":   string(trustlesshttp.ContentTypeOrderDfs),
+				"dups":    tc.expectedDups,
+			}, params)
+		})
+	}
+}
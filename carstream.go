@@ -3,6 +3,7 @@ package frisbii
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
 
 	// codecs we care about
@@ -21,6 +22,9 @@ import (
 	"github.com/ipld/go-ipld-prime/linking"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	trustlessutils "github.com/ipld/go-trustless-utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // StreamCar streams a DAG in CARv1 format to the given writer, using the given
@@ -31,36 +35,86 @@ func StreamCar(
 	out io.Writer,
 	request trustlessutils.Request,
 ) error {
+	return streamCar(ctx, requestLsys, out, request, datamodel.ParsePath(request.Path))
+}
+
+// streamCar is StreamCar, with the path within the DAG supplied separately
+// from the request, so that it may have segments that contain a "/".
+func streamCar(
+	ctx context.Context,
+	requestLsys linking.LinkSystem,
+	out io.Writer,
+	request trustlessutils.Request,
+	path datamodel.Path,
+) error {
+	ctx, span := tracer.Start(ctx, "StreamCar", trace.WithAttributes(
+		attribute.String("cid", request.Root.String()),
+		attribute.String("path", request.Path),
+		attribute.String("dag-scope", string(request.Scope)),
+		attribute.Bool("dups", request.Duplicates),
+	))
+	defer span.End()
+
 	carWriter := deferred.NewDeferredCarWriterForStream(out, []cid.Cid{request.Root}, car.AllowDuplicatePuts(request.Duplicates))
 	requestLsys.StorageReadOpener = carPipe(requestLsys.StorageReadOpener, carWriter)
 
-	cfg := traversal.Config{Root: request.Root, Selector: request.Selector()}
+	cfg := traversal.Config{Root: request.Root, Selector: requestSelector(request, path)}
 	lastPath, err := cfg.Traverse(ctx, requestLsys, nil)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return err
 	}
 
-	if err := traversal.CheckPath(datamodel.ParsePath(request.Path), lastPath); err != nil {
+	if err := traversal.CheckPath(path, lastPath); err != nil {
 		logger.Warn(err)
 	}
 
 	return nil
 }
 
+// TraversalError is returned from StreamCar when a block below the root of the
+// DAG can't be loaded, recording the CID of the block and the path, from the
+// root, of the link to it. It unwraps to the error from the underlying
+// storage.
+type TraversalError struct {
+	Cid  cid.Cid
+	Path datamodel.Path
+	Err  error
+}
+
+func (e *TraversalError) Error() string {
+	return fmt.Sprintf("failed to load block %s at path %q: %s", e.Cid, e.Path.String(), e.Err)
+}
+
+func (e *TraversalError) Unwrap() error {
+	return e.Err
+}
+
 func carPipe(orig linking.BlockReadOpener, car *deferred.DeferredCarWriter) linking.BlockReadOpener {
 	return func(lc linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+		c := lnk.(cidlink.Link).Cid
 		r, err := orig(lc, lnk)
 		if err != nil {
-			return nil, err
+			return nil, traversalError(c, lc.LinkPath, err)
 		}
 		byts, err := io.ReadAll(r)
 		if err != nil {
-			return nil, err
+			return nil, traversalError(c, lc.LinkPath, err)
 		}
-		err = car.Put(lc.Ctx, lnk.(cidlink.Link).Cid.KeyString(), byts)
+		err = car.Put(lc.Ctx, c.KeyString(), byts)
 		if err != nil {
 			return nil, err
 		}
 		return bytes.NewReader(byts), nil
 	}
 }
+
+// traversalError wraps err in a TraversalError, unless it's for the root block,
+// which the traversal already reports as such.
+func traversalError(c cid.Cid, path datamodel.Path, err error) error {
+	if path.Len() == 0 {
+		return err
+	}
+	return &TraversalError{Cid: c, Path: path, Err: err}
+}
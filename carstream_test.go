@@ -4,12 +4,14 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
+	"errors"
 	"io"
 	"testing"
 
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
 	gstestutil "github.com/ipfs/go-graphsync/testutil"
+	format "github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/go-unixfsnode"
 	unixfs "github.com/ipfs/go-unixfsnode/testutil"
 	"github.com/ipld/frisbii"
@@ -20,6 +22,7 @@ import (
 	"github.com/ipld/go-ipld-prime/storage/memstore"
 	trustlessutils "github.com/ipld/go-trustless-utils"
 	trustlesstestutil "github.com/ipld/go-trustless-utils/testutil"
+	"github.com/multiformats/go-multihash"
 	"github.com/stretchr/testify/require"
 )
 
@@ -225,3 +228,52 @@ func GenerateNoDupes(gen func() unixfs.DirEntry) unixfs.DirEntry {
 		}
 	}
 }
+
+func TestStreamCarMissingBlock(t *testing.T) {
+	req := require.New(t)
+	lsys := makeLsys()
+
+	missingHash, err := multihash.Sum([]byte("not stored"), multihash.SHA2_256, -1)
+	req.NoError(err)
+	missingCid := cid.NewCidV1(cid.Raw, missingHash)
+
+	fileLnk := mkUnixfsFile(t, lsys, []byte("hello world"))
+	subdirLnk := mkUnixfsDir(t, lsys, map[string]datamodel.Link{"missing.txt": cidlink.Link{Cid: missingCid}})
+	dirLnk := mkUnixfsDir(t, lsys, map[string]datamodel.Link{"hello.txt": fileLnk, "sub": subdirLnk})
+	dirCid := dirLnk.(cidlink.Link).Cid
+
+	for _, tc := range []struct {
+		name       string
+		path       string
+		scope      trustlessutils.DagScope
+		expectPath string
+	}{
+		{
+			// the whole DAG is traversed as plain dag-pb, so the path is through
+			// the data model
+			name:       "all",
+			scope:      trustlessutils.DagScopeAll,
+			expectPath: "Links/1/Hash/Links/0/Hash",
+		},
+		{
+			name:       "pathed",
+			path:       "sub/missing.txt",
+			scope:      trustlessutils.DagScopeBlock,
+			expectPath: "sub/missing.txt",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+			var buf bytes.Buffer
+			err := frisbii.StreamCar(context.Background(), lsys, &buf, trustlessutils.Request{Root: dirCid, Path: tc.path, Scope: tc.scope})
+			req.Error(err)
+
+			var te *frisbii.TraversalError
+			req.True(errors.As(err, &te))
+			req.Equal(missingCid, te.Cid)
+			req.Equal(tc.expectPath, te.Path.String())
+			req.Equal(`failed to load block `+missingCid.String()+` at path "`+tc.expectPath+`": `+format.ErrNotFound{Cid: missingCid}.Error(), te.Error())
+			req.True(format.IsNotFound(err))
+		})
+	}
+}
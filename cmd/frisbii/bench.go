@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/ipfs/go-cid"
+	util "github.com/ipld/frisbii/internal/util"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	"github.com/urfave/cli/v2"
+)
+
+var benchCommand = &cli.Command{
+	Name:  "bench",
+	Usage: "generate load against a running frisbii, or other Trustless Gateway, and report throughput and latency",
+	Description: "Repeatedly requests a CAR for --cid from --target with --concurrency parallel " +
+		"workers for --duration. Each response is verified to be a well-formed CAR with the " +
+		"expected root and correctly hashed blocks; any other response is counted as an error.",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "target",
+			Usage:    "base URL of the server to request from, e.g. http://localhost:3747",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "cid",
+			Usage:    "root CID to request",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "dag-scope",
+			Usage: "dag-scope of each request, one of [all,entity,block]",
+			Value: string(trustlessutils.DagScopeAll),
+		},
+		&cli.IntFlag{
+			Name:  "concurrency",
+			Usage: "number of requests to make in parallel",
+			Value: 8,
+		},
+		&cli.DurationFlag{
+			Name:  "duration",
+			Usage: "how long to generate load for",
+			Value: 30 * time.Second,
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "output the summary as JSON",
+		},
+	},
+	Action: benchAction,
+}
+
+func benchAction(c *cli.Context) error {
+	root, err := cid.Parse(c.String("cid"))
+	if err != nil {
+		return fmt.Errorf("invalid --cid: %w", err)
+	}
+	scope, err := trustlessutils.ParseDagScope(c.String("dag-scope"))
+	if err != nil {
+		return err
+	}
+
+	result, err := util.Bench(c.Context, util.BenchConfig{
+		Target:      c.String("target"),
+		Root:        root,
+		Scope:       scope,
+		Concurrency: c.Int("concurrency"),
+		Duration:    c.Duration("duration"),
+	})
+	if err != nil {
+		return err
+	}
+
+	if c.Bool("json") {
+		enc := json.NewEncoder(c.App.Writer)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	}
+	w := c.App.Writer
+	fmt.Fprintf(w, "Requests:    %d in %s (%.1f/s)\n", result.Requests, result.Elapsed.Round(time.Millisecond), result.RequestsPerS)
+	fmt.Fprintf(w, "Throughput:  %s/s (%s total)\n", humanize.IBytes(uint64(result.BytesPerS)), humanize.IBytes(uint64(result.Bytes)))
+	fmt.Fprintf(w, "Errors:      %d (%.2f%%)\n", result.Errors, result.ErrorRate*100)
+	fmt.Fprintf(w, "Latency:     p50 %s, p95 %s, p99 %s, max %s\n",
+		result.LatencyP50.Round(time.Microsecond),
+		result.LatencyP95.Round(time.Microsecond),
+		result.LatencyP99.Round(time.Microsecond),
+		result.LatencyMax.Round(time.Microsecond),
+	)
+	for _, ec := range result.ErrorsByClass {
+		fmt.Fprintf(w, "  %6d × %s\n", ec.Count, ec.Error)
+	}
+	return nil
+}
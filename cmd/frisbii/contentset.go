@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipld/frisbii"
+	util "github.com/ipld/frisbii/internal/util"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	provider "github.com/ipni/index-provider"
+	"github.com/ipni/index-provider/engine"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// contentSet is a set of CARs that are served and announced together, either
+// under /ipfs/ or under a path prefix.
+type contentSet struct {
+	prefix     string // "" for the content served under /ipfs/
+	publicAddr string // the address a prefix is announced with
+	cars       carSet
+	multicar   *frisbii.MultiReadableStorage
+	mhCodes    []uint64      // the multihash codes that are announced, all if empty
+	ttl        time.Duration // how long each announcement is valid for, no expiry if 0
+
+	lk        sync.Mutex
+	roots     []cid.Cid // the roots that may be served and announced
+	announced []byte    // digest of the multihashes last announced, nil if never announced
+}
+
+func newContentSet(prefix string, publicAddr string, mhCodes []uint64, ttl time.Duration) *contentSet {
+	return &contentSet{prefix: prefix, publicAddr: publicAddr, multicar: frisbii.NewMultiReadableStorage(), mhCodes: mhCodes, ttl: ttl}
+}
+
+// newContentSets returns the set of the content served under /ipfs/, from the
+// CARs at rootCars, followed by a set for each of prefixes, along with the paths
+// of the CARs of each set.
+func newContentSets(rootCars []string, prefixes []util.Prefix, mhCodes []uint64, ttl time.Duration) ([]*contentSet, map[*contentSet][]string) {
+	rootSet := newContentSet("", "", mhCodes, ttl)
+	sets := []*contentSet{rootSet}
+	carPaths := map[*contentSet][]string{rootSet: rootCars}
+	for _, prefix := range prefixes {
+		cs := newContentSet(prefix.Name, prefix.PublicAddr, mhCodes, ttl)
+		sets = append(sets, cs)
+		carPaths[cs] = prefix.Cars
+	}
+	return sets, carPaths
+}
+
+// countCars returns the number of CARs across each of the sets of carPaths.
+func countCars(carPaths map[*contentSet][]string) int {
+	var count int
+	for _, paths := range carPaths {
+		count += len(paths)
+	}
+	return count
+}
+
+// loadContentSets loads each of sets from its carPaths, see contentSet.load,
+// reporting the number of CARs loaded across all of them to progress, and warns
+// where some of servableRoots aren't in any of them.
+func loadContentSets(sets []*contentSet, carPaths map[*contentSet][]string, servableRoots []cid.Cid, denylist []frisbii.DenylistEntry, progress func(loaded int)) error {
+	var loaded int
+	for _, cs := range sets {
+		previous := loaded
+		if _, _, _, err := cs.load(carPaths[cs], servableRoots, denylist, func(l int) {
+			progress(previous + l)
+		}); err != nil {
+			return err
+		}
+		loaded += len(carPaths[cs])
+	}
+	warnMissingServableRoots(sets, servableRoots)
+	return nil
+}
+
+func (cs *contentSet) name() string {
+	if cs.prefix == "" {
+		return "/ipfs/"
+	}
+	return "/" + cs.prefix + "/ipfs/"
+}
+
+func (cs *contentSet) contextID() []byte {
+	if cs.prefix == "" {
+		return []byte(frisbii.ContextID)
+	}
+	return frisbii.PrefixContextID(cs.prefix)
+}
+
+func (cs *contentSet) linkSystem() linking.LinkSystem {
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.TrustedStorage = true
+	unixfsnode.AddUnixFSReificationToLinkSystem(&lsys)
+	lsys.SetReadStorage(cs.multicar)
+	return lsys
+}
+
+// httpOptions returns the options that are specific to the content of the set.
+func (cs *contentSet) httpOptions() []frisbii.HttpOption {
+	return []frisbii.HttpOption{
+		// content is immutable, so the most recent CAR modification time is a
+		// stable Last-Modified value for everything we serve
+		frisbii.WithLastModified(cs.cars.lastModified()),
+		frisbii.WithPresenceCheck(cs.multicar),
+	}
+}
+
+// load opens the CARs at carPaths, reusing those already open, and serves them
+// in place of the set's current CARs. The CARs that are no longer served are
+// returned for the caller to close, along with whether the set of CARs, and the
+// roots that may be served, have changed. Roots that are wholly denied by
+// denylist are not served. Where there is an error, the set is left unchanged.
+func (cs *contentSet) load(carPaths []string, servableRoots []cid.Cid, denylist []frisbii.DenylistEntry, progress func(loaded int)) (dropped []*util.Car, carsChanged bool, rootsChanged bool, err error) {
+	loaded, dropped, carsChanged, err := cs.cars.load(carPaths, progress)
+	if err != nil {
+		return nil, false, false, err
+	}
+	cs.multicar.ReplaceStores(loaded)
+	roots := loaded.Roots()
+	if servableRoots != nil {
+		roots = util.FilterRoots(roots, servableRoots)
+	}
+	if denylist != nil {
+		roots = util.ExcludeDeniedRoots(roots, denylist)
+	}
+	cs.lk.Lock()
+	defer cs.lk.Unlock()
+	rootsChanged = !sameRoots(cs.roots, roots)
+	cs.roots = roots
+	return dropped, carsChanged, rootsChanged, nil
+}
+
+// servedRoots returns the roots of the set that may be served and announced.
+func (cs *contentSet) servedRoots() []cid.Cid {
+	cs.lk.Lock()
+	defer cs.lk.Unlock()
+	return cs.roots
+}
+
+// announce announces the roots of the set to the indexer. The content under
+// /ipfs/ is announced with the engine's own addresses, and the content of a
+// prefix with its public address.
+func (cs *contentSet) announce(ctx context.Context, eng *engine.Engine, id peer.ID, serverAddr string) (cid.Cid, error) {
+	included, filtered := util.AnnouncedMultihashes(cs.servedRoots(), cs.mhCodes)
+	if filtered > 0 {
+		logger.Infof("Announcing %d multihashes of %s, %d excluded by multihash code are served but not announced", len(included), cs.name(), filtered)
+	} else {
+		logger.Infof("Announcing %d multihashes of %s", len(included), cs.name())
+	}
+	var opts []frisbii.AnnounceOption
+	if cs.ttl > 0 {
+		opts = append(opts, frisbii.WithExpiry(time.Now().Add(cs.ttl)))
+	}
+	var adCid cid.Cid
+	if cs.prefix == "" {
+		var err error
+		if adCid, err = frisbii.NotifyPut(ctx, eng, opts...); err != nil {
+			return cid.Undef, err
+		}
+	} else {
+		listenAddr, err := util.GetListenAddr(serverAddr, cs.publicAddr)
+		if err != nil {
+			return cid.Undef, err
+		}
+		if adCid, err = frisbii.NotifyPutPrefix(ctx, eng, cs.prefix, &peer.AddrInfo{ID: id, Addrs: []multiaddr.Multiaddr{listenAddr.Maddr}}, opts...); err != nil {
+			return cid.Undef, err
+		}
+	}
+	cs.setAnnounced(util.AnnouncementDigest(included))
+	return adCid, nil
+}
+
+// announceChanged returns true if the multihashes that would be announced for
+// the set differ from those of its last successful announcement.
+func (cs *contentSet) announceChanged() bool {
+	included, _ := util.AnnouncedMultihashes(cs.servedRoots(), cs.mhCodes)
+	digest := util.AnnouncementDigest(included)
+	cs.lk.Lock()
+	defer cs.lk.Unlock()
+	return cs.announced == nil || !bytes.Equal(cs.announced, digest)
+}
+
+func (cs *contentSet) setAnnounced(digest []byte) {
+	cs.lk.Lock()
+	defer cs.lk.Unlock()
+	cs.announced = digest
+}
+
+// reannounce replaces the set's previous announcement, if any, with one for
+// its current roots. An advertisement can't be updated, so the previous one is
+// removed and a new one put, which lists the current roots.
+func (cs *contentSet) reannounce(ctx context.Context, eng *engine.Engine, id peer.ID, serverAddr string) error {
+	if _, err := eng.NotifyRemove(ctx, "", cs.contextID()); err != nil && !errors.Is(err, provider.ErrContextIDNotFound) {
+		return fmt.Errorf("failed to remove previous announcement of %s: %w", cs.name(), err)
+	}
+	if len(cs.servedRoots()) == 0 {
+		cs.setAnnounced(util.AnnouncementDigest(nil))
+		return nil
+	}
+	if _, err := cs.announce(ctx, eng, id, serverAddr); err != nil {
+		return fmt.Errorf("failed to announce %s: %w", cs.name(), err)
+	}
+	return nil
+}
+
+// needsReannounce returns true if cs should be re-announced on reload, where
+// rootsChanged is whether its roots changed with the reload. --force-announce
+// re-announces every set. With --announce-on-change-only, the multihashes that
+// would be announced are compared with those of the last successful
+// announcement rather than the previous load, so roots that changed only in
+// ways that aren't announced, or that were changed then reverted between
+// reloads, aren't re-announced, while announcements that failed are retried.
+func needsReannounce(cs *contentSet, config Config, rootsChanged bool) bool {
+	switch {
+	case config.ForceAnnounce:
+		return true
+	case config.AnnounceChangedOnly:
+		return cs.announceChanged()
+	default:
+		return rootsChanged
+	}
+}
+
+// announcer announces content sets to the indexer with eng. The extended
+// providers, where there are any, apply to the context ID of the content under
+// /ipfs/, so they are published again after each announcement of it, which
+// would otherwise leave the indexer with an advertisement for the context ID
+// that doesn't list them.
+type announcer struct {
+	eng               *engine.Engine
+	privKey           crypto.PrivKey
+	id                peer.ID
+	maddr             multiaddr.Multiaddr // the address the content under /ipfs/ is announced with
+	serverAddr        string
+	extendedProviders *util.ExtendedProviders
+}
+
+// announce announces cs, returning the CID of the latest advertisement, which
+// is that of the extended providers where they're published after it.
+func (a *announcer) announce(ctx context.Context, cs *contentSet) (cid.Cid, error) {
+	adCid, err := cs.announce(ctx, a.eng, a.id, a.serverAddr)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if cs.prefix != "" {
+		logger.Infof("Announced %s as %s in %s", cs.name(), cs.publicAddr, adCid)
+	}
+	return a.publishExtendedProviders(ctx, cs, adCid)
+}
+
+// reannounce replaces the previous announcement of cs, see
+// contentSet.reannounce.
+func (a *announcer) reannounce(ctx context.Context, cs *contentSet) error {
+	if err := cs.reannounce(ctx, a.eng, a.id, a.serverAddr); err != nil {
+		return err
+	}
+	if len(cs.servedRoots()) == 0 {
+		// removed, so there's nothing for the extended providers to apply to
+		return nil
+	}
+	if _, err := a.publishExtendedProviders(ctx, cs, cid.Undef); err != nil {
+		return fmt.Errorf("failed to announce extended providers of %s: %w", cs.name(), err)
+	}
+	return nil
+}
+
+// announceSets announces each of sets in turn, the content under /ipfs/ where
+// there are CARs for it, then that of the prefixes, returning the CID of the
+// latest advertisement.
+func (a *announcer) announceSets(ctx context.Context, sets []*contentSet) (cid.Cid, error) {
+	head := cid.Undef
+	for _, cs := range sets {
+		if cs.prefix == "" && len(cs.cars.paths()) == 0 {
+			continue
+		}
+		var err error
+		if head, err = a.announce(ctx, cs); err != nil {
+			return cid.Undef, err
+		}
+	}
+	return head, nil
+}
+
+// refresh publishes a new advertisement, with a new expiry, for each of sets
+// that has roots to announce. A set whose roots have changed since it was last
+// announced, e.g. as its announcement on reload failed, is re-announced in
+// full so that the advertisement doesn't refresh stale entries.
+func (a *announcer) refresh(ctx context.Context, sets []*contentSet) {
+	for _, cs := range sets {
+		if len(cs.servedRoots()) == 0 {
+			continue
+		}
+		if cs.announceChanged() {
+			if err := a.reannounce(ctx, cs); err != nil {
+				logger.Warnf("Failed to refresh announcement: %s", err)
+			}
+			continue
+		}
+		if adCid, err := a.announce(ctx, cs); err != nil {
+			logger.Warnf("Failed to refresh announcement of %s: %s", cs.name(), err)
+		} else {
+			logger.Infof("Refreshed announcement of %s in %s", cs.name(), adCid)
+		}
+	}
+}
+
+// publishExtendedProviders publishes the extended providers after an
+// announcement, adCid, of cs, where they apply to it, returning the CID of the
+// latest advertisement.
+func (a *announcer) publishExtendedProviders(ctx context.Context, cs *contentSet, adCid cid.Cid) (cid.Cid, error) {
+	if a.extendedProviders == nil || cs.prefix != "" {
+		return adCid, nil
+	}
+	xpCid, err := util.PublishExtendedProviders(ctx, a.eng, a.privKey, a.maddr, a.extendedProviders)
+	if err != nil {
+		return cid.Undef, err
+	}
+	logger.Infof("Announced %d extended providers in %s", len(a.extendedProviders.Providers), xpCid)
+	return xpCid, nil
+}
+
+// contentSetsLister returns a MultihashLister for the roots of each of sets,
+// by the context ID they are announced with.
+func contentSetsLister(sets []*contentSet) provider.MultihashLister {
+	byContextID := make(map[string]*contentSet, len(sets))
+	for _, cs := range sets {
+		byContextID[string(cs.contextID())] = cs
+	}
+	return func(ctx context.Context, p peer.ID, contextID []byte) (provider.MultihashIterator, error) {
+		cs, ok := byContextID[string(contextID)]
+		if !ok {
+			return nil, provider.ErrContextIDNotFound
+		}
+		return util.RootsLister(cs.servedRoots(), cs.mhCodes)(ctx, p, contextID)
+	}
+}
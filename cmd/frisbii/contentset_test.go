@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/frisbii"
+	util "github.com/ipld/frisbii/internal/util"
+	"github.com/ipld/go-car/v2/storage"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/ipni/index-provider/engine/xproviders"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnnouncerExtendedProviders(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	xpPrivKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	req.NoError(err)
+	xpID, err := peer.IDFromPrivateKey(xpPrivKey)
+	req.NoError(err)
+	xpAddr, err := multiaddr.NewMultiaddr("/dns/mirror.example.com/tcp/443/https")
+	req.NoError(err)
+	xp := &util.ExtendedProviders{Providers: []xproviders.Info{xproviders.NewInfo(xpID, xpPrivKey, nil, []multiaddr.Multiaddr{xpAddr})}}
+
+	// refreshes only happen with a TTL, to renew the expiry
+	rootSet := newContentSet("", "", nil, time.Hour)
+	prefixSet := newContentSet("mirror", "https://mirror.example.com:443", nil, 0)
+	sets := []*contentSet{rootSet, prefixSet}
+	a := writeContentSetCar(t, dir, "a")
+	b := writeContentSetCar(t, dir, "b")
+	p := writeContentSetCar(t, dir, "p")
+	_, _, _, err = rootSet.load([]string{a}, nil, nil, func(int) {})
+	req.NoError(err)
+	_, _, _, err = prefixSet.load([]string{p}, nil, nil, func(int) {})
+	req.NoError(err)
+
+	ann := newTestAnnouncer(t, sets, xp)
+	eng, id := ann.eng, ann.id
+
+	// requireExtendedProviders checks that the latest advertisement lists the
+	// extended providers for the content under /ipfs/, following a put of it
+	requireExtendedProviders := func(t *testing.T) {
+		req := require.New(t)
+		adCid, ad, err := eng.GetLatestAdv(ctx)
+		req.NoError(err)
+		req.NotNil(ad.ExtendedProvider, "latest advertisement %s is not of the extended providers", adCid)
+		req.Equal([]byte(frisbii.ContextID), ad.ContextID)
+		req.Len(ad.ExtendedProvider.Providers, 2)
+		var ids []string
+		for _, p := range ad.ExtendedProvider.Providers {
+			ids = append(ids, p.ID)
+		}
+		req.ElementsMatch([]string{id.String(), xpID.String()}, ids)
+		prev, err := eng.GetAdv(ctx, ad.PreviousID.(cidlink.Link).Cid)
+		req.NoError(err)
+		req.Nil(prev.ExtendedProvider)
+		req.Equal([]byte(frisbii.ContextID), prev.ContextID)
+		req.False(prev.IsRm)
+		req.NotEqual(schema.NoEntries, prev.Entries)
+	}
+
+	// the prefix is announced after the content under /ipfs/ and its extended
+	// providers
+	head, err := ann.announceSets(ctx, sets)
+	req.NoError(err)
+	latest, ad, err := eng.GetLatestAdv(ctx)
+	req.NoError(err)
+	req.Equal(head, latest)
+	req.Equal(prefixSet.contextID(), ad.ContextID)
+	prev, err := eng.GetAdv(ctx, ad.PreviousID.(cidlink.Link).Cid)
+	req.NoError(err)
+	req.NotNil(prev.ExtendedProvider)
+
+	t.Run("reannounce", func(t *testing.T) {
+		req := require.New(t)
+		_, _, rootsChanged, err := rootSet.load([]string{b}, nil, nil, func(int) {})
+		req.NoError(err)
+		req.True(rootsChanged)
+		req.NoError(ann.reannounce(ctx, rootSet))
+		requireExtendedProviders(t)
+	})
+
+	t.Run("refresh", func(t *testing.T) {
+		req := require.New(t)
+		before, _, err := eng.GetLatestAdv(ctx)
+		req.NoError(err)
+		// the expiry is to the second, so it's pushed out for the refresh to
+		// differ from the announcement just made
+		rootSet.ttl = 2 * time.Hour
+		ann.refresh(ctx, []*contentSet{rootSet})
+		after, _, err := eng.GetLatestAdv(ctx)
+		req.NoError(err)
+		req.NotEqual(before, after)
+		requireExtendedProviders(t)
+	})
+
+	t.Run("prefix reannounce", func(t *testing.T) {
+		// extended providers don't apply to the prefixes
+		req := require.New(t)
+		before, _, err := eng.GetLatestAdv(ctx)
+		req.NoError(err)
+		_, _, _, err = prefixSet.load([]string{a}, nil, nil, func(int) {})
+		req.NoError(err)
+		req.NoError(ann.reannounce(ctx, prefixSet))
+		_, ad, err := eng.GetLatestAdv(ctx)
+		req.NoError(err)
+		req.Nil(ad.ExtendedProvider)
+		req.Equal(prefixSet.contextID(), ad.ContextID)
+		req.NotEqual(before, ad.PreviousID.(cidlink.Link).Cid)
+	})
+}
+
+func TestNeedsReannounce(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	cs := newContentSet("", "", nil, 0)
+	a := writeContentSetCar(t, dir, "a")
+	b := writeContentSetCar(t, dir, "b")
+	_, _, _, err := cs.load([]string{a}, nil, nil, func(int) {})
+	req.NoError(err)
+	ann := newTestAnnouncer(t, []*contentSet{cs}, nil)
+	announced, err := ann.announceSets(ctx, []*contentSet{cs})
+	req.NoError(err)
+
+	var changedOnly, force, changedOnlyForce Config
+	changedOnly.AnnounceChangedOnly = true
+	force.ForceAnnounce = true
+	changedOnlyForce.AnnounceChangedOnly, changedOnlyForce.ForceAnnounce = true, true
+
+	// reload and re-announce as frisbii does, returning whether it did
+	reload := func(carPath string, config Config) (rootsChanged bool, reannounced bool) {
+		_, _, rootsChanged, err := cs.load([]string{carPath}, nil, nil, func(int) {})
+		req.NoError(err)
+		before, _, err := ann.eng.GetLatestAdv(ctx)
+		req.NoError(err)
+		if needsReannounce(cs, config, rootsChanged) {
+			req.NoError(ann.reannounce(ctx, cs))
+		}
+		after, _, err := ann.eng.GetLatestAdv(ctx)
+		req.NoError(err)
+		return rootsChanged, before != after
+	}
+
+	// nothing changed
+	for _, config := range []Config{{}, changedOnly} {
+		rootsChanged, reannounced := reload(a, config)
+		req.False(rootsChanged)
+		req.False(reannounced)
+	}
+
+	// modified then reverted, where the reload of the modification, e.g. of a
+	// CAR that was being replaced, wasn't announced
+	_, _, rootsChanged, err := cs.load([]string{b}, nil, nil, func(int) {})
+	req.NoError(err)
+	req.True(rootsChanged)
+	req.True(needsReannounce(cs, changedOnly, rootsChanged))
+	rootsChanged, reannounced := reload(a, changedOnly)
+	req.True(rootsChanged)
+	req.False(reannounced)
+	latest, _, err := ann.eng.GetLatestAdv(ctx)
+	req.NoError(err)
+	req.Equal(announced, latest)
+
+	// without --announce-on-change-only, the revert is a change of roots
+	_, _, _, err = cs.load([]string{b}, nil, nil, func(int) {})
+	req.NoError(err)
+	rootsChanged, reannounced = reload(a, Config{})
+	req.True(rootsChanged)
+	req.True(reannounced)
+
+	// --force-announce re-announces regardless
+	for _, config := range []Config{force, changedOnlyForce} {
+		rootsChanged, reannounced := reload(a, config)
+		req.False(rootsChanged)
+		req.True(reannounced)
+	}
+
+	// a modification is re-announced with --announce-on-change-only
+	rootsChanged, reannounced = reload(b, changedOnly)
+	req.True(rootsChanged)
+	req.True(reannounced)
+	req.False(cs.announceChanged())
+}
+
+// newTestAnnouncer returns an announcer with a started engine, for sets, that
+// doesn't announce to an indexer.
+func newTestAnnouncer(t *testing.T, sets []*contentSet, xp *util.ExtendedProviders) *announcer {
+	req := require.New(t)
+	privKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	req.NoError(err)
+	id, err := peer.IDFromPrivateKey(privKey)
+	req.NoError(err)
+	maddr, err := multiaddr.NewMultiaddr("/dns/example.com/tcp/3747/http")
+	req.NoError(err)
+	eng, err := util.NewEngine(privKey, maddr, "/ipni/", "", util.DefaultEntryChunkSize)
+	req.NoError(err)
+	eng.RegisterMultihashLister(contentSetsLister(sets))
+	req.NoError(eng.Start(context.Background()))
+	t.Cleanup(func() { _ = eng.Shutdown() })
+	return &announcer{eng: eng, privKey: privKey, id: id, maddr: maddr, serverAddr: "127.0.0.1:3747", extendedProviders: xp}
+}
+
+// writeContentSetCar writes a CAR with a single raw block, data, as its root,
+// returning its path.
+func writeContentSetCar(t *testing.T, dir string, data string) string {
+	req := require.New(t)
+	c, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: 0x12, MhLength: -1}.Sum([]byte(data))
+	req.NoError(err)
+	carPath := filepath.Join(dir, data+".car")
+	carFile, err := os.Create(carPath)
+	req.NoError(err)
+	carWriter, err := storage.NewWritable(carFile, []cid.Cid{c})
+	req.NoError(err)
+	req.NoError(carWriter.Put(context.Background(), c.KeyString(), []byte(data)))
+	req.NoError(carWriter.Finalize())
+	req.NoError(carFile.Close())
+	return carPath
+}
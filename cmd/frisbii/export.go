@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/frisbii"
+	util "github.com/ipld/frisbii/internal/util"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/multierr"
+)
+
+var announceExportCommand = &cli.Command{
+	Name:  "announce-export",
+	Usage: "write the advertisement that would be announced to the indexer to a file, without contacting the indexer",
+	Description: "Constructs the same advertisement, and entries, that frisbii would announce " +
+		"for the given CARs and prefixes, for side-loading into an indexer that frisbii can't reach. The " +
+		"output is a CAR of the advertisement chain and entries, or just the advertisement " +
+		"block if --out doesn't have a .car extension. The advertisement CID is printed to stdout.",
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "car",
+			Usage: "path(s) to CAR file(s) that will be served, can be a glob",
+		},
+		&cli.StringFlag{
+			Name:  "prefixes",
+			Usage: "path to a JSON file describing additional sets of CAR files that will be served under path prefixes, as with frisbii's --prefixes; each is advertised with its publicAddr",
+		},
+		&cli.StringFlag{
+			Name:     "out",
+			Usage:    "path to write the advertisement to, as a CAR if it has a .car extension, otherwise as a DAG-JSON advertisement block",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "listen",
+			Usage: "hostname and port frisbii will listen on",
+		},
+		&cli.StringFlag{
+			Name:  "public-addr",
+			Usage: "multiaddr or URL of the frisbii server as seen by the indexer and other peers if it is different to the listen address",
+		},
+		&cli.StringSliceFlag{
+			Name:        "announce-mh-codecs",
+			Usage:       "multihash functions, by name or code, e.g. sha2-256 or 0x12, to include in the advertisement",
+			DefaultText: "all",
+		},
+		&cli.DurationFlag{
+			Name:  "announce-ttl",
+			Usage: "how long after the announcement the indexer should consider the records valid, as with frisbii's --announce-ttl; the expiry is from when the advertisement is exported (use 0 for no expiry)",
+		},
+		&cli.IntFlag{
+			Name:  "announce-entry-chunk-size",
+			Usage: "maximum number of multihashes in each entries block of the advertisement, as with frisbii's --announce-entry-chunk-size",
+			Value: util.DefaultEntryChunkSize,
+		},
+		&cli.StringFlag{
+			Name:  "extended-providers",
+			Usage: "path to a JSON file describing sibling providers that announced content is also retrievable from",
+		},
+		&cli.StringFlag{
+			Name:  "servable-roots",
+			Usage: "path to a file listing the root CIDs that will be served, only these roots are advertised",
+		},
+		&cli.StringFlag{
+			Name:  "denylist",
+			Usage: "path to a file listing the CIDs that will not be served, these roots are not advertised",
+		},
+		&cli.StringFlag{
+			Name:  "base-path",
+			Usage: "the URL path frisbii will serve all content under, as with frisbii's --base-path",
+		},
+		&cli.BoolFlag{
+			Name:  "base-path-proxied",
+			Usage: "the reverse proxy in front of frisbii adds --base-path to retrievals, as with frisbii's --base-path-proxied",
+		},
+		&cli.StringFlag{
+			Name:  "ipni-path",
+			Usage: "the local path frisbii will serve IPNI content from",
+			Value: IndexerHandlerPath,
+		},
+	},
+	Action: announceExportAction,
+}
+
+func announceExportAction(c *cli.Context) error {
+	ctx := c.Context
+
+	carPaths := make([]string, 0)
+	for _, car := range c.StringSlice("car") {
+		matches, err := filepath.Glob(car)
+		if err != nil {
+			return err
+		}
+		carPaths = append(carPaths, matches...)
+	}
+	var prefixes []util.Prefix
+	if c.String("prefixes") != "" {
+		var err error
+		if prefixes, err = util.LoadPrefixes(c.String("prefixes")); err != nil {
+			return err
+		}
+		if err := validatePrefixAnnounce(prefixes); err != nil {
+			return err
+		}
+	}
+	if len(carPaths) == 0 && len(prefixes) == 0 {
+		return errors.New("must specify at least one CAR file")
+	}
+
+	if c.String("listen") == "" && c.String("public-addr") == "" {
+		return errors.New("must specify the address frisbii will be available at with --public-addr or --listen")
+	}
+	listenAddr, err := util.GetListenAddr(c.String("listen"), c.String("public-addr"))
+	if err != nil {
+		return err
+	}
+	if listenAddr.Unspecified {
+		return fmt.Errorf("cannot announce with unspecified listen address, use --public-addr or --listen to specify one")
+	}
+
+	if err := validateBasePathAnnounce(frisbii.CleanBasePath(c.String("base-path")), true, c.Bool("base-path-proxied")); err != nil {
+		return err
+	}
+
+	var extendedProviders *util.ExtendedProviders
+	if c.String("extended-providers") != "" {
+		if extendedProviders, err = util.LoadExtendedProviders(c.String("extended-providers")); err != nil {
+			return err
+		}
+	}
+
+	mhCodes, err := util.ParseMultihashCodes(c.StringSlice("announce-mh-codecs"))
+	if err != nil {
+		return err
+	}
+
+	var servableRoots []cid.Cid
+	if c.String("servable-roots") != "" {
+		if servableRoots, err = util.LoadServableRoots(c.String("servable-roots")); err != nil {
+			return err
+		}
+	}
+
+	var denylist []frisbii.DenylistEntry
+	if c.String("denylist") != "" {
+		if denylist, err = util.LoadDenylist(c.String("denylist")); err != nil {
+			return err
+		}
+	}
+
+	announceTTL := c.Duration("announce-ttl")
+	if announceTTL < 0 {
+		return errors.New("invalid announce-ttl parameter, must be 0 or greater")
+	}
+
+	// the sets that frisbii will serve, loaded as it loads them
+	sets, setCarPaths := newContentSets(carPaths, prefixes, mhCodes, announceTTL)
+	if err := loadContentSets(sets, setCarPaths, servableRoots, denylist, func(int) {}); err != nil {
+		return err
+	}
+
+	confDir, err := util.ConfigDir()
+	if err != nil {
+		return err
+	}
+	privKey, id, err := util.LoadPrivKey(confDir)
+	if err != nil {
+		return err
+	}
+	logger.Infof("PeerID: %s", id.String())
+
+	// no announce URL, so nothing leaves this process
+	engine, err := util.NewEngine(privKey, listenAddr.Maddr, frisbii.CleanBasePath(c.String("base-path"))+c.String("ipni-path"), "", c.Int("announce-entry-chunk-size"))
+	if err != nil {
+		return err
+	}
+	engine.RegisterMultihashLister(contentSetsLister(sets))
+	if err := engine.Start(ctx); err != nil {
+		return err
+	}
+	defer engine.Shutdown()
+
+	// the same announcements that frisbii makes on startup
+	ann := &announcer{
+		eng:               engine,
+		privKey:           privKey,
+		id:                id,
+		maddr:             listenAddr.Maddr,
+		serverAddr:        c.String("listen"),
+		extendedProviders: extendedProviders,
+	}
+	head, err := ann.announceSets(ctx, sets)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(c.String("out"))
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(c.String("out")), ".car") {
+		err = util.ExportAdvertisementsCar(ctx, engine, head, out)
+	} else {
+		err = util.ExportAdvertisementDagJson(ctx, engine, head, out)
+	}
+	if err = multierr.Append(err, out.Close()); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(c.App.Writer, head.String())
+	return nil
+}
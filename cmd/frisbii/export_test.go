@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/frisbii"
+	util "github.com/ipld/frisbii/internal/util"
+	"github.com/ipld/go-car/v2"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+// runExport runs announce-export with args, writing a CAR of the advertisement
+// chain, and returns the advertisements of the chain from its head, along with
+// a LinkSystem to load their entries from.
+func runExport(t *testing.T, args ...string) ([]*schema.Advertisement, linking.LinkSystem) {
+	req := require.New(t)
+
+	privKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	req.NoError(err)
+	data, err := crypto.MarshalPrivateKey(privKey)
+	req.NoError(err)
+	t.Setenv(util.PrivateKeyEnvVar, base64.StdEncoding.EncodeToString(data))
+
+	outPath := filepath.Join(t.TempDir(), "ads.car")
+	var stdout bytes.Buffer
+	app := &cli.App{Writer: &stdout, Commands: []*cli.Command{announceExportCommand}}
+	req.NoError(app.Run(append([]string{"frisbii", "announce-export", "--out", outPath}, args...)))
+	head, err := cid.Decode(strings.TrimSpace(stdout.String()))
+	req.NoError(err)
+
+	outFile, err := os.Open(outPath)
+	req.NoError(err)
+	defer outFile.Close()
+	carReader, err := car.NewBlockReader(outFile)
+	req.NoError(err)
+	req.Equal([]cid.Cid{head}, carReader.Roots)
+	store := &memstore.Store{}
+	for {
+		blk, err := carReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		req.NoError(err)
+		req.NoError(store.Put(context.Background(), blk.Cid().KeyString(), blk.RawData()))
+	}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+
+	var ads []*schema.Advertisement
+	for next := head; next != cid.Undef; {
+		n, err := lsys.Load(linking.LinkContext{}, cidlink.Link{Cid: next}, schema.AdvertisementPrototype)
+		req.NoError(err)
+		ad, err := schema.UnwrapAdvertisement(n)
+		req.NoError(err)
+		ads = append(ads, ad)
+		next = cid.Undef
+		if ad.PreviousID != nil {
+			next = ad.PreviousID.(cidlink.Link).Cid
+		}
+	}
+	return ads, lsys
+}
+
+func TestAnnounceExportTTL(t *testing.T) {
+	carPath := writeContentSetCar(t, t.TempDir(), "a")
+
+	ads, _ := runExport(t, "--car", carPath, "--listen", "127.0.0.1:3747")
+	require.Len(t, ads, 1)
+	md := metadata.Default.New()
+	require.NoError(t, md.UnmarshalBinary(ads[0].Metadata))
+	require.True(t, md.Equal(frisbii.AdvertisementMetadata()))
+
+	before := time.Now().Truncate(time.Second)
+	ads, _ = runExport(t, "--car", carPath, "--listen", "127.0.0.1:3747", "--announce-ttl", "24h")
+	require.Len(t, ads, 1)
+	require.Equal(t, []byte(frisbii.ContextID), ads[0].ContextID)
+	md = metadata.Default.New()
+	require.NoError(t, md.UnmarshalBinary(ads[0].Metadata))
+	expiry, ok := frisbii.AdvertisementExpiry(md)
+	require.True(t, ok)
+	require.False(t, expiry.Before(before.Add(24*time.Hour)))
+	require.False(t, expiry.After(time.Now().Add(24*time.Hour)))
+}
+
+func TestAnnounceExportPrefixes(t *testing.T) {
+	req := require.New(t)
+	dir := t.TempDir()
+	// the same root in two CARs is advertised once
+	a := writeContentSetCar(t, dir, "a")
+	sharedDir := filepath.Join(dir, "shared")
+	req.NoError(os.Mkdir(sharedDir, 0o755))
+	shared := writeContentSetCar(t, sharedDir, "a")
+	p := writeContentSetCar(t, dir, "p")
+
+	writePrefixes := func(publicAddr string) string {
+		prefixesPath := filepath.Join(t.TempDir(), "prefixes.json")
+		data, err := json.Marshal(util.PrefixesConfig{Prefixes: []util.PrefixConfig{{Name: "tenant-a", Cars: []string{p}, PublicAddr: publicAddr}}})
+		req.NoError(err)
+		req.NoError(os.WriteFile(prefixesPath, data, 0o644))
+		return prefixesPath
+	}
+
+	ads, lsys := runExport(t, "--car", a, "--car", shared, "--prefixes", writePrefixes("https://tenant-a.example.com:443"), "--listen", "127.0.0.1:3747")
+	req.Len(ads, 2)
+	// as frisbii announces on startup, the content under /ipfs/ then the prefix
+	req.Equal(frisbii.PrefixContextID("tenant-a"), ads[0].ContextID)
+	req.Equal(frisbii.ContextID, string(ads[1].ContextID))
+	req.Contains(ads[0].Addresses, "/dns/tenant-a.example.com/tcp/443/https")
+	for ii, expected := range []string{"p", "a"} {
+		n, err := lsys.Load(linking.LinkContext{}, ads[ii].Entries, schema.EntryChunkPrototype)
+		req.NoError(err)
+		chunk, err := schema.UnwrapEntryChunk(n)
+		req.NoError(err)
+		req.Len(chunk.Entries, 1)
+		c, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: 0x12, MhLength: -1}.Sum([]byte(expected))
+		req.NoError(err)
+		req.Equal(c.Hash(), chunk.Entries[0])
+	}
+
+	// only a prefix of CARs
+	ads, _ = runExport(t, "--prefixes", writePrefixes("https://tenant-a.example.com:443"), "--listen", "127.0.0.1:3747")
+	req.Len(ads, 1)
+	req.Equal(frisbii.PrefixContextID("tenant-a"), ads[0].ContextID)
+
+	// as frisbii, a prefix can't be announced without a publicAddr
+	app := &cli.App{Writer: io.Discard, Commands: []*cli.Command{announceExportCommand}}
+	err := app.Run([]string{"frisbii", "announce-export", "--out", filepath.Join(t.TempDir(), "ads.car"), "--car", a, "--prefixes", writePrefixes(""), "--listen", "127.0.0.1:3747"})
+	req.ErrorContains(err, "cannot announce prefix [tenant-a] without a publicAddr")
+}
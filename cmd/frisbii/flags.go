@@ -6,38 +6,115 @@ import (
 	"net/url"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dustin/go-humanize"
+	"github.com/ipld/frisbii"
+	util "github.com/ipld/frisbii/internal/util"
 	"github.com/urfave/cli/v2"
 )
 
-var Flags = []cli.Flag{
+// EnvVarPrefix is prepended to the name of each of Flags, upper-cased and with
+// "-" replaced by "_", to give the environment variable it may also be set
+// with, e.g. FRISBII_ANNOUNCE_URL for --announce-url. A flag given on the
+// command line takes precedence over its environment variable.
+const EnvVarPrefix = "FRISBII_"
+
+var Flags = withEnvVars([]cli.Flag{
 	&cli.StringSliceFlag{
-		Name:     "car",
-		Usage:    "path(s) to CAR file(s) to serve content from, can be a glob",
-		Required: true,
+		Name:  "car",
+		Usage: "path(s) to CAR file(s) to serve content from, can be a glob",
 	},
 	&cli.StringFlag{
 		Name:  "listen",
-		Usage: "hostname and port to listen on",
+		Usage: "hostname and port to listen on, or unix:/path/to.sock to listen on a Unix domain socket",
 		Value: ":" + strconv.FormatInt(int64(DefaultHttpPort), 10),
 	},
 	&cli.StringFlag{
-		Name:  "announce",
-		Usage: "content to announce to the indexer, one of [none,roots]",
-		Value: "none",
+		Name:        "announce",
+		Usage:       "content to announce to the indexer, one of [none,roots]; none serves content without any announcement setup",
+		DefaultText: "none, or roots if --announce-url is set",
 	},
 	&cli.StringFlag{
 		Name:  "announce-url",
 		Usage: "announcement endpoint url for the indexer",
 		Value: IndexerAnnounceUrl,
 	},
+	&cli.DurationFlag{
+		Name:  "announce-interval",
+		Usage: "interval at which to re-announce the latest advertisement to the indexer, e.g. 6h (use 0 to only announce on startup and reload)",
+	},
+	&cli.Float64Flag{
+		Name:  "announce-splay",
+		Usage: "fraction of --announce-interval, 0-1, up to which a random delay is added to each re-announcement, to spread out the load of many instances on the indexer",
+		Value: 0.1,
+	},
+	&cli.Int64Flag{
+		Name:        "announce-splay-seed",
+		Usage:       "seed for the random re-announcement delays, for a reproducible schedule",
+		DefaultText: "random",
+	},
+	&cli.DurationFlag{
+		Name:  "announce-ttl",
+		Usage: "how long after each announcement the indexer should consider the records valid, e.g. 24h, included in the advertisement metadata and refreshed by each --announce-interval re-announcement; should be longer than --announce-interval (use 0 for no expiry)",
+	},
+	&cli.BoolFlag{
+		Name:  "announce-on-change-only",
+		Usage: "on reload, only publish a new advertisement for content whose announced multihashes have changed since it was last successfully announced; the periodic --announce-interval re-announcement is unaffected",
+	},
+	&cli.BoolFlag{
+		Name:  "force-announce",
+		Usage: "on reload, publish a new advertisement for all content, even where it hasn't changed, to fully refresh the indexer",
+	},
+	&cli.IntFlag{
+		Name:  "announce-entry-chunk-size",
+		Usage: "maximum number of multihashes in each entries block of an advertisement, " + strconv.Itoa(util.MinEntryChunkSize) + "-" + strconv.Itoa(util.MaxEntryChunkSize) + "; larger chunks make for shorter entries chains with fewer blocks for the indexer to fetch, smaller chunks for smaller blocks",
+		Value: util.DefaultEntryChunkSize,
+	},
+	&cli.StringSliceFlag{
+		Name:        "announce-mh-codecs",
+		Usage:       "multihash functions, by name or code, e.g. sha2-256 or 0x12, to include in announcements; content with other multihashes is served but not announced",
+		DefaultText: "all",
+	},
+	&cli.StringFlag{
+		Name:  "extended-providers",
+		Usage: "path to a JSON file describing sibling providers that announced content is also retrievable from, announced as IPNI extended providers",
+	},
+	&cli.StringFlag{
+		Name:  "servable-roots",
+		Usage: "path to a file listing the root CIDs that may be served and announced, one per line; requests for other roots receive a 404",
+	},
+	&cli.StringFlag{
+		Name:  "denylist",
+		Usage: "path to a file listing CIDs, optionally followed by a path within the DAG, that must not be served, one per line; requests for them receive a 410 and denied roots aren't announced",
+	},
+	&cli.StringFlag{
+		Name:  "denylist-message",
+		Usage: "the body of the 410 response to a request for content on the --denylist",
+		Value: frisbii.DefaultDenylistMessage,
+	},
+	&cli.StringFlag{
+		Name:  "error-template",
+		Usage: "path to an HTML template, with {{.Status}}, {{.StatusText}}, {{.Message}} and {{.Path}} placeholders, rendered for error responses to clients that prefer HTML, such as web browsers; loaded once, on startup",
+	},
+	&cli.StringFlag{
+		Name:  "prefixes",
+		Usage: "path to a JSON file describing additional sets of CAR files to serve under /<name>/ipfs/ path prefixes",
+	},
 	&cli.StringFlag{
 		Name:  "ipni-path",
 		Usage: "the local path to serve IPNI content from, requests will have /ipni/v1/ad/ automatically appended to it",
 		Value: IndexerHandlerPath,
 	},
+	&cli.StringFlag{
+		Name:  "base-path",
+		Usage: "URL path to serve all content under, including IPNI content, e.g. /gateway for a reverse proxy that mounts frisbii at /gateway/ without stripping the path; requests outside of it receive a 404",
+	},
+	&cli.BoolFlag{
+		Name:  "base-path-proxied",
+		Usage: "the reverse proxy at --public-addr adds --base-path to retrievals of /ipfs/<cid> and /<name>/ipfs/<cid>, which are requested without it; required to announce with a --base-path",
+	},
 	&cli.StringFlag{
 		Name:  "public-addr",
 		Usage: "multiaddr or URL of this server as seen by the indexer and other peers if it is different to the listen address",
@@ -47,6 +124,18 @@ var Flags = []cli.Flag{
 		Usage: "path to file to append HTTP request and error logs to, defaults to stdout (-)",
 		Value: "-",
 	},
+	&cli.BoolFlag{
+		Name:  "no-log",
+		Usage: "disable the HTTP request and error log entirely, overrides --log-file",
+	},
+	&cli.IntFlag{
+		Name:  "log-min-status",
+		Usage: "only log requests with a response status code at or above this value, e.g. 400 to only log errors; errors are always logged",
+	},
+	&cli.BoolFlag{
+		Name:  "log-redact-query",
+		Usage: "mask query string values in the request log, except for known-safe parameters such as format and dag-scope",
+	},
 	&cli.DurationFlag{
 		Name:  "max-response-duration",
 		Usage: "maximum duration to spend responding to a request (use 0 for no limit)",
@@ -57,15 +146,102 @@ var Flags = []cli.Flag{
 		Usage: "maximum number of bytes to send in a response (use 0 for no limit)",
 		Value: "100MiB",
 	},
+	&cli.StringFlag{
+		Name:  "max-header-bytes",
+		Usage: "maximum size of a request's request line and headers, larger requests receive a 431 (use 0 for the Go default of 1MiB)",
+		Value: "16KiB",
+	},
+	&cli.StringFlag{
+		Name:  "max-request-uri-bytes",
+		Usage: "maximum size of a request's URI, including the query string, longer URIs receive a 414 (use 0 for no limit)",
+		Value: "8KiB",
+	},
+	&cli.IntFlag{
+		Name:  "max-connections",
+		Usage: "maximum number of connections to have open at once, further connections wait for an open connection to close (use 0 for no limit)",
+	},
+	&cli.DurationFlag{
+		Name:  "tcp-keepalive",
+		Usage: "keep-alive period for accepted TCP connections (use 0 for the Go default of 15s, or a negative value to disable keep-alives)",
+	},
 	&cli.IntFlag{
 		Name:  "compression-level",
-		Usage: "compression level to use for responses, 0-9, 0 is no compression, 9 is maximum compression",
+		Usage: "compression level to use for gzip or zstd responses, 0-9, 0 is no compression, 9 is maximum compression",
 		Value: gzip.NoCompression,
 	},
+	&cli.StringFlag{
+		Name:  "response-cache-dir",
+		Usage: "directory to cache complete CAR responses in, so that identical requests can be served without traversing the DAG again; caching is disabled if unset",
+	},
+	&cli.StringFlag{
+		Name:  "response-cache-size",
+		Usage: "maximum total size of the responses held in the response cache, least recently used responses are evicted first",
+		Value: "1GiB",
+	},
+	&cli.BoolFlag{
+		Name:  "enable-dir-index",
+		Usage: "serve an HTML listing of UnixFS directories to clients that request HTML rather than a CAR or raw block",
+	},
+	&cli.BoolFlag{
+		Name:  "enable-deserialized",
+		Usage: "serve the bytes of UnixFS files to clients that request application/octet-stream or HTML rather than a CAR or raw block",
+	},
+	&cli.StringFlag{
+		Name:  "otel-endpoint",
+		Usage: "OTLP/HTTP endpoint URL to export OpenTelemetry traces to, e.g. http://localhost:4318; tracing is disabled if unset",
+	},
+	&cli.StringFlag{
+		Name:  "pprof-listen",
+		Usage: "private hostname and port, e.g. 127.0.0.1:6060, to serve the Go pprof debug endpoints on, separately from --listen; never expose it publicly, disabled if unset",
+	},
+	&cli.BoolFlag{
+		Name:  "self-test",
+		Usage: "on startup, fetch the root block of a loaded CAR from the server over loopback, before announcing, and act on --self-test-failure if it fails",
+	},
+	&cli.StringFlag{
+		Name:  "self-test-failure",
+		Usage: "what to do when --self-test fails, one of [exit,not-ready]; exit exits with an error, not-ready continues serving but doesn't announce, and reports ready=false in the startup log",
+		Value: string(SelfTestExit),
+	},
+	&cli.StringFlag{
+		Name:  "log-level",
+		Usage: "level of the diagnostic logs written to stderr, one of error, warn, info or debug; by default only errors are logged, along with a line once started, use GOLOG_LOG_LEVEL for finer control",
+	},
+	&cli.BoolFlag{
+		Name:  "quiet",
+		Usage: "only log errors to stderr, without the line logged once started or the progress display, same as --log-level=error",
+	},
 	&cli.BoolFlag{
 		Name:  "verbose",
-		Usage: "enable verbose debug logging to stderr, same as setting GOLOG_LOG_LEVEL=DEBUG",
+		Usage: "enable verbose debug logging to stderr, same as --log-level=debug",
 	},
+})
+
+// withEnvVars sets the environment variable of each of flags, see
+// EnvVarPrefix.
+func withEnvVars(flags []cli.Flag) []cli.Flag {
+	for _, flag := range flags {
+		envVars := []string{EnvVarPrefix + strings.ToUpper(strings.ReplaceAll(flag.Names()[0], "-", "_"))}
+		switch f := flag.(type) {
+		case *cli.StringFlag:
+			f.EnvVars = envVars
+		case *cli.StringSliceFlag:
+			f.EnvVars = envVars
+		case *cli.BoolFlag:
+			f.EnvVars = envVars
+		case *cli.IntFlag:
+			f.EnvVars = envVars
+		case *cli.Int64Flag:
+			f.EnvVars = envVars
+		case *cli.Float64Flag:
+			f.EnvVars = envVars
+		case *cli.DurationFlag:
+			f.EnvVars = envVars
+		default:
+			panic("no environment variable support for flag type of --" + flag.Names()[0])
+		}
+	}
+	return flags
 }
 
 type AnnounceType string
@@ -75,21 +251,76 @@ const (
 	AnnounceRoots AnnounceType = "roots"
 )
 
+type SelfTestFailure string
+
+const (
+	SelfTestExit     SelfTestFailure = "exit"
+	SelfTestNotReady SelfTestFailure = "not-ready"
+)
+
 type Config struct {
 	Cars                []string
 	Listen              string
 	Announce            AnnounceType
 	AnnounceUrl         *url.URL
+	AnnounceInterval    time.Duration
+	AnnounceSplay       float64
+	AnnounceSplaySeed   int64
+	AnnounceTTL         time.Duration
+	AnnounceMhCodes     []uint64
+	AnnounceChunkSize   int
+	AnnounceChangedOnly bool
+	ForceAnnounce       bool
+	ExtendedProviders   string
+	ServableRoots       string
+	Denylist            string
+	DenylistMessage     string
+	ErrorTemplate       string
+	Prefixes            string
 	IpniPath            string
+	BasePath            string
+	BasePathProxied     bool
 	PublicAddr          string
 	LogFile             string
+	NoLog               bool
+	LogMinStatus        int
+	LogRedactQuery      bool
 	MaxResponseDuration time.Duration
 	MaxResponseBytes    int64
+	MaxHeaderBytes      int
+	MaxRequestURIBytes  int
+	MaxConnections      int
+	TCPKeepAlive        time.Duration
 	CompressionLevel    int
-	Verbose             bool
+	ResponseCacheDir    string
+	ResponseCacheSize   int64
+	DirIndex            bool
+	Deserialized        bool
+	OtelEndpoint        string
+	PprofListen         string
+	SelfTest            bool
+	SelfTestFailure     SelfTestFailure
+	LogLevel            string
+}
+
+// validateBasePathAnnounce returns an error where content served under a base
+// path would be announced without the proxy in front of it, at the announced
+// address, adding the base path. Retrieval clients request /ipfs/<cid> from
+// the address of a provider record, which can't carry the base path, so they'd
+// otherwise receive a 404 for everything announced.
+func validateBasePathAnnounce(basePath string, announce bool, proxied bool) error {
+	if basePath == "" || !announce || proxied {
+		return nil
+	}
+	return errors.New("cannot announce content served under --base-path, retrievals from the announced address are requested without it; set --base-path-proxied where the proxy at --public-addr adds it")
 }
 
 func ToConfig(c *cli.Context) (Config, error) {
+	// the identity is only loaded once the CARs are, so check it up front
+	if err := util.ValidatePrivKeyEnv(); err != nil {
+		return Config{}, err
+	}
+
 	cars := c.StringSlice("car")
 	carPaths := make([]string, 0)
 	for _, car := range cars {
@@ -99,13 +330,18 @@ func ToConfig(c *cli.Context) (Config, error) {
 		}
 		carPaths = append(carPaths, matches...)
 	}
-	if len(carPaths) == 0 {
+	prefixes := c.String("prefixes")
+	if len(carPaths) == 0 && prefixes == "" {
 		return Config{}, errors.New("must specify at least one CAR file")
 	}
 	announceType := AnnounceNone
 	announce := c.String("announce")
+	if !c.IsSet("announce") && c.IsSet("announce-url") {
+		// an explicit announce URL implies a desire to announce
+		announce = string(AnnounceRoots)
+	}
 	switch announce {
-	case "none":
+	case "", "none":
 	case "roots":
 		announceType = AnnounceRoots
 	default:
@@ -116,11 +352,59 @@ func ToConfig(c *cli.Context) (Config, error) {
 		return Config{}, err
 	}
 
+	announceInterval := c.Duration("announce-interval")
+	if announceInterval < 0 {
+		return Config{}, errors.New("invalid announce-interval parameter, must be 0 or greater")
+	}
+	announceSplay := c.Float64("announce-splay")
+	if announceSplay < 0 || announceSplay > 1 {
+		return Config{}, errors.New("invalid announce-splay parameter, must be between 0 and 1")
+	}
+	announceSplaySeed := c.Int64("announce-splay-seed")
+	if !c.IsSet("announce-splay-seed") {
+		announceSplaySeed = time.Now().UnixNano()
+	}
+	announceTTL := c.Duration("announce-ttl")
+	if announceTTL < 0 {
+		return Config{}, errors.New("invalid announce-ttl parameter, must be 0 or greater")
+	}
+
+	announceMhCodes, err := util.ParseMultihashCodes(c.StringSlice("announce-mh-codecs"))
+	if err != nil {
+		return Config{}, err
+	}
+	announceChunkSize := c.Int("announce-entry-chunk-size")
+	if err := util.ValidateEntryChunkSize(announceChunkSize); err != nil {
+		return Config{}, errors.New("invalid announce-entry-chunk-size parameter, " + err.Error())
+	}
+	announceChangedOnly := c.Bool("announce-on-change-only")
+	forceAnnounce := c.Bool("force-announce")
+
+	extendedProviders := c.String("extended-providers")
+	servableRoots := c.String("servable-roots")
+	denylist := c.String("denylist")
+	denylistMessage := c.String("denylist-message")
+	errorTemplate := c.String("error-template")
 	ipniPath := c.String("ipni-path")
+	basePath := frisbii.CleanBasePath(c.String("base-path"))
+	if err := validateBasePathAnnounce(basePath, announceType != AnnounceNone, c.Bool("base-path-proxied")); err != nil {
+		return Config{}, err
+	}
 	listen := c.String("listen")
 	publicAddr := c.String("public-addr")
 	logFile := c.String("log-file")
-	verbose := c.Bool("verbose")
+	noLog := c.Bool("no-log")
+	logMinStatus := c.Int("log-min-status")
+	logRedactQuery := c.Bool("log-redact-query")
+	selfTest := c.Bool("self-test")
+	selfTestFailure := SelfTestFailure(c.String("self-test-failure"))
+	if selfTestFailure != SelfTestExit && selfTestFailure != SelfTestNotReady {
+		return Config{}, errors.New("invalid self-test-failure parameter, must be of value [exit,not-ready]")
+	}
+	logLevel, err := toLogLevel(c.String("log-level"), c.Bool("quiet"), c.Bool("verbose"))
+	if err != nil {
+		return Config{}, err
+	}
 
 	maxResponseDuration := c.Duration("max-response-duration")
 	var maxResponseBytes uint64
@@ -132,19 +416,77 @@ func ToConfig(c *cli.Context) (Config, error) {
 		}
 	}
 
+	maxHeaderBytes, err := humanize.ParseBytes(c.String("max-header-bytes"))
+	if err != nil {
+		return Config{}, err
+	}
+	maxRequestURIBytes, err := humanize.ParseBytes(c.String("max-request-uri-bytes"))
+	if err != nil {
+		return Config{}, err
+	}
+
+	maxConnections := c.Int("max-connections")
+	if maxConnections < 0 {
+		return Config{}, errors.New("invalid max-connections parameter, must be 0 or greater")
+	}
+	tcpKeepAlive := c.Duration("tcp-keepalive")
+
 	compressionLevel := c.Int("compression-level")
+	responseCacheDir := c.String("response-cache-dir")
+	responseCacheSize, err := humanize.ParseBytes(c.String("response-cache-size"))
+	if err != nil {
+		return Config{}, err
+	}
+	dirIndex := c.Bool("enable-dir-index")
+	deserialized := c.Bool("enable-deserialized")
+	otelEndpoint := c.String("otel-endpoint")
+	pprofListen := c.String("pprof-listen")
+	if pprofListen != "" && sameListenPort(pprofListen, listen) {
+		return Config{}, errors.New("invalid pprof-listen parameter, must not be the --listen address")
+	}
 
 	return Config{
 		Cars:                carPaths,
 		Listen:              listen,
 		Announce:            announceType,
 		AnnounceUrl:         announceUrl,
+		AnnounceInterval:    announceInterval,
+		AnnounceSplay:       announceSplay,
+		AnnounceSplaySeed:   announceSplaySeed,
+		AnnounceTTL:         announceTTL,
+		AnnounceMhCodes:     announceMhCodes,
+		AnnounceChunkSize:   announceChunkSize,
+		AnnounceChangedOnly: announceChangedOnly,
+		ForceAnnounce:       forceAnnounce,
+		ExtendedProviders:   extendedProviders,
+		ServableRoots:       servableRoots,
+		Denylist:            denylist,
+		DenylistMessage:     denylistMessage,
+		ErrorTemplate:       errorTemplate,
+		Prefixes:            prefixes,
 		IpniPath:            ipniPath,
+		BasePath:            basePath,
+		BasePathProxied:     c.Bool("base-path-proxied"),
 		PublicAddr:          publicAddr,
 		LogFile:             logFile,
+		NoLog:               noLog,
+		LogMinStatus:        logMinStatus,
+		LogRedactQuery:      logRedactQuery,
 		MaxResponseDuration: maxResponseDuration,
 		MaxResponseBytes:    int64(maxResponseBytes),
+		MaxHeaderBytes:      int(maxHeaderBytes),
+		MaxRequestURIBytes:  int(maxRequestURIBytes),
+		MaxConnections:      maxConnections,
+		TCPKeepAlive:        tcpKeepAlive,
 		CompressionLevel:    compressionLevel,
-		Verbose:             verbose,
+		ResponseCacheDir:    responseCacheDir,
+		ResponseCacheSize:   int64(responseCacheSize),
+		DirIndex:            dirIndex,
+		Deserialized:        deserialized,
+		OtelEndpoint:        otelEndpoint,
+		PprofListen:         pprofListen,
+		SelfTest:            selfTest,
+		SelfTestFailure:     selfTestFailure,
+		LogLevel:            logLevel,
 	}, nil
 }
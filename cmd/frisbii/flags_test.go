@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+// toConfig parses args as frisbii's command line.
+func toConfig(t *testing.T, args ...string) (Config, error) {
+	var config Config
+	var configErr error
+	app := &cli.App{
+		Flags: Flags,
+		Action: func(c *cli.Context) error {
+			config, configErr = ToConfig(c)
+			return nil
+		},
+	}
+	require.NoError(t, app.Run(append([]string{"frisbii"}, args...)))
+	return config, configErr
+}
+
+func TestToConfigBasePathAnnounce(t *testing.T) {
+	carPath := writeContentSetCar(t, t.TempDir(), "a")
+
+	for _, tc := range []struct {
+		name        string
+		args        []string
+		expectedErr string
+	}{
+		{"base path", []string{"--base-path", "/gateway"}, ""},
+		{"announce", []string{"--announce", "roots"}, ""},
+		{"announce base path", []string{"--base-path", "/gateway", "--announce", "roots"}, "cannot announce content served under --base-path"},
+		{"announce url base path", []string{"--base-path", "/gateway", "--announce-url", "https://indexer.example.com/announce"}, "cannot announce content served under --base-path"},
+		{"announce base path proxied", []string{"--base-path", "/gateway", "--announce", "roots", "--base-path-proxied"}, ""},
+		{"announce root base path", []string{"--base-path", "/", "--announce", "roots"}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config, err := toConfig(t, append([]string{"--car", carPath}, tc.args...)...)
+			if tc.expectedErr != "" {
+				require.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, []string{carPath}, config.Cars)
+		})
+	}
+}
+
+func TestToConfigSelfTestFailure(t *testing.T) {
+	carPath := writeContentSetCar(t, t.TempDir(), "a")
+
+	config, err := toConfig(t, "--car", carPath, "--self-test")
+	require.NoError(t, err)
+	require.Equal(t, SelfTestExit, config.SelfTestFailure)
+
+	config, err = toConfig(t, "--car", carPath, "--self-test", "--self-test-failure", "not-ready")
+	require.NoError(t, err)
+	require.Equal(t, SelfTestNotReady, config.SelfTestFailure)
+
+	_, err = toConfig(t, "--car", carPath, "--self-test", "--self-test-failure", "ignore")
+	require.ErrorContains(t, err, "invalid self-test-failure parameter")
+}
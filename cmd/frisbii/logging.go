@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ipfs/go-log/v2"
+)
+
+// LogLevels are the values of --log-level, from least to most output.
+var LogLevels = []string{"error", "warn", "info", "debug"}
+
+// toLogLevel resolves --log-level, --quiet and the --verbose flag that it
+// supersedes, to one of LogLevels, or "" where none of them are set.
+func toLogLevel(logLevel string, quiet, verbose bool) (string, error) {
+	set := 0
+	for _, s := range []bool{logLevel != "", quiet, verbose} {
+		if s {
+			set++
+		}
+	}
+	if set > 1 {
+		return "", errors.New("only one of --log-level, --quiet and --verbose may be set")
+	}
+	switch {
+	case quiet:
+		return "error", nil
+	case verbose:
+		return "debug", nil
+	}
+	for _, l := range LogLevels {
+		if logLevel == l {
+			return logLevel, nil
+		}
+	}
+	if logLevel != "" {
+		return "", fmt.Errorf("invalid log-level parameter [%s], must be one of error, warn, info or debug", logLevel)
+	}
+	return "", nil
+}
+
+// setupLogging sets the level of the diagnostic logs, written to stderr,
+// unless GOLOG_LOG_LEVEL is set to choose them instead. By default, where
+// level is "", only errors are logged along with the startup logger's lines;
+// those are logged at every level but error. The request log is written
+// separately, and isn't affected.
+func setupLogging(level string) {
+	if os.Getenv("GOLOG_LOG_LEVEL") != "" {
+		return
+	}
+	if level != "" {
+		_ = log.SetLogLevel("*", level)
+	}
+	if level == "" || level == "warn" {
+		_ = log.SetLogLevel("frisbii/startup", "info")
+	}
+}
@@ -3,24 +3,19 @@ package main
 import (
 	"context"
 	"fmt"
+	"html/template"
 	"io"
-	"net/url"
 	"os"
 	"os/signal"
-	"strings"
-	"sync"
-	"sync/atomic"
 	"syscall"
+	"time"
 
+	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-log/v2"
-	"github.com/ipfs/go-unixfsnode"
 	"github.com/ipld/frisbii"
 	util "github.com/ipld/frisbii/internal/util"
-	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
-	"github.com/ipni/go-libipni/maurl"
 	"github.com/ipni/index-provider/engine"
 	"github.com/libp2p/go-libp2p/core/peer"
-	"github.com/multiformats/go-multiaddr"
 	"github.com/urfave/cli/v2"
 	"go.uber.org/multierr"
 	"golang.org/x/term"
@@ -29,11 +24,17 @@ import (
 const (
 	IndexerHandlerPath = "/ipni/"
 	IndexerAnnounceUrl = "https://cid.contact/ingest/announce"
+	IndexerFindUrl     = "https://cid.contact"
 	DefaultHttpPort    = 3747
 )
 
 var logger = log.Logger("frisbii")
 
+// startupLogger logs the line describing the server once it has started, and
+// the line on shutting down, which are logged by default, unlike the rest of
+// the info logs.
+var startupLogger = log.Logger("frisbii/startup")
+
 func main() {
 	// Set up a context that is canceled when the command is interrupted
 	ctx, cancel := context.WithCancel(context.Background())
@@ -44,8 +45,19 @@ func main() {
 		Usage:  "A minimal IPLD data provider for IPFS",
 		Flags:  Flags,
 		Action: action,
+		Commands: []*cli.Command{
+			announceExportCommand,
+			benchCommand,
+			verifyAnnounceCommand,
+			versionCommand,
+		},
 	}
 
+	// A write to a closed stdout or stderr pipe would otherwise kill the
+	// process with SIGPIPE, rather than returning an error that the request
+	// log can recover from
+	signal.Ignore(syscall.SIGPIPE)
+
 	// Set up a signal handler to cancel the context
 	go func() {
 		interrupt := make(chan os.Signal, 1)
@@ -53,8 +65,7 @@ func main() {
 		select {
 		case <-interrupt:
 			cancel()
-			fmt.Println("Received interrupt signal, shutting down...")
-			fmt.Println("(Hit ctrl-c again to force-shutdown the daemon.)")
+			startupLogger.Info("Received interrupt signal, shutting down... (hit ctrl-c again to force-shutdown the daemon)")
 		case <-ctx.Done():
 		}
 		// Allow any further SIGTERM or SIGINT to kill process
@@ -76,14 +87,66 @@ func action(c *cli.Context) error {
 		return err
 	}
 
-	if config.Verbose && os.Getenv("GOLOG_LOG_LEVEL") == "" {
-		_ = log.SetLogLevel("*", "DEBUG")
+	setupLogging(config.LogLevel)
+
+	if config.OtelEndpoint != "" {
+		shutdownTracing, err := setupTracing(ctx, config.OtelEndpoint)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := shutdownTracing(context.Background()); err != nil {
+				logger.Warnf("failed to shut down tracing: %s", err)
+			}
+		}()
+	}
+
+	if config.PprofListen != "" {
+		shutdownPprof, err := startPprof(config.PprofListen)
+		if err != nil {
+			return err
+		}
+		defer shutdownPprof()
+	}
+
+	// validate before doing anything expensive
+	servableRoots, err := loadServableRoots(config)
+	if err != nil {
+		return err
+	}
+	denylist, err := loadDenylist(config)
+	if err != nil {
+		return err
+	}
+	// unlike the files above, the template isn't re-read on reload
+	var errorTemplate *template.Template
+	if config.ErrorTemplate != "" {
+		if errorTemplate, err = util.LoadErrorTemplate(config.ErrorTemplate); err != nil {
+			return err
+		}
+	}
+	prefixes, err := loadPrefixes(config)
+	if err != nil {
+		return err
+	}
+	var extendedProviders *util.ExtendedProviders
+	if config.Announce != AnnounceNone {
+		if config.ExtendedProviders != "" {
+			if extendedProviders, err = util.LoadExtendedProviders(config.ExtendedProviders); err != nil {
+				return err
+			}
+		}
+		if err := validatePrefixAnnounce(prefixes); err != nil {
+			return err
+		}
 	}
 
 	loader := NewLoader(c.App.ErrWriter)
 	loader.SetStatus("Starting ...")
 	isTerm := c.App.ErrWriter == os.Stderr && term.IsTerminal(int(os.Stderr.Fd()))
-	if isTerm && !config.Verbose && os.Getenv("GOLOG_LOG_LEVEL") == "" {
+	// the progress display is only shown where it wouldn't be interleaved with
+	// other logs
+	if isTerm && config.LogLevel == "" && os.Getenv("GOLOG_LOG_LEVEL") == "" {
 		loader.Start()
 		defer loader.Stop()
 		sigs := make(chan os.Signal, 1)
@@ -95,161 +158,447 @@ func action(c *cli.Context) error {
 		}()
 	}
 
-	confDir, err := util.ConfigDir()
-	if err != nil {
-		return err
-	}
-
-	privKey, id, err := util.LoadPrivKey(confDir)
-	if err != nil {
+	// the content served under /ipfs/ followed by that of each prefix
+	sets, carPaths := newContentSets(config.Cars, prefixes, config.AnnounceMhCodes, config.AnnounceTTL)
+	rootSet := sets[0]
+	carCount := countCars(carPaths)
+	loader.SetStatus(fmt.Sprintf("Loading CARs (%d / %d) ...", 0, carCount))
+	if err := loadContentSets(sets, carPaths, servableRoots, denylist, func(loaded int) {
+		loader.SetStatus(fmt.Sprintf("Loading CARs (%d / %d) ...", loaded, carCount))
+	}); err != nil {
 		return err
 	}
 
-	multicar := frisbii.NewMultiReadableStorage()
-	var wg sync.WaitGroup
-	loader.SetStatus(fmt.Sprintf("Loading CARs (%d / %d) ...", 0, len(config.Cars)))
-	var loaded int64
-	err = nil
-	for ii, carPath := range config.Cars {
-		wg.Add(1)
-		go func(ii int, carPath string) {
-			err = multierr.Append(err, util.LoadCar(multicar, carPath))
-			wg.Done()
-			l := atomic.AddInt64(&loaded, 1)
-			loader.SetStatus(fmt.Sprintf("Loading CARs (%d / %d) ...", l, len(config.Cars)))
-		}(ii, carPath)
-	}
-	wg.Wait()
+	loader.SetStatus("Loaded CARs, starting server ...")
+	logWriter, logCloser, err := openLogWriter(c, config)
 	if err != nil {
 		return err
 	}
 
-	loader.SetStatus("Loaded CARs, starting server ...")
-	var logWriter io.Writer
-	switch config.LogFile {
-	case "":
-	case "-":
-		logWriter = c.App.Writer
-	default:
-		logWriter, err = os.OpenFile(config.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
+	var responseCache *frisbii.ResponseCache
+	if config.ResponseCacheDir != "" {
+		if responseCache, err = frisbii.NewResponseCache(config.ResponseCacheDir, config.ResponseCacheSize); err != nil {
 			return err
 		}
 	}
 
-	lsys := cidlink.DefaultLinkSystem()
-	lsys.TrustedStorage = true
-	unixfsnode.AddUnixFSReificationToLinkSystem(&lsys)
-	lsys.SetReadStorage(multicar)
+	httpOptions := func(config Config, logWriter io.Writer, servableRoots []cid.Cid, denylist []frisbii.DenylistEntry) []frisbii.HttpOption {
+		httpOptions := []frisbii.HttpOption{
+			frisbii.WithLogWriter(logWriter),
+			frisbii.WithLogMinStatus(config.LogMinStatus),
+			frisbii.WithLogRedactQuery(config.LogRedactQuery),
+			frisbii.WithMaxResponseDuration(config.MaxResponseDuration),
+			frisbii.WithMaxResponseBytes(config.MaxResponseBytes),
+			frisbii.WithMaxHeaderBytes(config.MaxHeaderBytes),
+			frisbii.WithMaxRequestURIBytes(config.MaxRequestURIBytes),
+			frisbii.WithMaxConnections(config.MaxConnections),
+			frisbii.WithTCPKeepAlive(config.TCPKeepAlive),
+			frisbii.WithBasePath(config.BasePath),
+			frisbii.WithCompressionLevel(config.CompressionLevel),
+			frisbii.WithDirectoryIndex(config.DirIndex),
+			frisbii.WithDeserialized(config.Deserialized),
+			frisbii.WithDenylistMessage(config.DenylistMessage),
+		}
+		if servableRoots != nil {
+			httpOptions = append(httpOptions, frisbii.WithServableRoots(servableRoots))
+		}
+		if denylist != nil {
+			httpOptions = append(httpOptions, frisbii.WithDenylist(denylist))
+		}
+		if responseCache != nil {
+			httpOptions = append(httpOptions, frisbii.WithResponseCache(responseCache))
+		}
+		if errorTemplate != nil {
+			httpOptions = append(httpOptions, frisbii.WithErrorTemplate(errorTemplate))
+		}
+		return append(httpOptions, rootSet.httpOptions()...)
+	}
 
 	server, err := frisbii.NewFrisbiiServer(
 		ctx,
-		lsys,
+		rootSet.linkSystem(),
 		config.Listen,
-		frisbii.WithLogWriter(logWriter),
-		frisbii.WithMaxResponseDuration(config.MaxResponseDuration),
-		frisbii.WithMaxResponseBytes(config.MaxResponseBytes),
-		frisbii.WithCompressionLevel(config.CompressionLevel),
+		httpOptions(config, logWriter, servableRoots, denylist)...,
 	)
 	if err != nil {
 		return err
 	}
+	defer server.Close()
+	for _, cs := range sets[1:] {
+		if err := server.AddPrefix(cs.prefix, cs.linkSystem(), cs.httpOptions()...); err != nil {
+			return err
+		}
+	}
 	errCh := make(chan error, 1)
 	go func() {
 		errCh <- server.Serve()
 	}()
 
-	frisbiiListenAddr, err := util.GetListenAddr(server.Addr().String(), config.PublicAddr)
+	serverAddr := server.Addr().String()
+	laddr := "http://" + serverAddr
+	if server.Addr().Network() == "unix" {
+		serverAddr = frisbii.UnixSocketPrefix + serverAddr
+		laddr = serverAddr
+	}
+	frisbiiListenAddr, err := util.GetListenAddr(serverAddr, config.PublicAddr)
 	if err != nil {
 		return err
 	}
 
-	logger.Infof("PeerID: %s", id.String())
 	logger.Infof("Listening on %s", server.Addr())
 	logger.Infof("Available as %s", frisbiiListenAddr.Url.String())
-	logger.Infof("Available as %s/p2p/%s", frisbiiListenAddr.Maddr.String(), id.String())
 
-	if config.Announce != AnnounceNone {
-		if frisbiiListenAddr.Unspecified {
-			return fmt.Errorf("cannot announce with unspecified listen address, use --public-addr or --listen to specify one")
+	// not ready where the self-test failed with SelfTestNotReady, in which case
+	// the content is still served but not announced, until a restart
+	ready := true
+	if config.SelfTest {
+		if roots := rootSet.servedRoots(); len(roots) == 0 {
+			logger.Warn("Skipping self-test, no CAR roots to test with")
+		} else {
+			loader.SetStatus("Loaded CARs, started server, running self-test ...")
+			if err := selfTest(ctx, server.Addr(), config.BasePath, roots[0]); err != nil {
+				if config.SelfTestFailure != SelfTestNotReady {
+					return err
+				}
+				logger.Errorf("Self-test failed, serving without announcing: %s", err)
+				ready = false
+			} else {
+				logger.Infof("Self-test fetching %s succeeded", roots[0])
+			}
 		}
+	}
 
+	// with AnnounceNone we skip all IPNI setup, including the identity, so there
+	// is nothing running other than the HTTP server
+	var ann *announcer
+	if ready {
+		if ann, err = startAnnouncer(ctx, config, server, serverAddr, frisbiiListenAddr, sets, extendedProviders); err != nil {
+			return err
+		}
+	}
+	var id peer.ID
+	var eng *engine.Engine
+	if ann != nil {
+		id, eng = ann.id, ann.eng
 		loader.SetStatus("Loaded CARs, started server, announcing to indexer ...")
-		logger.Infof("Announcing to indexer as %s", frisbiiListenAddr.Maddr.String())
+		if _, err := ann.announceSets(ctx, sets); err != nil {
+			return err
+		}
+	}
 
-		listenUrl, err := maurl.ToURL(frisbiiListenAddr.Maddr)
+	// reload re-reads the files named by the configuration, the CARs, servable
+	// roots, denylist, prefixes and log file, and applies them without interrupting
+	// requests in progress. A content set whose CARs fail to load continues to
+	// serve its previous CARs.
+	reload := func() error {
+		config, err := ToConfig(c)
 		if err != nil {
 			return err
 		}
-
-		announceAddr := frisbiiListenAddr.Maddr
-		ipniPath := config.IpniPath
-		if strings.HasPrefix("/ipni/v1/ad/", config.IpniPath) {
-			// if it's some subset of /ipni/v1/ad/ then we won't need to add it,
-			// ipnisync's ServeHTTP is agnostic and only cares about the path.Base()
-			// of the path.
-			ipniPath = ""
+		servableRoots, err := loadServableRoots(config)
+		if err != nil {
+			return err
 		}
-		if ipniPath != "" {
-			httpath, err := multiaddr.NewComponent("httpath", url.PathEscape(ipniPath))
-			if err != nil {
-				return err
-			}
-			announceAddr = multiaddr.Join(announceAddr, httpath)
-		}
-
-		engine, err := engine.New(
-			engine.WithPrivateKey(privKey),
-			engine.WithProvider(peer.AddrInfo{ID: id, Addrs: []multiaddr.Multiaddr{frisbiiListenAddr.Maddr}}),
-			engine.WithDirectAnnounce(config.AnnounceUrl.String()),
-			engine.WithPublisherKind(engine.HttpPublisher),
-			engine.WithHttpPublisherWithoutServer(),
-			engine.WithHttpPublisherHandlerPath(ipniPath),
-			engine.WithHttpPublisherListenAddr(listenUrl.Host),
-			engine.WithHttpPublisherAnnounceAddr(announceAddr.String()),
-		)
+		denylist, err := loadDenylist(config)
+		if err != nil {
+			return err
+		}
+		prefixes, err := loadPrefixes(config)
+		if err != nil {
+			return err
+		}
+		newLogWriter, newLogCloser, err := openLogWriter(c, config)
 		if err != nil {
 			return err
 		}
 
-		// assume announce type "roots"
-		// TODO: support "all" with provider.CarMultihashIterator(idx), or similar
-		engine.RegisterMultihashLister(multicar.RootsLister())
+		carPaths := map[*contentSet][]string{rootSet: config.Cars}
+		byName := make(map[string]util.Prefix, len(prefixes))
+		for _, prefix := range prefixes {
+			byName[prefix.Name] = prefix
+		}
+		for _, cs := range sets[1:] {
+			prefix, ok := byName[cs.prefix]
+			switch {
+			case !ok:
+				logger.Warnf("Prefix [%s] has been removed from the prefixes config, it will continue to be served until restart", cs.prefix)
+			case prefix.PublicAddr != cs.publicAddr:
+				logger.Warnf("The publicAddr of prefix [%s] has changed, this will not be applied until restart", cs.prefix)
+				fallthrough
+			default:
+				carPaths[cs] = prefix.Cars
+			}
+			delete(byName, cs.prefix)
+		}
+		for name := range byName {
+			logger.Warnf("Prefix [%s] has been added to the prefixes config, it will not be served until restart", name)
+		}
 
-		if err := engine.Start(ctx); err != nil {
-			return err
+		var (
+			errs        error
+			replaced    []io.Closer
+			carsChanged bool
+			reannounce  []*contentSet
+			carCount    int
+		)
+		for _, cs := range sets {
+			paths, ok := carPaths[cs]
+			if !ok {
+				paths = cs.cars.paths()
+			}
+			dropped, changed, rootsChanged, err := cs.load(paths, servableRoots, denylist, func(int) {})
+			if err != nil {
+				errs = multierr.Append(errs, fmt.Errorf("failed to load CARs for %s: %w", cs.name(), err))
+				continue
+			}
+			carCount += len(paths)
+			for _, car := range dropped {
+				replaced = append(replaced, car)
+			}
+			carsChanged = carsChanged || changed
+			if needsReannounce(cs, config, rootsChanged) {
+				reannounce = append(reannounce, cs)
+			} else if ann != nil && config.AnnounceChangedOnly {
+				logger.Infof("%s: no changes, skipping announce", cs.name())
+			}
+		}
+		warnMissingServableRoots(sets, servableRoots)
+
+		if carsChanged && responseCache != nil {
+			if err := responseCache.Clear(); err != nil {
+				logger.Warnf("failed to clear response cache: %s", err)
+			}
+		}
+		server.SetHttpOptions(httpOptions(config, newLogWriter, servableRoots, denylist)...)
+		for _, cs := range sets[1:] {
+			if err := server.SetPrefixHttpOptions(cs.prefix, cs.httpOptions()...); err != nil {
+				errs = multierr.Append(errs, err)
+			}
 		}
 
-		// use config.IpniPath here, but the adjusted ipniPath above for setting up
-		// the engine; here we set our local mount expectations and it can't be
-		// ""
-		server.SetIndexerProvider(config.IpniPath, engine)
+		grace := config.MaxResponseDuration
+		if grace == 0 {
+			grace = reloadGrace
+		}
+		if logCloser != nil {
+			replaced = append(replaced, logCloser)
+		}
+		closeAfter(grace, replaced...)
+		logCloser = newLogCloser
+		logger.Infof("Reloaded %d CARs", carCount)
 
-		if err := server.Announce(); err != nil {
-			return err
+		if ann != nil {
+			for _, cs := range reannounce {
+				if err := ann.reannounce(ctx, cs); err != nil {
+					errs = multierr.Append(errs, err)
+					continue
+				}
+				logger.Infof("Re-announced %d roots of %s to indexer", len(cs.servedRoots()), cs.name())
+			}
 		}
+		return errs
 	}
 
 	if loader.IsRunning() {
 		loader.Stop()
 		a := ""
-		if config.Announce != AnnounceNone {
+		if eng != nil {
 			a = ", announced to indexer"
 		}
-		laddr := "http://" + server.Addr().String()
 		fmt.Fprintf(c.App.ErrWriter, " 💿 Loaded CARs, server started%s.\n", a)
-		fmt.Fprintf(c.App.ErrWriter, " 💿 Frisbii thrown and ready to be fetched!\n")
+		if ready {
+			fmt.Fprintf(c.App.ErrWriter, " 💿 Frisbii thrown and ready to be fetched!\n")
+		} else {
+			fmt.Fprintf(c.App.ErrWriter, " 💿 Self-test failed, Frisbii is serving but not ready, and hasn't announced\n")
+		}
 		fmt.Fprintf(c.App.ErrWriter, " 💿 Listening to %s\n", laddr)
 		if laddr != frisbiiListenAddr.Url.String() {
 			fmt.Fprintf(c.App.ErrWriter, " 💿 Available at %s\n", frisbiiListenAddr.Url.String())
 		}
-		fmt.Fprintf(c.App.ErrWriter, " 💿 %s/p2p/%s\n", frisbiiListenAddr.Maddr.String(), id.String())
+		if id != "" {
+			fmt.Fprintf(c.App.ErrWriter, " 💿 %s/p2p/%s\n", frisbiiListenAddr.Maddr.String(), id.String())
+		}
 	}
 
-	select {
-	case <-ctx.Done():
-	case err = <-errCh:
-		return err
+	var rootCount int
+	for _, cs := range sets {
+		rootCount += len(cs.servedRoots())
+	}
+	var peerID string
+	if id != "" {
+		peerID = id.String()
+	}
+	version := getVersionInfo()
+	startupLogger.Infow("Frisbii started",
+		"version", version.Version,
+		"commit", version.Commit,
+		"goVersion", version.GoVersion,
+		"listen", laddr,
+		"publicAddr", frisbiiListenAddr.Url.String(),
+		"announce", string(config.Announce),
+		"peerID", peerID,
+		"cars", carCount,
+		"roots", rootCount,
+		"ready", ready,
+	)
+
+	// periodically re-announce the latest advertisement, with a splay so that
+	// instances started together don't all announce at once
+	var reannounceTimer *time.Timer
+	var reannounceCh <-chan time.Time
+	var splay *util.Splay
+	if eng != nil && config.AnnounceInterval > 0 {
+		splay = util.NewSplay(config.AnnounceSplay, config.AnnounceSplaySeed)
+		reannounceTimer = time.NewTimer(splay.Next(config.AnnounceInterval))
+		defer reannounceTimer.Stop()
+		reannounceCh = reannounceTimer.C
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err = <-errCh:
+			return err
+		case <-reannounceCh:
+			if config.AnnounceTTL > 0 {
+				// re-announcing the latest advertisement doesn't extend the expiry
+				// of the records, each set needs a new advertisement
+				ann.refresh(ctx, sets)
+			} else if adCid, err := eng.PublishLatest(ctx); err != nil {
+				logger.Warnf("Failed to re-announce to indexer: %s", err)
+			} else {
+				logger.Infof("Re-announced %s to indexer", adCid)
+			}
+			reannounceTimer.Reset(splay.Next(config.AnnounceInterval))
+		case <-hup:
+			logger.Info("Received SIGHUP, reloading ...")
+			if err := reload(); err != nil {
+				logger.Errorf("Reload failed, continuing with the previous configuration where it couldn't be applied: %s", err)
+			}
+		}
+	}
+}
+
+// startAnnouncer sets up announcements to the indexer of sets, served by
+// server, listening at serverAddr and available at listenAddr, starting the engine and serving its advertisements from
+// server. With AnnounceNone nothing is set up, not even the identity, and nil
+// is returned.
+func startAnnouncer(ctx context.Context, config Config, server *frisbii.FrisbiiServer, serverAddr string, listenAddr util.ListenAddr, sets []*contentSet, extendedProviders *util.ExtendedProviders) (*announcer, error) {
+	if config.Announce == AnnounceNone {
+		return nil, nil
+	}
+	if listenAddr.Unspecified {
+		return nil, fmt.Errorf("cannot announce with unspecified listen address, use --public-addr or --listen to specify one")
+	}
+
+	confDir, err := util.ConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	privKey, id, err := util.LoadPrivKey(confDir)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Infof("PeerID: %s", id.String())
+	logger.Infof("Available as %s/p2p/%s", listenAddr.Maddr.String(), id.String())
+	logger.Infof("Announcing to indexer as %s", listenAddr.Maddr.String())
+	if config.AnnounceTTL > 0 {
+		// records are only refreshed by the periodic re-announcement, so they
+		// lapse between re-announcements unless the TTL outlasts the longest
+		// delay, including splay
+		longest := config.AnnounceInterval + time.Duration(float64(config.AnnounceInterval)*config.AnnounceSplay)
+		if config.AnnounceInterval == 0 {
+			logger.Warnf("--announce-ttl of %s is set without an --announce-interval, announcements will expire unless refreshed by a reload", config.AnnounceTTL)
+		} else if config.AnnounceTTL <= longest {
+			logger.Warnf("--announce-ttl of %s is not longer than the longest re-announcement delay of %s (--announce-interval plus --announce-splay), announcements may expire before they are refreshed", config.AnnounceTTL, longest)
+		}
+	}
+
+	// the indexer fetches advertisements from under the base path, where the
+	// server mounts them
+	eng, err := util.NewEngine(privKey, listenAddr.Maddr, config.BasePath+config.IpniPath, config.AnnounceUrl.String(), config.AnnounceChunkSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// assume announce type "roots"
+	// TODO: support "all" with provider.CarMultihashIterator(idx), or similar
+	eng.RegisterMultihashLister(contentSetsLister(sets))
+
+	if err := eng.Start(ctx); err != nil {
+		return nil, err
+	}
+
+	// the engine may adjust the IPNI path it publishes under, but here we set
+	// our local mount expectations and it can't be ""
+	if err := server.SetIndexerProvider(config.IpniPath, eng); err != nil {
+		// e.g. without an --announce-url there is no publisher to serve from
+		logger.Warnf("Not serving IPNI advertisements: %s", err)
+	}
+
+	return &announcer{
+		eng:               eng,
+		privKey:           privKey,
+		id:                id,
+		maddr:             listenAddr.Maddr,
+		serverAddr:        serverAddr,
+		extendedProviders: extendedProviders,
+	}, nil
+}
+
+func loadServableRoots(config Config) ([]cid.Cid, error) {
+	if config.ServableRoots == "" {
+		return nil, nil
+	}
+	return util.LoadServableRoots(config.ServableRoots)
+}
+
+func loadDenylist(config Config) ([]frisbii.DenylistEntry, error) {
+	if config.Denylist == "" {
+		return nil, nil
+	}
+	return util.LoadDenylist(config.Denylist)
+}
+
+func loadPrefixes(config Config) ([]util.Prefix, error) {
+	if config.Prefixes == "" {
+		return nil, nil
+	}
+	return util.LoadPrefixes(config.Prefixes)
+}
+
+// validatePrefixAnnounce returns an error where one of prefixes can't be
+// announced, as it doesn't have a valid publicAddr.
+func validatePrefixAnnounce(prefixes []util.Prefix) error {
+	for _, prefix := range prefixes {
+		if prefix.PublicAddr == "" {
+			return fmt.Errorf("cannot announce prefix [%s] without a publicAddr, clients of the indexer can only retrieve from /ipfs/ at the announced address", prefix.Name)
+		}
+		if _, err := util.GetListenAddr("", prefix.PublicAddr); err != nil {
+			return fmt.Errorf("invalid publicAddr for prefix [%s]: %w", prefix.Name, err)
+		}
 	}
 	return nil
 }
+
+// warnMissingServableRoots warns where some of the servable roots aren't
+// found in any of the loaded CARs.
+func warnMissingServableRoots(sets []*contentSet, servableRoots []cid.Cid) {
+	if servableRoots == nil {
+		return
+	}
+	found := make(map[string]struct{})
+	for _, cs := range sets {
+		for _, root := range cs.servedRoots() {
+			found[string(root.Hash())] = struct{}{}
+		}
+	}
+	if len(found) < len(servableRoots) {
+		logger.Warnf("Only %d of %d servable roots were found in the loaded CARs", len(found), len(servableRoots))
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipld/frisbii"
+	util "github.com/ipld/frisbii/internal/util"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartAnnouncer(t *testing.T) {
+	ctx := context.Background()
+
+	carPath := writeContentSetCar(t, t.TempDir(), "a")
+	sets, carPaths := newContentSets([]string{carPath}, nil, nil, 0)
+	require.NoError(t, loadContentSets(sets, carPaths, nil, nil, func(int) {}))
+
+	// startServer serves sets as frisbii does, returning the URL of the IPNI
+	// head advertisement
+	startServer := func(t *testing.T, config Config) (*announcer, string) {
+		req := require.New(t)
+		server, err := frisbii.NewFrisbiiServer(ctx, sets[0].linkSystem(), "127.0.0.1:0", sets[0].httpOptions()...)
+		req.NoError(err)
+		t.Cleanup(func() { _ = server.Close() })
+		go func() { _ = server.Serve() }()
+		serverAddr := server.Addr().String()
+		listenAddr, err := util.GetListenAddr(serverAddr, "")
+		req.NoError(err)
+		ann, err := startAnnouncer(ctx, config, server, serverAddr, listenAddr, sets, nil)
+		req.NoError(err)
+		if ann != nil {
+			t.Cleanup(func() { _ = ann.eng.Shutdown() })
+		}
+		return ann, "http://" + serverAddr + IndexerHandlerPath + "v1/ad/head"
+	}
+
+	getStatus := func(t *testing.T, u string) int {
+		res, err := http.Get(u)
+		require.NoError(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	t.Run("none", func(t *testing.T) {
+		req := require.New(t)
+		// nothing is set up, not even the identity, which would otherwise be
+		// created in the config dir
+		home := t.TempDir()
+		t.Setenv("HOME", home)
+		ann, headUrl := startServer(t, Config{Announce: AnnounceNone, IpniPath: IndexerHandlerPath, AnnounceUrl: &url.URL{}})
+		req.Nil(ann)
+		req.Equal(http.StatusNotFound, getStatus(t, headUrl))
+		_, err := os.Stat(filepath.Join(home, util.FrisbiiConfigDir))
+		req.True(os.IsNotExist(err))
+	})
+
+	t.Run("roots", func(t *testing.T) {
+		req := require.New(t)
+		t.Setenv("HOME", t.TempDir())
+		privKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		req.NoError(err)
+		data, err := crypto.MarshalPrivateKey(privKey)
+		req.NoError(err)
+		t.Setenv(util.PrivateKeyEnvVar, base64.StdEncoding.EncodeToString(data))
+		var announced int
+		announceServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			announced++
+			res.WriteHeader(http.StatusNoContent)
+		}))
+		defer announceServer.Close()
+		announceUrl, err := url.Parse(announceServer.URL + "/announce")
+		req.NoError(err)
+
+		ann, headUrl := startServer(t, Config{Announce: AnnounceRoots, IpniPath: IndexerHandlerPath, AnnounceUrl: announceUrl, AnnounceChunkSize: util.DefaultEntryChunkSize})
+		req.NotNil(ann)
+		_, err = ann.announceSets(ctx, sets)
+		req.NoError(err)
+		req.Equal(1, announced)
+		req.Equal(http.StatusOK, getStatus(t, headUrl))
+	})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// startPprof serves the net/http/pprof handlers, under /debug/pprof/, on a
+// listener of their own at address, separate from the gateway so that it can
+// be firewalled and can't collide with the content served. The returned
+// function stops the listener, along with any profiles being collected.
+func startPprof(address string) (func() error, error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on pprof-listen address [%s]: %w", address, err)
+	}
+	logger.Warnf("Serving pprof debug endpoints on http://%s/debug/pprof/, these expose the internals of the process and must not be reachable publicly", listener.Addr())
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		if ip := net.ParseIP(host); host == "" || (ip != nil && !ip.IsLoopback()) {
+			logger.Warnf("pprof-listen address [%s] is not a loopback address, make sure it's firewalled", address)
+		}
+	}
+
+	// profiles are streamed for as long as they're requested, e.g. with
+	// ?seconds=, so there's no write timeout
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("pprof server failed: %s", err)
+		}
+	}()
+	return server.Close, nil
+}
+
+// sameListenPort returns true if the TCP addresses a and b may listen on the
+// same port, i.e. they have the same port and the same host, or either would
+// listen on all interfaces.
+func sameListenPort(a, b string) bool {
+	hostA, portA, errA := net.SplitHostPort(a)
+	hostB, portB, errB := net.SplitHostPort(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	if portA != portB || portA == "0" {
+		return false
+	}
+	unspecified := func(host string) bool {
+		ip := net.ParseIP(host)
+		return host == "" || (ip != nil && ip.IsUnspecified())
+	}
+	return hostA == hostB || unspecified(hostA) || unspecified(hostB)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/frisbii"
+	util "github.com/ipld/frisbii/internal/util"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/multierr"
+)
+
+// reloadGrace is how long resources replaced on reload, such as CAR files that
+// are no longer being served, are kept open for requests that are still using
+// them, where there is no --max-response-duration to bound those requests.
+const reloadGrace = time.Hour
+
+// carSet is the set of open CAR files being served. It can be reloaded with a
+// new list of CAR paths, reusing the CARs that are already open and haven't
+// changed.
+type carSet struct {
+	lk    sync.Mutex
+	cars  map[string]*util.Car
+	order []string
+}
+
+// load opens the CARs at carPaths, reusing any that are already open and
+// unchanged, and returns a MultiReadableStorage of them, along with the
+// previously open CARs that are no longer part of the set, which the caller is
+// responsible for closing. changed indicates whether the set of CARs differs
+// from the previous load.
+func (cs *carSet) load(carPaths []string, progress func(loaded int)) (multicar *frisbii.MultiReadableStorage, dropped []*util.Car, changed bool, err error) {
+	cs.lk.Lock()
+	defer cs.lk.Unlock()
+
+	carPaths = uniqueStrings(carPaths)
+	cars := make([]*util.Car, len(carPaths))
+	var (
+		wg     sync.WaitGroup
+		errLk  sync.Mutex
+		loaded int64
+	)
+	for ii, carPath := range carPaths {
+		if prev, ok := cs.cars[carPath]; ok && prev.Unchanged() {
+			cars[ii] = prev
+			progress(int(atomic.AddInt64(&loaded, 1)))
+			continue
+		}
+		changed = true
+		wg.Add(1)
+		go func(ii int, carPath string) {
+			defer wg.Done()
+			car, openErr := util.OpenCar(carPath)
+			errLk.Lock()
+			cars[ii], err = car, multierr.Append(err, openErr)
+			errLk.Unlock()
+			progress(int(atomic.AddInt64(&loaded, 1)))
+		}(ii, carPath)
+	}
+	wg.Wait()
+	if err != nil {
+		for ii, car := range cars {
+			if car != nil && car != cs.cars[carPaths[ii]] {
+				car.Close()
+			}
+		}
+		return nil, nil, false, err
+	}
+
+	// overlapping CARs are resolved in load order, see MultiReadableStorage
+	for _, shared := range util.SharedRoots(cars) {
+		logger.Warnf("Root %s is listed by multiple CARs, it is announced once and read from the first: [%s]", shared.Root, strings.Join(shared.Paths, "], ["))
+	}
+	next := make(map[string]*util.Car, len(cars))
+	multicar = frisbii.NewMultiReadableStorage()
+	for _, car := range cars {
+		next[car.Path] = car
+		multicar.AddStore(car.Store, car.Store.Roots())
+	}
+	for carPath, car := range cs.cars {
+		if next[carPath] != car {
+			dropped = append(dropped, car)
+			changed = true
+		}
+	}
+	cs.cars = next
+	cs.order = carPaths
+	return multicar, dropped, changed, nil
+}
+
+// paths returns the paths of the CARs in the set, in the order they were
+// loaded.
+func (cs *carSet) paths() []string {
+	cs.lk.Lock()
+	defer cs.lk.Unlock()
+	return cs.order
+}
+
+// lastModified returns the modification time of the most recently modified
+// CAR in the set.
+func (cs *carSet) lastModified() time.Time {
+	cs.lk.Lock()
+	defer cs.lk.Unlock()
+	var lastModified time.Time
+	for _, car := range cs.cars {
+		if car.ModTime.After(lastModified) {
+			lastModified = car.ModTime
+		}
+	}
+	return lastModified
+}
+
+func uniqueStrings(strs []string) []string {
+	seen := make(map[string]struct{}, len(strs))
+	unique := make([]string, 0, len(strs))
+	for _, s := range strs {
+		if _, ok := seen[s]; !ok {
+			seen[s] = struct{}{}
+			unique = append(unique, s)
+		}
+	}
+	return unique
+}
+
+// openLogWriter opens the request log destination for config. The returned
+// io.Closer is nil where there is nothing to close.
+func openLogWriter(c *cli.Context, config Config) (io.Writer, io.Closer, error) {
+	switch {
+	case config.NoLog, config.LogFile == "":
+		return nil, nil, nil
+	case config.LogFile == "-":
+		return c.App.Writer, nil, nil
+	default:
+		f, err := os.OpenFile(config.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+		return f, f, nil
+	}
+}
+
+// closeAfter closes each of the closers once grace has elapsed, giving
+// requests that are still using them time to complete.
+func closeAfter(grace time.Duration, closers ...io.Closer) {
+	if len(closers) == 0 {
+		return
+	}
+	time.AfterFunc(grace, func() {
+		for _, closer := range closers {
+			if err := closer.Close(); err != nil {
+				logger.Warnf("failed to close replaced resource: %s", err)
+			}
+		}
+	})
+}
+
+// sameRoots returns true if a and b contain the same multihashes, regardless
+// of order.
+func sameRoots(a, b []cid.Cid) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	keys := func(roots []cid.Cid) []string {
+		k := make([]string, 0, len(roots))
+		for _, r := range roots {
+			k = append(k, string(r.Hash()))
+		}
+		sort.Strings(k)
+		return k
+	}
+	ka, kb := keys(a), keys(b)
+	for i := range ka {
+		if ka[i] != kb[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+)
+
+const selfTestTimeout = 30 * time.Second
+
+// selfTest fetches the root block of root from the server listening at addr,
+// under basePath, over loopback, to check that the full serve path works for
+// it; from the index lookup, through traversal to CAR encoding.
+func selfTest(ctx context.Context, addr net.Addr, basePath string, root cid.Cid) error {
+	ctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+	defer cancel()
+
+	client := http.DefaultClient
+	host := "localhost"
+	if addr.Network() == "unix" {
+		var dialer net.Dialer
+		client = &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", addr.String())
+			},
+		}}
+	} else {
+		h, port, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(h); ip != nil && !ip.IsUnspecified() {
+			host = h
+		}
+		host = net.JoinHostPort(host, port)
+	}
+	u := fmt.Sprintf("http://%s%s/ipfs/%s?dag-scope=block", host, basePath, root)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", trustlesshttp.DefaultContentType().String())
+	req.Header.Set("User-Agent", "frisbii-self-test")
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("self-test request for %s failed: %w", root, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("self-test request for %s failed: %s: %s", root, res.Status, body)
+	}
+
+	carReader, err := car.NewBlockReader(res.Body)
+	if err != nil {
+		return fmt.Errorf("self-test response for %s is not a valid CAR: %w", root, err)
+	}
+	if len(carReader.Roots) != 1 || !carReader.Roots[0].Equals(root) {
+		return fmt.Errorf("self-test response for %s has unexpected roots: %v", root, carReader.Roots)
+	}
+	blk, err := carReader.Next()
+	if err != nil {
+		return fmt.Errorf("self-test response for %s is missing the root block: %w", root, err)
+	}
+	if !blk.Cid().Equals(root) {
+		return fmt.Errorf("self-test response for %s has unexpected block: %s", root, blk.Cid())
+	}
+	sum, err := root.Prefix().Sum(blk.RawData())
+	if err != nil {
+		return err
+	}
+	if !sum.Equals(root) {
+		return fmt.Errorf("self-test response for %s has a corrupt root block", root)
+	}
+	if _, err := carReader.Next(); err != io.EOF {
+		return fmt.Errorf("self-test response for %s has unexpected trailing data: %v", root, err)
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/frisbii"
+	util "github.com/ipld/frisbii/internal/util"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/urfave/cli/v2"
+)
+
+var verifyAnnounceCommand = &cli.Command{
+	Name:  "verify-announce",
+	Usage: "check that an indexer has ingested the announcements for the given CARs",
+	Description: "Queries the find API of --indexer for each multihash that frisbii announces " +
+		"for the given CARs, i.e. the multihashes of their roots, and reports those that are " +
+		"present for the provider, missing, or only present for other providers. Exits " +
+		"non-zero if the fraction present is below --min-coverage.",
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:     "car",
+			Usage:    "path(s) to CAR file(s) that are served and announced, can be a glob",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "indexer",
+			Usage: "base URL of the indexer's find API",
+			Value: IndexerFindUrl,
+		},
+		&cli.StringFlag{
+			Name:        "provider",
+			Usage:       "peer ID that the content is expected to be provided by",
+			DefaultText: "the peer ID of this frisbii's identity",
+		},
+		&cli.IntFlag{
+			Name:        "sample",
+			Usage:       "check only this many multihashes, chosen at random",
+			DefaultText: "all",
+		},
+		&cli.Int64Flag{
+			Name:        "sample-seed",
+			Usage:       "seed for choosing the --sample, for a reproducible check",
+			DefaultText: "random",
+		},
+		&cli.Float64Flag{
+			Name:  "min-coverage",
+			Usage: "fraction, 0-1, of the checked multihashes that must be present for the provider",
+			Value: 1,
+		},
+		&cli.IntFlag{
+			Name:  "concurrency",
+			Usage: "number of find queries to make in parallel",
+			Value: 8,
+		},
+		&cli.StringSliceFlag{
+			Name:        "announce-mh-codecs",
+			Usage:       "multihash functions, by name or code, e.g. sha2-256 or 0x12, that are included in announcements",
+			DefaultText: "all",
+		},
+		&cli.StringFlag{
+			Name:  "servable-roots",
+			Usage: "path to a file listing the root CIDs that are served, only these roots are announced",
+		},
+		&cli.StringFlag{
+			Name:  "denylist",
+			Usage: "path to a file listing the CIDs that aren't served, these roots are not announced",
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "output the result as JSON",
+		},
+	},
+	Action: verifyAnnounceAction,
+}
+
+func verifyAnnounceAction(c *cli.Context) error {
+	carPaths := make([]string, 0)
+	for _, car := range c.StringSlice("car") {
+		matches, err := filepath.Glob(car)
+		if err != nil {
+			return err
+		}
+		carPaths = append(carPaths, matches...)
+	}
+	if len(carPaths) == 0 {
+		return errors.New("must specify at least one CAR file")
+	}
+
+	minCoverage := c.Float64("min-coverage")
+	if minCoverage < 0 || minCoverage > 1 {
+		return errors.New("invalid min-coverage parameter, must be between 0 and 1")
+	}
+
+	provider, err := verifyProvider(c.String("provider"))
+	if err != nil {
+		return err
+	}
+
+	mhCodes, err := util.ParseMultihashCodes(c.StringSlice("announce-mh-codecs"))
+	if err != nil {
+		return err
+	}
+	var servableRoots []cid.Cid
+	if c.String("servable-roots") != "" {
+		if servableRoots, err = util.LoadServableRoots(c.String("servable-roots")); err != nil {
+			return err
+		}
+	}
+	var denylist []frisbii.DenylistEntry
+	if c.String("denylist") != "" {
+		if denylist, err = util.LoadDenylist(c.String("denylist")); err != nil {
+			return err
+		}
+	}
+
+	multicar := frisbii.NewMultiReadableStorage()
+	for _, carPath := range carPaths {
+		if err := util.LoadCar(multicar, carPath); err != nil {
+			return err
+		}
+	}
+	roots := multicar.Roots()
+	if servableRoots != nil {
+		roots = util.FilterRoots(roots, servableRoots)
+	}
+	if denylist != nil {
+		roots = util.ExcludeDeniedRoots(roots, denylist)
+	}
+	mhs, _ := util.AnnouncedMultihashes(roots, mhCodes)
+	seed := c.Int64("sample-seed")
+	if !c.IsSet("sample-seed") {
+		seed = time.Now().UnixNano()
+	}
+	mhs = util.SampleMultihashes(mhs, c.Int("sample"), seed)
+
+	result, err := util.VerifyAnnounce(c.Context, util.VerifyAnnounceConfig{
+		Indexer:     c.String("indexer"),
+		Provider:    provider,
+		Multihashes: mhs,
+		Concurrency: c.Int("concurrency"),
+	})
+	if err != nil {
+		return err
+	}
+
+	if c.Bool("json") {
+		enc := json.NewEncoder(c.App.Writer)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			return err
+		}
+	} else {
+		w := c.App.Writer
+		for _, mh := range result.Missing {
+			fmt.Fprintf(w, "missing         %s\n", mh)
+		}
+		for _, op := range result.OtherProvider {
+			fmt.Fprintf(w, "other provider  %s  %s\n", op.Multihash, strings.Join(op.Providers, ","))
+		}
+		for _, f := range result.Failed {
+			fmt.Fprintf(w, "failed          %s  %s\n", f.Multihash, f.Error)
+		}
+		fmt.Fprintf(w, "Checked:         %d multihashes for %s\n", result.Checked, provider)
+		fmt.Fprintf(w, "Present:         %d (%.2f%%)\n", result.Present, result.Coverage*100)
+		fmt.Fprintf(w, "Missing:         %d\n", len(result.Missing))
+		fmt.Fprintf(w, "Other provider:  %d\n", len(result.OtherProvider))
+		fmt.Fprintf(w, "Failed queries:  %d\n", len(result.Failed))
+	}
+
+	if result.Coverage < minCoverage {
+		return fmt.Errorf("coverage of %.2f%% is below --min-coverage of %.2f%%", result.Coverage*100, minCoverage*100)
+	}
+	return nil
+}
+
+// verifyProvider returns the peer ID given with --provider or, where it isn't
+// given, that of the identity that frisbii announces with. Unlike starting
+// frisbii, this doesn't create an identity where there is none.
+func verifyProvider(provider string) (peer.ID, error) {
+	if provider != "" {
+		id, err := peer.Decode(provider)
+		if err != nil {
+			return "", fmt.Errorf("invalid provider parameter: %w", err)
+		}
+		return id, nil
+	}
+	confDir, err := util.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	if _, ok := os.LookupEnv(util.PrivateKeyEnvVar); !ok {
+		if _, err := os.Stat(path.Join(confDir, "key")); err != nil {
+			if os.IsNotExist(err) {
+				return "", fmt.Errorf("no frisbii identity found in %s or %s, use --provider to specify the peer ID to verify", confDir, util.PrivateKeyEnvVar)
+			}
+			return "", err
+		}
+	}
+	_, id, err := util.LoadPrivKey(confDir)
+	return id, err
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/urfave/cli/v2"
+)
+
+// versionInfo describes the build of the running frisbii binary.
+type versionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	GoVersion string `json:"goVersion"`
+}
+
+// getVersionInfo returns the module version and VCS revision that the binary
+// was built from, where the build recorded them, e.g. "(devel)" and "" for a
+// build from a module cache without VCS information.
+func getVersionInfo() versionInfo {
+	info := versionInfo{Version: "(devel)", GoVersion: runtime.Version()}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if bi.Main.Version != "" {
+		info.Version = bi.Main.Version
+	}
+	var modified bool
+	for _, setting := range bi.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Commit = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if modified && info.Commit != "" {
+		info.Commit += "-dirty"
+	}
+	return info
+}
+
+var versionCommand = &cli.Command{
+	Name:  "version",
+	Usage: "print the version of frisbii, the commit it was built from and the Go version it was built with",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "print the version information as JSON, with the same keys as the startup log line",
+		},
+	},
+	Action: versionAction,
+}
+
+func versionAction(c *cli.Context) error {
+	info := getVersionInfo()
+	if c.Bool("json") {
+		return json.NewEncoder(c.App.Writer).Encode(info)
+	}
+	commit := info.Commit
+	if commit == "" {
+		commit = "unknown"
+	}
+	_, err := fmt.Fprintf(c.App.Writer, "frisbii %s\ncommit: %s\ngo: %s\n", info.Version, commit, info.GoVersion)
+	return err
+}
@@ -0,0 +1,47 @@
+package frisbii
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// SniffLength is the maximum number of leading bytes of a file that will be
+// inspected by DetectContentType.
+const SniffLength = 512
+
+// MimeTypeOctetStream is the fallback Content-Type for deserialized file
+// responses whose type can't be determined.
+const MimeTypeOctetStream = "application/octet-stream"
+
+// DetectContentType determines a Content-Type for a deserialized (plain file)
+// response. The extension of filename is consulted first, as it is the only
+// reliable signal for text formats such as JSON or CSS; where that yields
+// nothing, up to the first SniffLength bytes of the file content, supplied as
+// head, are sniffed. If neither produces a result, MimeTypeOctetStream is
+// returned.
+//
+// This is not used for CAR or raw block responses, which always have a fixed
+// Content-Type.
+func DetectContentType(filename string, head []byte) string {
+	if ct := typeByExtension(filename); ct != "" {
+		return ct
+	}
+	if len(head) > 0 {
+		if len(head) > SniffLength {
+			head = head[:SniffLength]
+		}
+		return http.DetectContentType(head)
+	}
+	return MimeTypeOctetStream
+}
+
+// typeByExtension returns the Content-Type for the extension of filename, or
+// "" where it has none, or one that isn't known.
+func typeByExtension(filename string) string {
+	if ext := path.Ext(filename); ext != "" {
+		return mime.TypeByExtension(strings.ToLower(ext))
+	}
+	return ""
+}
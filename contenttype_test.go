@@ -0,0 +1,39 @@
+package frisbii_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ipld/frisbii"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectContentType(t *testing.T) {
+	pngHead := []byte("\x89PNG\x0D\x0A\x1A\x0A\x00\x00\x00\x0DIHDR")
+	mp4Head := []byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom")
+	htmlHead := []byte("<!DOCTYPE html><html><body>hello</body></html>")
+	jsonHead := []byte(`{"hello":"world"}`)
+
+	for _, tc := range []struct {
+		name     string
+		filename string
+		head     []byte
+		expected string
+	}{
+		{"html by extension", "index.html", []byte("plain"), "text/html; charset=utf-8"},
+		{"html by sniffing", "index", htmlHead, "text/html; charset=utf-8"},
+		{"png by extension", "image.PNG", nil, "image/png"},
+		{"png by sniffing", "image", pngHead, "image/png"},
+		{"json by extension", "data.json", jsonHead, "application/json"},
+		{"json without extension sniffs as text", "data", jsonHead, "text/plain; charset=utf-8"},
+		{"mp4 by sniffing", "video", mp4Head, "video/mp4"},
+		{"unknown extension falls back to sniffing", "video.notarealext", mp4Head, "video/mp4"},
+		{"binary", "blob", []byte{0x00, 0x01, 0x02, 0x03}, frisbii.MimeTypeOctetStream},
+		{"empty", "", nil, frisbii.MimeTypeOctetStream},
+		{"long head", "", append(bytes.Repeat([]byte{' '}, frisbii.SniffLength), pngHead...), "text/plain; charset=utf-8"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, frisbii.DetectContentType(tc.filename, tc.head))
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package frisbii
+
+import (
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+)
+
+// DefaultDenylistMessage is the body of the 410 Gone response to a request
+// for denied content, where WithDenylistMessage isn't used.
+const DefaultDenylistMessage = "this content is no longer available"
+
+// DenylistEntry is content that must not be served: the DAG with the given
+// root or, where Path is not empty, only the content at or below Path within
+// that DAG.
+type DenylistEntry struct {
+	Root cid.Cid
+	Path datamodel.Path
+}
+
+func (e DenylistEntry) String() string {
+	if e.Path.Len() == 0 {
+		return e.Root.String()
+	}
+	return e.Root.String() + "/" + e.Path.String()
+}
+
+// refusalError is an error for a request that was refused, where the client is
+// sent Error() but the request log records the more detailed logMessage().
+type refusalError interface {
+	error
+	logMessage() string
+}
+
+// deniedError is the error for a request that matched a denylist entry. The
+// client is sent the configured message, while the request log records the
+// content that was requested and the entry it matched.
+type deniedError struct {
+	entry   DenylistEntry
+	root    cid.Cid
+	path    datamodel.Path
+	message string
+}
+
+func (e *deniedError) Error() string {
+	return e.message
+}
+
+func (e *deniedError) logMessage() string {
+	requested := DenylistEntry{Root: e.root, Path: e.path}
+	return fmt.Sprintf("denied: %s matched denylist entry %s", requested, e.entry)
+}
+
+// denied returns the denylist entry, if any, that matches the content at path
+// within the DAG with the given root. Roots are matched by multihash, so a
+// CIDv0 and a CIDv1, of any codec, are treated the same.
+func (o *httpOptions) denied(root cid.Cid, path datamodel.Path) (DenylistEntry, bool) {
+	for _, entry := range o.Denylist[string(root.Hash())] {
+		if pathHasPrefix(path, entry.Path) {
+			return entry, true
+		}
+	}
+	return DenylistEntry{}, false
+}
+
+// pathHasPrefix returns true if the leading segments of path are those of
+// prefix.
+func pathHasPrefix(path datamodel.Path, prefix datamodel.Path) bool {
+	if prefix.Len() > path.Len() {
+		return false
+	}
+	segs := path.Segments()
+	for i, seg := range prefix.Segments() {
+		if seg.String() != segs[i].String() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,157 @@
+package frisbii_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipld/frisbii"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+	trustlesstestutil "github.com/ipld/go-trustless-utils/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHttpIpfsDenylist(t *testing.T) {
+	store := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	unixfsnode.AddUnixFSReificationToLinkSystem(&lsys)
+
+	fileLnk := mkUnixfsFile(t, lsys, []byte("hello world"))
+	subdirLnk := mkUnixfsDir(t, lsys, map[string]datamodel.Link{"nested.txt": fileLnk})
+	dirLnk := mkUnixfsDir(t, lsys, map[string]datamodel.Link{
+		"hello.txt": fileLnk,
+		"sub dir":   subdirLnk,
+	})
+	dirCid := dirLnk.(cidlink.Link).Cid
+	fileCid := fileLnk.(cidlink.Link).Cid
+	deniedDirPath := "/ipfs/" + dirCid.String() + "/sub%20dir"
+	carType := trustlesshttp.DefaultContentType().String()
+
+	denylist := []frisbii.DenylistEntry{
+		{Root: cid.NewCidV1(cid.Raw, fileCid.Hash())}, // matched by multihash
+		{Root: dirCid, Path: datamodel.ParsePath("sub dir")},
+	}
+
+	for _, tc := range []struct {
+		name         string
+		opts         []frisbii.HttpOption
+		path         string
+		accept       string
+		expectStatus int
+		expectBody   string
+		expectLog    string
+	}{
+		{
+			name:         "denied root",
+			path:         "/ipfs/" + fileCid.String(),
+			accept:       carType,
+			expectStatus: http.StatusGone,
+			expectBody:   frisbii.DefaultDenylistMessage,
+			expectLog:    "denied: " + fileCid.String() + " matched denylist entry " + denylist[0].String(),
+		},
+		{
+			name:         "denied root as CIDv0",
+			path:         "/ipfs/" + cid.NewCidV0(fileCid.Hash()).String(),
+			accept:       carType,
+			expectStatus: http.StatusGone,
+			expectBody:   frisbii.DefaultDenylistMessage,
+		},
+		{
+			name:         "denied root, raw",
+			path:         "/ipfs/" + fileCid.String(),
+			accept:       trustlesshttp.MimeTypeRaw,
+			expectStatus: http.StatusGone,
+			expectBody:   frisbii.DefaultDenylistMessage,
+		},
+		{
+			name:         "denied root, probe",
+			path:         "/ipfs/" + fileCid.String() + "?probe=1",
+			accept:       carType,
+			expectStatus: http.StatusGone,
+			expectBody:   frisbii.DefaultDenylistMessage,
+		},
+		{
+			name:         "denied path",
+			path:         deniedDirPath,
+			accept:       carType,
+			expectStatus: http.StatusGone,
+			expectBody:   frisbii.DefaultDenylistMessage,
+			expectLog:    "denied: " + dirCid.String() + "/sub dir matched denylist entry " + dirCid.String() + "/sub dir",
+		},
+		{
+			name:         "below denied path",
+			path:         deniedDirPath + "/nested.txt",
+			accept:       carType,
+			expectStatus: http.StatusGone,
+			expectBody:   frisbii.DefaultDenylistMessage,
+		},
+		{
+			name:         "below denied path, deserialized",
+			opts:         []frisbii.HttpOption{frisbii.WithDeserialized(true)},
+			path:         deniedDirPath + "/nested.txt",
+			accept:       frisbii.MimeTypeOctetStream,
+			expectStatus: http.StatusGone,
+			expectBody:   frisbii.DefaultDenylistMessage,
+		},
+		{
+			name:         "custom message",
+			opts:         []frisbii.HttpOption{frisbii.WithDenylistMessage("removed on request")},
+			path:         deniedDirPath,
+			accept:       carType,
+			expectStatus: http.StatusGone,
+			expectBody:   "removed on request",
+		},
+		{
+			name:         "root of denied path",
+			path:         "/ipfs/" + dirCid.String(),
+			accept:       carType,
+			expectStatus: http.StatusOK,
+		},
+		{
+			name:         "sibling of denied path",
+			path:         "/ipfs/" + dirCid.String() + "/hello.txt",
+			accept:       carType,
+			expectStatus: http.StatusOK,
+		},
+		{
+			name:         "path with denied path as a string prefix",
+			path:         "/ipfs/" + dirCid.String() + "/sub%20directory",
+			accept:       carType,
+			expectStatus: http.StatusOK,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+
+			var logBuf bytes.Buffer
+			opts := append([]frisbii.HttpOption{frisbii.WithDenylist(denylist), frisbii.WithLogWriter(&logBuf)}, tc.opts...)
+			handler := frisbii.NewLogMiddleware(frisbii.NewHttpIpfs(context.Background(), lsys, opts...), opts...)
+			request := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			request.Header.Set("Accept", tc.accept)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, request)
+
+			req.Equal(tc.expectStatus, rec.Code)
+			if tc.expectBody != "" {
+				body, err := io.ReadAll(rec.Body)
+				req.NoError(err)
+				req.Equal(tc.expectBody, string(body))
+			}
+			if tc.expectLog != "" {
+				req.Contains(logBuf.String(), " 410 ")
+				req.Contains(logBuf.String(), strconv.Quote(tc.expectLog))
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package frisbii
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+)
+
+// acceptsDeserialized returns true if the request would prefer the bytes of
+// a file to a CAR or raw block, i.e. it lists application/octet-stream or
+// text/html (as browsers do) in its Accept header but neither explicitly asks
+// for a CAR or a raw block, nor uses the format query parameter. A request
+// that accepts anything, with */*, is served a CAR.
+func acceptsDeserialized(req *http.Request) bool {
+	if req.URL.Query().Get("format") != "" {
+		return false
+	}
+	var deserialized bool
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		switch mt {
+		case trustlesshttp.MimeTypeCar, trustlesshttp.MimeTypeRaw:
+			return false
+		case MimeTypeOctetStream, MimeTypeHtml:
+			deserialized = deserialized || params["q"] != "0"
+		}
+	}
+	return deserialized
+}
+
+// serveDeserialized attempts to respond with the bytes of the UnixFS file
+// found at path under root, supporting Range requests on the file. A UnixFS
+// directory is rendered as a directory index where they are enabled, or
+// otherwise listed in a 300 Multiple Choices. If the target is not UnixFS,
+// false is returned and nothing is written to the response so the request can
+// be handled as it otherwise would.
+//
+// An error that occurs once the file has started streaming is returned, and
+// should be signalled with an unclean close.
+func serveDeserialized(
+	ctx context.Context,
+	lsys linking.LinkSystem,
+	res http.ResponseWriter,
+	req *http.Request,
+	cfg *httpOptions,
+	root cid.Cid,
+	path datamodel.Path,
+	logError func(int, error),
+) (bool, error) {
+	c, node, err := resolveUnixFSPath(ctx, lsys, root, path)
+	if err != nil {
+		if ctx.Err() != nil {
+			logError(http.StatusInternalServerError, ctx.Err())
+		} else if isNotFound(err) {
+			logError(http.StatusNotFound, fmt.Errorf("failed to resolve path: %w", err))
+		} else {
+			logError(http.StatusInternalServerError, err)
+		}
+		return true, nil
+	}
+
+	ipfsPath := cfg.PathPrefix + "/ipfs/" + root.String() + urlPathEscape(path)
+	if isUnixFSDirectory(node) {
+		if cfg.DirectoryIndex && serveDirectoryIndex(ctx, lsys, res, cfg.PathPrefix, root, path, logError) {
+			return true, nil
+		}
+		serveDirectoryChoices(res, ipfsPath, node, logError)
+		return true, nil
+	}
+	if node.Kind() != datamodel.Kind_Bytes {
+		return false, nil
+	}
+	rdr, err := unixfsFileReader(node)
+	if err != nil {
+		return false, nil
+	}
+
+	var name string
+	if path.Len() > 0 {
+		name = path.Last().String()
+	}
+	head := make([]byte, SniffLength)
+	n, err := io.ReadFull(rdr, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		logError(http.StatusInternalServerError, fmt.Errorf("failed to read file: %w", err))
+		return true, nil
+	}
+	if _, err := rdr.Seek(0, io.SeekStart); err != nil {
+		logError(http.StatusInternalServerError, err)
+		return true, nil
+	}
+
+	if req.Header.Get("Range") != "" {
+		// a range applies to the file itself, so it can't be compressed
+		res = uncompressedWriter(res)
+	}
+	// a ?filename= hint takes priority, and is the only name a root CID has
+	contentType := typeByExtension(req.URL.Query().Get("filename"))
+	if contentType == "" {
+		contentType = DetectContentType(name, head[:n])
+	}
+	res.Header().Set("Content-Type", contentType)
+	res.Header().Set("Cache-Control", trustlesshttp.ResponseCacheControlHeader)
+	res.Header().Set("Etag", encodedEtag(res, `"`+c.String()+`"`))
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.Header().Set("X-Ipfs-Path", ipfsPath)
+	res.Header().Set("Vary", "Accept, Accept-Encoding")
+
+	// http.ServeContent handles Range, If-None-Match and If-Modified-Since, but
+	// swallows read errors, which are caught here
+	er := &errorCapturingReader{ReadSeeker: rdr}
+	http.ServeContent(res, req, name, cfg.LastModified, er)
+	return true, er.err
+}
+
+// serveDirectoryChoices responds to a request for the bytes of a UnixFS
+// directory with a 300 Multiple Choices listing the paths of its entries.
+func serveDirectoryChoices(res http.ResponseWriter, ipfsPath string, node datamodel.Node, logError func(int, error)) {
+	entries, err := unixfsDirectoryEntries(node)
+	if err != nil {
+		logError(http.StatusInternalServerError, err)
+		return
+	}
+	var sb strings.Builder
+	for _, entry := range entries {
+		sb.WriteString(ipfsPath + "/" + url.PathEscape(entry.Name) + "\n")
+	}
+	res.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.Header().Set("X-Ipfs-Path", ipfsPath)
+	res.Header().Set("Vary", "Accept, Accept-Encoding")
+	res.WriteHeader(http.StatusMultipleChoices)
+	if _, err := io.WriteString(res, sb.String()); err != nil {
+		logger.Debugw("unable to write directory listing", "err", err)
+	}
+}
+
+// uncompressedWriter returns the writer that res compresses into where res is
+// a compression writer, otherwise res itself.
+func uncompressedWriter(res http.ResponseWriter) http.ResponseWriter {
+	switch rw := res.(type) {
+	case *gziphandler.GzipResponseWriter:
+		return rw.ResponseWriter
+	case gziphandler.GzipResponseWriterWithCloseNotify:
+		return rw.ResponseWriter
+	case *zstdResponseWriter:
+		return rw.ResponseWriter
+	}
+	return res
+}
+
+// errorCapturingReader records the first error, other than io.EOF, returned by
+// the underlying reader.
+type errorCapturingReader struct {
+	io.ReadSeeker
+	err error
+}
+
+func (r *errorCapturingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadSeeker.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
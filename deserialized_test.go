@@ -0,0 +1,241 @@
+package frisbii_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipld/frisbii"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+	trustlesstestutil "github.com/ipld/go-trustless-utils/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHttpIpfsDeserialized(t *testing.T) {
+	store := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	unixfsnode.AddUnixFSReificationToLinkSystem(&lsys)
+
+	// a file that spans several blocks
+	bigContent := make([]byte, 10<<10)
+	rand.New(rand.NewSource(1)).Read(bigContent)
+	bigLnk, _, err := builder.BuildUnixFSFile(bytes.NewReader(bigContent), "size-1024", &lsys)
+	require.NoError(t, err)
+
+	fileLnk := mkUnixfsFile(t, lsys, []byte("hello world"))
+	subdirLnk := mkUnixfsDir(t, lsys, map[string]datamodel.Link{"nested.txt": fileLnk})
+	dirLnk := mkUnixfsDir(t, lsys, map[string]datamodel.Link{
+		"hello.txt": fileLnk,
+		"big.bin":   bigLnk,
+		"sub dir":   subdirLnk,
+	})
+	dirCid := dirLnk.(cidlink.Link).Cid
+	fileCid := fileLnk.(cidlink.Link).Cid
+	dirPath := "/ipfs/" + dirCid.String()
+	carType := trustlesshttp.DefaultContentType().String()
+
+	for _, tc := range []struct {
+		name               string
+		disabled           bool
+		dirIndex           bool
+		compression        bool
+		path               string
+		accept             string
+		headers            map[string]string
+		expectStatus       int
+		expectContentType  string
+		expectBody         []byte
+		expectContentRange string
+	}{
+		{
+			name:              "file",
+			path:              dirPath + "/hello.txt",
+			accept:            frisbii.MimeTypeOctetStream,
+			expectStatus:      http.StatusOK,
+			expectContentType: "text/plain; charset=utf-8",
+			expectBody:        []byte("hello world"),
+		},
+		{
+			name:              "file, browser",
+			path:              dirPath + "/hello.txt",
+			accept:            browserAccept,
+			expectStatus:      http.StatusOK,
+			expectContentType: "text/plain; charset=utf-8",
+			expectBody:        []byte("hello world"),
+		},
+		{
+			name:              "file root",
+			path:              "/ipfs/" + fileCid.String(),
+			accept:            frisbii.MimeTypeOctetStream,
+			expectStatus:      http.StatusOK,
+			expectContentType: "text/plain; charset=utf-8",
+			expectBody:        []byte("hello world"),
+		},
+		{
+			name:              "file root, filename hint",
+			path:              "/ipfs/" + fileCid.String() + "?filename=x.svg",
+			accept:            frisbii.MimeTypeOctetStream,
+			expectStatus:      http.StatusOK,
+			expectContentType: "image/svg+xml",
+			expectBody:        []byte("hello world"),
+		},
+		{
+			name:              "filename hint over path",
+			path:              dirPath + "/hello.txt?filename=hello.json",
+			accept:            browserAccept,
+			expectStatus:      http.StatusOK,
+			expectContentType: "application/json",
+			expectBody:        []byte("hello world"),
+		},
+		{
+			name:              "unknown filename hint",
+			path:              dirPath + "/hello.txt?filename=hello.nope",
+			accept:            browserAccept,
+			expectStatus:      http.StatusOK,
+			expectContentType: "text/plain; charset=utf-8",
+			expectBody:        []byte("hello world"),
+		},
+		{
+			name:              "multi-block file",
+			path:              dirPath + "/big.bin",
+			accept:            frisbii.MimeTypeOctetStream,
+			expectStatus:      http.StatusOK,
+			expectContentType: frisbii.MimeTypeOctetStream,
+			expectBody:        bigContent,
+		},
+		{
+			name:               "range",
+			path:               dirPath + "/big.bin",
+			accept:             frisbii.MimeTypeOctetStream,
+			headers:            map[string]string{"Range": "bytes=1000-3999"},
+			expectStatus:       http.StatusPartialContent,
+			expectContentType:  frisbii.MimeTypeOctetStream,
+			expectBody:         bigContent[1000:4000],
+			expectContentRange: "bytes 1000-3999/10240",
+		},
+		{
+			name:               "range, compression accepted",
+			compression:        true,
+			path:               dirPath + "/big.bin",
+			accept:             frisbii.MimeTypeOctetStream,
+			headers:            map[string]string{"Range": "bytes=2000-", "Accept-Encoding": "gzip"},
+			expectStatus:       http.StatusPartialContent,
+			expectContentType:  frisbii.MimeTypeOctetStream,
+			expectBody:         bigContent[2000:],
+			expectContentRange: "bytes 2000-10239/10240",
+		},
+		{
+			name:               "unsatisfiable range",
+			path:               dirPath + "/hello.txt",
+			accept:             frisbii.MimeTypeOctetStream,
+			headers:            map[string]string{"Range": "bytes=100-200"},
+			expectStatus:       http.StatusRequestedRangeNotSatisfiable,
+			expectContentRange: "bytes */11",
+		},
+		{
+			name:         "not modified",
+			path:         dirPath + "/hello.txt",
+			accept:       frisbii.MimeTypeOctetStream,
+			headers:      map[string]string{"If-None-Match": `"` + fileCid.String() + `"`},
+			expectStatus: http.StatusNotModified,
+		},
+		{
+			name:              "directory",
+			path:              dirPath + "/sub%20dir",
+			accept:            frisbii.MimeTypeOctetStream,
+			expectStatus:      http.StatusMultipleChoices,
+			expectContentType: "text/plain; charset=utf-8",
+			expectBody:        []byte(dirPath + "/sub%20dir/nested.txt\n"),
+		},
+		{
+			name:              "directory with directory index",
+			dirIndex:          true,
+			path:              dirPath,
+			accept:            frisbii.MimeTypeOctetStream,
+			expectStatus:      http.StatusOK,
+			expectContentType: "text/html; charset=utf-8",
+		},
+		{
+			name:         "missing path",
+			path:         dirPath + "/nope.txt",
+			accept:       frisbii.MimeTypeOctetStream,
+			expectStatus: http.StatusNotFound,
+		},
+		{
+			name:              "CAR accepted",
+			path:              dirPath + "/hello.txt",
+			accept:            carType + ", " + browserAccept,
+			expectStatus:      http.StatusOK,
+			expectContentType: carType,
+		},
+		{
+			name:              "anything accepted",
+			path:              dirPath + "/hello.txt",
+			accept:            "*/*",
+			expectStatus:      http.StatusOK,
+			expectContentType: carType,
+		},
+		{
+			name:              "format=car",
+			path:              dirPath + "/hello.txt?format=car",
+			accept:            browserAccept,
+			expectStatus:      http.StatusOK,
+			expectContentType: carType,
+		},
+		{
+			name:              "disabled",
+			disabled:          true,
+			path:              dirPath + "/hello.txt",
+			accept:            browserAccept,
+			expectStatus:      http.StatusOK,
+			expectContentType: carType,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+
+			opts := []frisbii.HttpOption{frisbii.WithDeserialized(!tc.disabled), frisbii.WithDirectoryIndex(tc.dirIndex)}
+			if tc.compression {
+				opts = append(opts, frisbii.WithCompressionLevel(gzip.BestSpeed))
+			}
+			testServer := httptest.NewServer(frisbii.NewHttpIpfs(context.Background(), lsys, opts...))
+			defer testServer.Close()
+
+			request, err := http.NewRequest(http.MethodGet, testServer.URL+tc.path, nil)
+			req.NoError(err)
+			request.Header.Set("Accept", tc.accept)
+			for k, v := range tc.headers {
+				request.Header.Set(k, v)
+			}
+			res, err := http.DefaultClient.Do(request)
+			req.NoError(err)
+			defer res.Body.Close()
+			body, err := io.ReadAll(res.Body)
+			req.NoError(err)
+
+			req.Equal(tc.expectStatus, res.StatusCode)
+			if tc.expectContentType != "" {
+				req.Equal(tc.expectContentType, res.Header.Get("Content-Type"))
+			}
+			if tc.expectBody != nil {
+				req.Equal(tc.expectBody, body)
+			}
+			req.Equal(tc.expectContentRange, res.Header.Get("Content-Range"))
+			if tc.expectStatus == http.StatusPartialContent {
+				req.Empty(res.Header.Get("Content-Encoding"))
+			}
+		})
+	}
+}
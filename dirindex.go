@@ -0,0 +1,145 @@
+package frisbii
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+)
+
+const MimeTypeHtml = "text/html"
+
+var dirIndexTemplate = template.Must(template.New("dirindex").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Index of {{.Path}}</title>
+</head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<table>
+{{- if .Parent}}
+<tr><td><a href="{{.Parent}}">..</a></td><td></td></tr>
+{{- end}}
+{{- range .Entries}}
+<tr><td><a href="{{.Href}}">{{.Name}}</a></td><td>{{.Cid}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+type dirIndexEntry struct {
+	Name string
+	Href string
+	Cid  string
+}
+
+type dirIndex struct {
+	Path    string
+	Parent  string
+	Entries []dirIndexEntry
+}
+
+// acceptsHtml returns true if the request prefers an HTML response, i.e. it
+// lists text/html in its Accept header but neither explicitly asks for a CAR
+// or a raw block, nor uses the format query parameter.
+func acceptsHtml(req *http.Request) bool {
+	if req.URL.Query().Get("format") != "" {
+		return false
+	}
+	var html bool
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		switch mt {
+		case trustlesshttp.MimeTypeCar, trustlesshttp.MimeTypeRaw:
+			return false
+		case MimeTypeHtml:
+			html = params["q"] != "0"
+		}
+	}
+	return html
+}
+
+// urlPathEscape escapes each segment of the path so it can be used as a URL
+// path, it will always start with a "/" if not empty.
+func urlPathEscape(path datamodel.Path) string {
+	var sb strings.Builder
+	for _, seg := range path.Segments() {
+		sb.WriteRune('/')
+		sb.WriteString(url.PathEscape(seg.String()))
+	}
+	return sb.String()
+}
+
+// serveDirectoryIndex attempts to render an HTML listing of the UnixFS
+// directory found at path under root. If the target can't be resolved, or is
+// not a UnixFS directory, false is returned and nothing is written to the
+// response so the request can be handled as it otherwise would. Links are
+// prefixed with pathPrefix, the prefix the handler is served under, if any.
+func serveDirectoryIndex(
+	ctx context.Context,
+	lsys linking.LinkSystem,
+	res http.ResponseWriter,
+	pathPrefix string,
+	root cid.Cid,
+	path datamodel.Path,
+	logError func(int, error),
+) bool {
+	_, node, err := resolveUnixFSPath(ctx, lsys, root, path)
+	if err != nil {
+		logger.Debugw("unable to resolve path for directory index", "cid", root, "path", path.String(), "err", err)
+		return false
+	}
+	if !isUnixFSDirectory(node) {
+		return false
+	}
+	entries, err := unixfsDirectoryEntries(node)
+	if err != nil {
+		logError(http.StatusInternalServerError, err)
+		return true
+	}
+
+	rootPath := pathPrefix + "/ipfs/" + root.String()
+	base := rootPath + urlPathEscape(path)
+	index := dirIndex{Path: rootPath}
+	if path.Len() > 0 {
+		index.Path += "/" + path.String()
+		index.Parent = rootPath + urlPathEscape(path.Pop())
+	}
+	for _, entry := range entries {
+		index.Entries = append(index.Entries, dirIndexEntry{
+			Name: entry.Name,
+			Href: base + "/" + url.PathEscape(entry.Name),
+			Cid:  entry.Cid.String(),
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := dirIndexTemplate.Execute(&buf, index); err != nil {
+		logError(http.StatusInternalServerError, err)
+		return true
+	}
+
+	res.Header().Set("Content-Type", MimeTypeHtml+"; charset=utf-8")
+	res.Header().Set("Cache-Control", trustlesshttp.ResponseCacheControlHeader)
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.Header().Set("X-Ipfs-Path", base)
+	res.Header().Set("Vary", "Accept, Accept-Encoding")
+	res.WriteHeader(http.StatusOK)
+	if _, err := res.Write(buf.Bytes()); err != nil {
+		logger.Debugw("unable to write directory index", "err", err)
+	}
+	return true
+}
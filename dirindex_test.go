@@ -0,0 +1,169 @@
+package frisbii_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/data/builder"
+	"github.com/ipld/frisbii"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+	trustlesstestutil "github.com/ipld/go-trustless-utils/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+const browserAccept = "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+
+func TestHttpIpfsDirectoryIndex(t *testing.T) {
+	store := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	unixfsnode.AddUnixFSReificationToLinkSystem(&lsys)
+
+	fileLnk := mkUnixfsFile(t, lsys, []byte("hello world"))
+	subdirLnk := mkUnixfsDir(t, lsys, map[string]datamodel.Link{"nested.txt": fileLnk})
+	dirLnk := mkUnixfsDir(t, lsys, map[string]datamodel.Link{
+		"hello.txt":                 fileLnk,
+		"<script>alert(1)</script>": fileLnk,
+		"sub dir":                   subdirLnk,
+		"100% é":                    subdirLnk,
+	})
+	dirCid := dirLnk.(cidlink.Link).Cid
+	fileCid := fileLnk.(cidlink.Link).Cid
+
+	for _, tc := range []struct {
+		name              string
+		enabled           bool
+		path              string
+		accept            string
+		expectHtml        bool
+		expectIpfsPath    string
+		expectContains    []string
+		expectNotContains []string
+	}{
+		{
+			name:           "directory, browser",
+			enabled:        true,
+			path:           "/ipfs/" + dirCid.String(),
+			accept:         browserAccept,
+			expectHtml:     true,
+			expectIpfsPath: "/ipfs/" + dirCid.String(),
+			expectContains: []string{
+				"Index of /ipfs/" + dirCid.String(),
+				`<a href="/ipfs/` + dirCid.String() + `/hello.txt">hello.txt</a>`,
+				`<a href="/ipfs/` + dirCid.String() + `/sub%20dir">sub dir</a>`,
+				"&lt;script&gt;alert(1)&lt;/script&gt;",
+				fileCid.String(),
+			},
+			expectNotContains: []string{"<script>"},
+		},
+		{
+			name:           "subdirectory, browser",
+			enabled:        true,
+			path:           "/ipfs/" + dirCid.String() + "/sub%20dir",
+			accept:         browserAccept,
+			expectHtml:     true,
+			expectIpfsPath: "/ipfs/" + dirCid.String() + "/sub%20dir",
+			expectContains: []string{
+				"Index of /ipfs/" + dirCid.String() + "/sub dir",
+				`<a href="/ipfs/` + dirCid.String() + `">..</a>`,
+				`<a href="/ipfs/` + dirCid.String() + `/sub%20dir/nested.txt">nested.txt</a>`,
+			},
+		},
+		{
+			name:           "subdirectory with special characters, browser",
+			enabled:        true,
+			path:           "/ipfs/" + dirCid.String() + "/100%25%20%C3%A9",
+			accept:         browserAccept,
+			expectHtml:     true,
+			expectIpfsPath: "/ipfs/" + dirCid.String() + "/100%25%20%C3%A9",
+			expectContains: []string{
+				"Index of /ipfs/" + dirCid.String() + "/100% é",
+				`<a href="/ipfs/` + dirCid.String() + `/100%25%20%C3%A9/nested.txt">nested.txt</a>`,
+			},
+		},
+		{
+			name:    "directory, browser, disabled",
+			enabled: false,
+			path:    "/ipfs/" + dirCid.String(),
+			accept:  browserAccept,
+		},
+		{
+			name:    "directory, CAR",
+			enabled: true,
+			path:    "/ipfs/" + dirCid.String(),
+			accept:  trustlesshttp.DefaultContentType().String() + ", " + browserAccept,
+		},
+		{
+			name:    "directory, format=car",
+			enabled: true,
+			path:    "/ipfs/" + dirCid.String() + "?format=car",
+			accept:  browserAccept,
+		},
+		{
+			name:    "file, browser",
+			enabled: true,
+			path:    "/ipfs/" + dirCid.String() + "/hello.txt",
+			accept:  browserAccept,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+
+			handler := frisbii.NewHttpIpfs(context.Background(), lsys, frisbii.WithDirectoryIndex(tc.enabled))
+			testServer := httptest.NewServer(handler)
+			defer testServer.Close()
+
+			request, err := http.NewRequest(http.MethodGet, testServer.URL+tc.path, nil)
+			req.NoError(err)
+			request.Header.Set("Accept", tc.accept)
+			res, err := http.DefaultClient.Do(request)
+			req.NoError(err)
+			req.Equal(http.StatusOK, res.StatusCode)
+			body, err := io.ReadAll(res.Body)
+			req.NoError(err)
+
+			if !tc.expectHtml {
+				req.Equal(trustlesshttp.DefaultContentType().String(), res.Header.Get("Content-Type"))
+				return
+			}
+			req.Equal("text/html; charset=utf-8", res.Header.Get("Content-Type"))
+			// escaped, as it is for every other response
+			req.Equal(tc.expectIpfsPath, res.Header.Get("X-Ipfs-Path"))
+			for _, s := range tc.expectContains {
+				req.Contains(string(body), s)
+			}
+			for _, s := range tc.expectNotContains {
+				req.NotContains(string(body), s)
+			}
+		})
+	}
+}
+
+func mkUnixfsFile(t *testing.T, lsys linking.LinkSystem, content []byte) datamodel.Link {
+	lnk, _, err := builder.BuildUnixFSFile(bytes.NewReader(content), "", &lsys)
+	require.NoError(t, err)
+	return lnk
+}
+
+func mkUnixfsDir(t *testing.T, lsys linking.LinkSystem, children map[string]datamodel.Link) datamodel.Link {
+	entries := make([]dagpb.PBLink, 0, len(children))
+	for name, lnk := range children {
+		entry, err := builder.BuildUnixFSDirectoryEntry(name, 0, lnk)
+		require.NoError(t, err)
+		entries = append(entries, entry)
+	}
+	lnk, _, err := builder.BuildUnixFSDirectory(entries, &lsys)
+	require.NoError(t, err)
+	return lnk
+}
@@ -0,0 +1,54 @@
+package frisbii
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+	"net/http"
+)
+
+// ErrorPage is the data that the template supplied to WithErrorTemplate is
+// executed with.
+type ErrorPage struct {
+	// Status is the response status code, e.g. 404.
+	Status int
+	// StatusText is the text of the status code, e.g. "Not Found".
+	StatusText string
+	// Message is the body that would otherwise be sent, e.g. "root not found:
+	// <cid>", or the WithDenylistMessage for denied content.
+	Message string
+	// Path is the URL path that was requested.
+	Path string
+}
+
+// ValidateErrorTemplate executes tmpl with example data, to catch a template
+// that parses but refers to fields that ErrorPage doesn't have, before it's
+// supplied to WithErrorTemplate.
+func ValidateErrorTemplate(tmpl *template.Template) error {
+	return tmpl.Execute(io.Discard, ErrorPage{
+		Status:     http.StatusNotFound,
+		StatusText: http.StatusText(http.StatusNotFound),
+		Message:    "not found",
+		Path:       "/ipfs/bafkqaaa",
+	})
+}
+
+// writeErrorPage responds with tmpl rendered for the error, returning false,
+// with nothing written, where it fails to render so that the plain message can
+// be sent instead.
+func writeErrorPage(res http.ResponseWriter, req *http.Request, tmpl *template.Template, status int, message string) bool {
+	var buf bytes.Buffer
+	page := ErrorPage{Status: status, StatusText: http.StatusText(status), Message: message, Path: req.URL.Path}
+	if err := tmpl.Execute(&buf, page); err != nil {
+		logger.Debugw("unable to render error template", "err", err)
+		return false
+	}
+	res.Header().Set("Content-Type", MimeTypeHtml+"; charset=utf-8")
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.Header().Set("Vary", "Accept, Accept-Encoding")
+	res.WriteHeader(status)
+	if _, err := res.Write(buf.Bytes()); err != nil {
+		logger.Debugw("unable to write error page to response", "err", err)
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+package frisbii_test
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/frisbii"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+	trustlesstestutil "github.com/ipld/go-trustless-utils/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHttpIpfsErrorTemplate(t *testing.T) {
+	store := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	missing := cid.MustParse("bafkreiepinbumzepnoln7co5vea4kf3lcctnqolb3u6bvsellgznymt2uq")
+	notFound := "root not found: " + missing.String()
+
+	tmpl := template.Must(template.New("error").Parse(`<h1>{{.Status}} {{.StatusText}}</h1><p>{{.Message}}</p><p>{{.Path}}</p>`))
+	// parses, but fails to execute where the field doesn't exist
+	broken := template.Must(template.New("error").Parse(`{{.Nope}}`))
+
+	browserAccept := "text/html,application/xhtml+xml,*/*;q=0.8"
+	for _, tc := range []struct {
+		name       string
+		path       string
+		accept     string
+		tmpl       *template.Template
+		expectType string
+		expectBody string
+		expectLog  string
+	}{
+		{
+			name:       "browser",
+			path:       "/ipfs/" + missing.String(),
+			accept:     browserAccept,
+			tmpl:       tmpl,
+			expectType: "text/html; charset=utf-8",
+			expectBody: "<h1>404 Not Found</h1><p>" + notFound + "</p><p>/ipfs/" + missing.String() + "</p>",
+			expectLog:  notFound,
+		},
+		{
+			name:       "browser, bad request",
+			path:       "/ipfs/%3Cb%3E",
+			accept:     browserAccept,
+			tmpl:       tmpl,
+			expectType: "text/html; charset=utf-8",
+			expectBody: "<h1>400 Bad Request</h1><p>failed to parse CID path parameter</p><p>/ipfs/&lt;b&gt;</p>",
+			expectLog:  "failed to parse CID path parameter",
+		},
+		{
+			name:       "CAR client",
+			path:       "/ipfs/" + missing.String(),
+			accept:     trustlesshttp.DefaultContentType().String(),
+			tmpl:       tmpl,
+			expectBody: notFound,
+			expectLog:  notFound,
+		},
+		{
+			name:       "browser, no template",
+			path:       "/ipfs/" + missing.String(),
+			accept:     browserAccept,
+			expectBody: notFound,
+			expectLog:  notFound,
+		},
+		{
+			name:       "browser, template fails",
+			path:       "/ipfs/" + missing.String(),
+			accept:     browserAccept,
+			tmpl:       broken,
+			expectBody: notFound,
+			expectLog:  notFound,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+
+			var logBuf bytes.Buffer
+			opts := []frisbii.HttpOption{frisbii.WithLogWriter(&logBuf), frisbii.WithPresenceCheck(store)}
+			if tc.tmpl != nil {
+				opts = append(opts, frisbii.WithErrorTemplate(tc.tmpl))
+			}
+			handler := frisbii.NewLogMiddleware(frisbii.NewHttpIpfs(context.Background(), lsys, opts...), opts...)
+			request := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			request.Header.Set("Accept", tc.accept)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, request)
+
+			req.Equal(tc.expectBody, rec.Body.String())
+			if tc.expectType != "" {
+				req.Equal(tc.expectType, rec.Header().Get("Content-Type"))
+			}
+			// the log records the message regardless of the body
+			req.Contains(logBuf.String(), strconv.Quote(tc.expectLog))
+		})
+	}
+
+	req := require.New(t)
+	req.NoError(frisbii.ValidateErrorTemplate(tmpl))
+	req.Error(frisbii.ValidateErrorTemplate(broken))
+}
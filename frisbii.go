@@ -1,10 +1,17 @@
 package frisbii
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-log/v2"
@@ -12,6 +19,7 @@ import (
 
 	"github.com/ipld/go-ipld-prime/linking"
 	"github.com/ipni/go-libipni/metadata"
+	"github.com/multiformats/go-multicodec"
 )
 
 const ContextID = "frisbii"
@@ -26,10 +34,30 @@ type FrisbiiServer struct {
 	ctx         context.Context
 	lsys        linking.LinkSystem
 	httpOptions []HttpOption
+	lk          sync.Mutex
 
 	listener        net.Listener
+	basePath        string
 	mux             *http.ServeMux
+	handlers        atomic.Pointer[frisbiiHandlers]
+	prefixes        map[string]prefixedContent
 	indexerProvider IndexerProvider
+	indexerPath     string
+	indexerHandler  http.HandlerFunc
+}
+
+// frisbiiHandlers are the parts of the request handling chain that depend on
+// the HttpOptions, which are replaced together by SetHttpOptions.
+type frisbiiHandlers struct {
+	ipfs     http.Handler
+	prefixes map[string]http.Handler
+	root     http.Handler
+}
+
+// prefixedContent is content served under a path prefix, see AddPrefix.
+type prefixedContent struct {
+	lsys        linking.LinkSystem
+	httpOptions []HttpOption
 }
 
 type IndexerProvider interface {
@@ -37,13 +65,20 @@ type IndexerProvider interface {
 	NotifyPut(ctx context.Context, provider *peer.AddrInfo, contextID []byte, md metadata.Metadata) (cid.Cid, error)
 }
 
+// NewFrisbiiServer creates a FrisbiiServer listening on address, which is
+// either a TCP host and port, or a Unix domain socket path with a
+// UnixSocketPrefix, e.g. "unix:/run/frisbii.sock".
 func NewFrisbiiServer(
 	ctx context.Context,
 	lsys linking.LinkSystem,
 	address string,
 	httpOptions ...HttpOption,
 ) (*FrisbiiServer, error) {
-	listener, err := net.Listen("tcp", address)
+	cfg := toConfig(httpOptions)
+	if strings.ContainsAny(cfg.BasePath, "?#%") {
+		return nil, fmt.Errorf("invalid base path: %q", cfg.BasePath)
+	}
+	listener, err := listen(address, cfg.TCPKeepAlive, cfg.MaxConnections)
 	if err != nil {
 		return nil, err
 	}
@@ -59,21 +94,128 @@ func (fs *FrisbiiServer) Addr() net.Addr {
 	return fs.listener.Addr()
 }
 
+// Close stops the server listening for new connections, removing the socket
+// file if it is listening on a Unix domain socket.
+func (fs *FrisbiiServer) Close() error {
+	return fs.listener.Close()
+}
+
 func (fs *FrisbiiServer) Serve() error {
+	fs.lk.Lock()
+	cfg := toConfig(fs.httpOptions)
+	fs.basePath = cfg.BasePath
 	fs.mux = http.NewServeMux()
-	fs.mux.Handle("/ipfs/", NewHttpIpfs(fs.ctx, fs.lsys, fs.httpOptions...))
+	fs.mux.HandleFunc("/ipfs/", func(res http.ResponseWriter, req *http.Request) {
+		fs.handlers.Load().ipfs.ServeHTTP(res, req)
+	})
 	fs.mux.Handle("/", http.NotFoundHandler())
+	for prefix := range fs.prefixes {
+		fs.handlePrefixLocked(prefix)
+	}
+	if fs.indexerHandler != nil {
+		fs.mux.HandleFunc(fs.indexerPath, fs.indexerHandler)
+	}
+	fs.setHandlersLocked()
+	fs.lk.Unlock()
 	server := &http.Server{
-		Addr:        fs.Addr().String(),
-		BaseContext: func(listener net.Listener) context.Context { return fs.ctx },
-		Handler:     NewLogMiddleware(fs.mux, fs.httpOptions...),
+		Addr:           fs.Addr().String(),
+		BaseContext:    func(listener net.Listener) context.Context { return fs.ctx },
+		MaxHeaderBytes: cfg.MaxHeaderBytes,
+		Handler: http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			fs.handlers.Load().root.ServeHTTP(res, req)
+		}),
 	}
 	logger.Debugf("Serve() server on %s", fs.Addr().String())
 	return server.Serve(fs.listener)
 }
 
+// SetHttpOptions replaces the options used to serve and log requests, e.g.
+// to apply reloaded configuration while the server is running. Requests
+// already in progress complete using the options they started with. The
+// http.Server's MaxHeaderBytes and the base path are fixed once Serve has
+// been called, and the listener's connection limit and keep-alive period once
+// the server has been created.
+func (fs *FrisbiiServer) SetHttpOptions(httpOptions ...HttpOption) {
+	fs.lk.Lock()
+	defer fs.lk.Unlock()
+	fs.httpOptions = httpOptions
+	if fs.mux != nil {
+		fs.setHandlersLocked()
+	}
+}
+
+// AddPrefix serves the content of lsys under "/<prefix>/ipfs/", in addition to
+// the content served under "/ipfs/", such that separate sets of content can be
+// served by the same server. Requests under a prefix that hasn't been added
+// receive a 404 Not Found. The server's HttpOptions apply to requests under
+// each prefix, followed by the httpOptions supplied for the prefix, which may
+// override them, e.g. with a presence check for lsys' store. Responses for
+// each prefix are kept separate in a shared ResponseCache.
+//
+// A prefix is a single path segment and can't be "ipfs". AddPrefix may be
+// called before or after Serve.
+func (fs *FrisbiiServer) AddPrefix(prefix string, lsys linking.LinkSystem, httpOptions ...HttpOption) error {
+	if prefix == "" || prefix == "ipfs" || prefix == "." || prefix == ".." || strings.ContainsAny(prefix, "/?#%") {
+		return fmt.Errorf("invalid path prefix: %q", prefix)
+	}
+	fs.lk.Lock()
+	defer fs.lk.Unlock()
+	if _, ok := fs.prefixes[prefix]; ok {
+		return fmt.Errorf("path prefix already added: %q", prefix)
+	}
+	if fs.prefixes == nil {
+		fs.prefixes = make(map[string]prefixedContent)
+	}
+	fs.prefixes[prefix] = prefixedContent{lsys: lsys, httpOptions: httpOptions}
+	if fs.mux != nil {
+		fs.handlePrefixLocked(prefix)
+		fs.setHandlersLocked()
+	}
+	return nil
+}
+
+// SetPrefixHttpOptions replaces the httpOptions supplied to AddPrefix for
+// prefix, see SetHttpOptions.
+func (fs *FrisbiiServer) SetPrefixHttpOptions(prefix string, httpOptions ...HttpOption) error {
+	fs.lk.Lock()
+	defer fs.lk.Unlock()
+	content, ok := fs.prefixes[prefix]
+	if !ok {
+		return fmt.Errorf("unknown path prefix: %q", prefix)
+	}
+	content.httpOptions = httpOptions
+	fs.prefixes[prefix] = content
+	if fs.mux != nil {
+		fs.setHandlersLocked()
+	}
+	return nil
+}
+
+func (fs *FrisbiiServer) handlePrefixLocked(prefix string) {
+	fs.mux.HandleFunc("/"+prefix+"/ipfs/", func(res http.ResponseWriter, req *http.Request) {
+		fs.handlers.Load().prefixes[prefix].ServeHTTP(res, req)
+	})
+}
+
+func (fs *FrisbiiServer) setHandlersLocked() {
+	prefixes := make(map[string]http.Handler, len(fs.prefixes))
+	for prefix, content := range fs.prefixes {
+		pathPrefix := "/" + prefix
+		opts := append(append(append([]HttpOption{}, fs.httpOptions...), content.httpOptions...), withPathPrefix(fs.basePath+pathPrefix))
+		prefixes[prefix] = http.StripPrefix(pathPrefix, NewHttpIpfs(fs.ctx, content.lsys, opts...))
+	}
+	ipfsOpts := append(append([]HttpOption{}, fs.httpOptions...), withPathPrefix(fs.basePath))
+	fs.handlers.Store(&frisbiiHandlers{
+		ipfs:     NewHttpIpfs(fs.ctx, fs.lsys, ipfsOpts...),
+		prefixes: prefixes,
+		root:     NewLogMiddleware(stripBasePath(fs.basePath, fs.mux), fs.httpOptions...),
+	})
+}
+
+// SetIndexerProvider serves the advertisements of indexerProvider under
+// handlerPath, and uses it for Announce. It may be called before or after
+// Serve, but only once.
 func (fs *FrisbiiServer) SetIndexerProvider(handlerPath string, indexerProvider IndexerProvider) error {
-	fs.indexerProvider = indexerProvider
 	handlerFunc, err := indexerProvider.GetPublisherHttpFunc()
 	if err != nil {
 		return err
@@ -81,16 +223,26 @@ func (fs *FrisbiiServer) SetIndexerProvider(handlerPath string, indexerProvider
 	if handlerPath == "" || handlerPath[len(handlerPath)-1] != '/' {
 		handlerPath += "/"
 	}
-	fs.mux.HandleFunc(handlerPath, handlerFunc)
+	fs.lk.Lock()
+	defer fs.lk.Unlock()
+	fs.indexerProvider = indexerProvider
+	fs.indexerPath = handlerPath
+	fs.indexerHandler = handlerFunc
+	if fs.mux != nil {
+		fs.mux.HandleFunc(handlerPath, handlerFunc)
+	}
 	logger.Debugf("SetIndexerProvider() handler on %s", handlerPath)
 	return nil
 }
 
 func (fs *FrisbiiServer) Announce() error {
-	if fs.indexerProvider == nil {
+	fs.lk.Lock()
+	indexerProvider := fs.indexerProvider
+	fs.lk.Unlock()
+	if indexerProvider == nil {
 		return errors.New("indexer provider not setup")
 	}
-	if c, err := fs.indexerProvider.NotifyPut(fs.ctx, nil, []byte(ContextID), advMetadata); err != nil {
+	if c, err := NotifyPut(fs.ctx, indexerProvider); err != nil {
 		logger.Errorf("Announce() error: %s", err)
 		return err
 	} else {
@@ -98,3 +250,101 @@ func (fs *FrisbiiServer) Announce() error {
 	}
 	return nil
 }
+
+// NotifyPut tells the IndexerProvider about the content served by frisbii,
+// using the same context ID and metadata as FrisbiiServer#Announce, and
+// returns the CID of the resulting advertisement.
+func NotifyPut(ctx context.Context, indexerProvider IndexerProvider, opts ...AnnounceOption) (cid.Cid, error) {
+	return indexerProvider.NotifyPut(ctx, nil, []byte(ContextID), announceMetadata(opts))
+}
+
+// PrefixContextID returns the context ID that content served under a path
+// prefix, see FrisbiiServer#AddPrefix, is announced with, which distinguishes
+// it from the content of the server's other prefixes and from the content
+// announced with ContextID.
+func PrefixContextID(prefix string) []byte {
+	return []byte(ContextID + "/" + prefix)
+}
+
+// NotifyPutPrefix tells the IndexerProvider about the content served under a
+// path prefix, using PrefixContextID and the same metadata as NotifyPut, and
+// returns the CID of the resulting advertisement. The Trustless Gateway
+// protocol has no notion of a path prefix; clients retrieve from
+// "<address>/ipfs/<cid>", so provider should describe addresses at which the
+// prefix is served at the root, e.g. via a reverse proxy; where provider is
+// nil the IndexerProvider's own identity and addresses are used.
+func NotifyPutPrefix(ctx context.Context, indexerProvider IndexerProvider, prefix string, provider *peer.AddrInfo, opts ...AnnounceOption) (cid.Cid, error) {
+	return indexerProvider.NotifyPut(ctx, provider, PrefixContextID(prefix), announceMetadata(opts))
+}
+
+// AdvertisementMetadata returns the metadata that frisbii includes in its
+// advertisements, describing retrieval via the Trustless Gateway protocol.
+func AdvertisementMetadata() metadata.Metadata {
+	return advMetadata
+}
+
+// ExpiryMetadataCode is the multicodec code, from the private use range, of
+// the metadata entry that WithExpiry adds to an advertisement. The entry's
+// data is the expiry as a uvarint of seconds since the Unix epoch. Indexers
+// and clients that don't recognise the code keep it as an opaque entry
+// alongside the Trustless Gateway protocol, so it doesn't affect retrieval.
+const ExpiryMetadataCode = multicodec.Code(0x300f15)
+
+// AnnounceOption configures an announcement made with NotifyPut or
+// NotifyPutPrefix.
+type AnnounceOption func(*announceOptions)
+
+type announceOptions struct {
+	expiry time.Time
+}
+
+// WithExpiry includes, in the advertisement's metadata, the time after which
+// the provider's records should no longer be considered valid unless they are
+// refreshed by a later advertisement. As the metadata differs with each
+// expiry, announcing again with a later expiry produces a new advertisement
+// for the same content, rather than provider.ErrAlreadyAdvertised.
+func WithExpiry(expiry time.Time) AnnounceOption {
+	return func(o *announceOptions) {
+		o.expiry = expiry
+	}
+}
+
+func announceMetadata(opts []AnnounceOption) metadata.Metadata {
+	var o announceOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.expiry.IsZero() {
+		return advMetadata
+	}
+	data := binary.AppendUvarint(nil, uint64(o.expiry.Unix()))
+	payload := binary.AppendUvarint(nil, uint64(ExpiryMetadataCode))
+	payload = binary.AppendUvarint(payload, uint64(len(data)))
+	payload = append(payload, data...)
+	return metadata.Default.New(metadata.IpfsGatewayHttp{}, &metadata.Unknown{Code: ExpiryMetadataCode, Payload: payload})
+}
+
+// AdvertisementExpiry returns the expiry included in md by WithExpiry, and
+// false where md has none.
+func AdvertisementExpiry(md metadata.Metadata) (time.Time, bool) {
+	p := md.Get(ExpiryMetadataCode)
+	if p == nil {
+		return time.Time{}, false
+	}
+	payload, err := p.MarshalBinary()
+	if err != nil {
+		return time.Time{}, false
+	}
+	r := bytes.NewReader(payload)
+	if code, err := binary.ReadUvarint(r); err != nil || multicodec.Code(code) != ExpiryMetadataCode {
+		return time.Time{}, false
+	}
+	if _, err := binary.ReadUvarint(r); err != nil {
+		return time.Time{}, false
+	}
+	secs, err := binary.ReadUvarint(r)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(secs), 0), true
+}
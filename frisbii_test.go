@@ -8,17 +8,26 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
 	unixfstestutil "github.com/ipfs/go-unixfsnode/testutil"
 	"github.com/ipld/frisbii"
 	"github.com/ipld/go-car/v2"
 	unixfsgen "github.com/ipld/go-fixtureplate/generator"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/ipld/go-ipld-prime/storage/memstore"
 	"github.com/ipld/go-trustless-utils/testutil"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/klauspost/compress/zstd"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multicodec"
 	"github.com/stretchr/testify/require"
 )
 
@@ -26,9 +35,11 @@ func TestFrisbiiServer(t *testing.T) {
 	testCases := []struct {
 		name                   string
 		acceptGzip             bool
+		acceptZstd             bool
 		noClientCompression    bool
 		serverCompressionLevel int
 		expectGzip             bool
+		expectZstd             bool
 	}{
 		{
 			name: "default",
@@ -63,6 +74,31 @@ func TestFrisbiiServer(t *testing.T) {
 			serverCompressionLevel: gzip.NoCompression,
 			expectGzip:             false,
 		},
+		{
+			name:                   "zstd (with server 1)",
+			acceptZstd:             true,
+			serverCompressionLevel: gzip.BestSpeed,
+			expectZstd:             true,
+		},
+		{
+			name:                   "zstd (with server 9)",
+			acceptZstd:             true,
+			serverCompressionLevel: gzip.BestCompression,
+			expectZstd:             true,
+		},
+		{
+			name:                   "zstd (no server compression)",
+			acceptZstd:             true,
+			serverCompressionLevel: gzip.NoCompression,
+			expectZstd:             false,
+		},
+		{
+			name:                   "zstd preferred over gzip",
+			acceptGzip:             true,
+			acceptZstd:             true,
+			serverCompressionLevel: gzip.DefaultCompression,
+			expectZstd:             true,
+		},
 		{
 			name:                   "gzip transparent (no server gzip)",
 			serverCompressionLevel: gzip.NoCompression,
@@ -103,7 +139,7 @@ func TestFrisbiiServer(t *testing.T) {
 					req.Equal("GET", method)
 					req.Equal("/ipfs/"+rootEnt.Root.String(), url.Path)
 					req.Equal(http.StatusOK, status)
-					if tc.expectGzip {
+					if tc.expectGzip || tc.expectZstd {
 						req.NotEqual("-", compressionRatio)
 						// convert compressionRatio string to a float64
 						compressionRatio, err := strconv.ParseFloat(compressionRatio, 64)
@@ -124,8 +160,13 @@ func TestFrisbiiServer(t *testing.T) {
 
 			request, err := http.NewRequest("GET", "http://"+addr.String()+"/ipfs/"+rootEnt.Root.String(), nil)
 			request.Header.Set("Accept", "application/vnd.ipld.car")
-			if tc.acceptGzip {
+			switch {
+			case tc.acceptGzip && tc.acceptZstd:
+				request.Header.Set("Accept-Encoding", "gzip, zstd")
+			case tc.acceptGzip:
 				request.Header.Set("Accept-Encoding", "gzip")
+			case tc.acceptZstd:
+				request.Header.Set("Accept-Encoding", "zstd")
 			}
 			req.NoError(err)
 			request = request.WithContext(ctx)
@@ -140,14 +181,21 @@ func TestFrisbiiServer(t *testing.T) {
 			req.Equal("application/vnd.ipld.car;version=1;order=dfs;dups=y", response.Header.Get("Content-Type"))
 			req.Equal("Accept, Accept-Encoding", response.Header.Get("Vary"))
 
-			rdr := response.Body
-			if tc.expectGzip {
+			var rdr io.Reader = response.Body
+			if tc.expectZstd {
+				req.Equal("zstd", response.Header.Get("Content-Encoding"))
+				zrdr, err := zstd.NewReader(response.Body)
+				req.NoError(err)
+				defer zrdr.Close()
+				rdr = zrdr
+				req.Regexp(`^W/"`+rootEnt.Root.String()+`\.car\.\w{2,13}\.zst"$`, response.Header.Get("Etag"))
+			} else if tc.expectGzip {
 				if tc.noClientCompression || tc.acceptGzip { // in either of these cases we expect to handle it ourselves
 					req.Equal("gzip", response.Header.Get("Content-Encoding"))
 					rdr, err = gzip.NewReader(response.Body)
 					req.NoError(err)
 				} // else should be handled by the go client
-				req.Regexp(`^"`+rootEnt.Root.String()+`\.car\.\w{2,13}\.gz"$`, response.Header.Get("Etag"))
+				req.Regexp(`^W/"`+rootEnt.Root.String()+`\.car\.\w{2,13}\.gz"$`, response.Header.Get("Etag"))
 			} else {
 				req.Regexp(`\.car\.\w{12,13}"$`, response.Header.Get("Etag"))
 			}
@@ -184,3 +232,280 @@ func toCids(e unixfstestutil.DirEntry) []cid.Cid {
 	r(e)
 	return cids
 }
+
+func TestFrisbiiServerSetHttpOptions(t *testing.T) {
+	req := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	blkA, blkB := randBlock(), randBlock()
+	storeFor := func(b blk) *testutil.CorrectedMemStore {
+		return &testutil.CorrectedMemStore{ParentStore: &memstore.Store{Bag: map[string][]byte{b.cid.KeyString(): b.byts}}}
+	}
+	multistore := frisbii.NewMultiReadableStorage()
+	multistore.AddStore(storeFor(blkA), []cid.Cid{blkA.cid})
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.TrustedStorage = true
+	lsys.SetReadStorage(multistore)
+
+	var logA, logB syncBuilder
+	server, err := frisbii.NewFrisbiiServer(ctx, lsys, "localhost:0", frisbii.WithLogWriter(&logA), frisbii.WithPresenceCheck(multistore))
+	req.NoError(err)
+	defer server.Close()
+	go server.Serve()
+
+	get := func(c cid.Cid) int {
+		request, err := http.NewRequest(http.MethodGet, "http://"+server.Addr().String()+"/ipfs/"+c.String(), nil)
+		req.NoError(err)
+		request.Header.Set("Accept", "application/vnd.ipld.car")
+		res, err := http.DefaultClient.Do(request)
+		req.NoError(err)
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	lines := func(b *syncBuilder) func() bool {
+		return func() bool { return strings.Count(b.String(), "\n") == 2 }
+	}
+
+	req.Equal(http.StatusOK, get(blkA.cid))
+	req.Equal(http.StatusNotFound, get(blkB.cid))
+	// log lines are written after the response completes
+	req.Eventually(lines(&logA), time.Second, 10*time.Millisecond)
+
+	// swap the content and the log destination while running
+	replacement := frisbii.NewMultiReadableStorage()
+	replacement.AddStore(storeFor(blkB), []cid.Cid{blkB.cid})
+	multistore.ReplaceStores(replacement)
+	req.Equal([]cid.Cid{blkB.cid}, multistore.Roots())
+	server.SetHttpOptions(frisbii.WithLogWriter(&logB), frisbii.WithPresenceCheck(multistore))
+
+	req.Equal(http.StatusNotFound, get(blkA.cid))
+	req.Equal(http.StatusOK, get(blkB.cid))
+	req.Eventually(lines(&logB), time.Second, 10*time.Millisecond)
+	req.True(lines(&logA)())
+	req.Contains(logB.String(), "root not found: "+blkA.cid.String())
+}
+
+func TestFrisbiiServerPrefixes(t *testing.T) {
+	req := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newLsys := func() linking.LinkSystem {
+		store := &testutil.CorrectedMemStore{ParentStore: &memstore.Store{}}
+		lsys := cidlink.DefaultLinkSystem()
+		lsys.TrustedStorage = true
+		lsys.SetReadStorage(store)
+		lsys.SetWriteStorage(store)
+		unixfsnode.AddUnixFSReificationToLinkSystem(&lsys)
+		return lsys
+	}
+	rootLsys, aLsys, bLsys := newLsys(), newLsys(), newLsys()
+	rootCid := mkUnixfsFile(t, rootLsys, []byte("root content")).(cidlink.Link).Cid
+	fileLnk := mkUnixfsFile(t, aLsys, []byte("tenant a content"))
+	aCid := fileLnk.(cidlink.Link).Cid
+	aDirCid := mkUnixfsDir(t, aLsys, map[string]datamodel.Link{"a.txt": fileLnk}).(cidlink.Link).Cid
+	shared := mkUnixfsFile(t, rootLsys, []byte("shared content")).(cidlink.Link).Cid
+	responseCache, err := frisbii.NewResponseCache(t.TempDir(), 1<<20)
+	req.NoError(err)
+
+	server, err := frisbii.NewFrisbiiServer(ctx, rootLsys, "localhost:0", frisbii.WithResponseCache(responseCache), frisbii.WithDirectoryIndex(true))
+	req.NoError(err)
+	defer server.Close()
+	req.NoError(server.AddPrefix("tenant-a", aLsys))
+	req.ErrorContains(server.AddPrefix("tenant-a", aLsys), "path prefix already added")
+	for _, invalid := range []string{"", "ipfs", "..", "a/b", "a%2fb"} {
+		req.ErrorContains(server.AddPrefix(invalid, aLsys), "invalid path prefix")
+	}
+	go server.Serve()
+	// prefixes can also be added once serving
+	req.NoError(server.AddPrefix("tenant-b", bLsys))
+
+	get := func(path string, accept string) (int, string) {
+		request, err := http.NewRequest(http.MethodGet, "http://"+server.Addr().String()+path, nil)
+		req.NoError(err)
+		request.Header.Set("Accept", accept)
+		res, err := http.DefaultClient.Do(request)
+		req.NoError(err)
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		req.NoError(err)
+		return res.StatusCode, string(body)
+	}
+	const carAccept = "application/vnd.ipld.car"
+
+	status, _ := get("/ipfs/"+rootCid.String(), carAccept)
+	req.Equal(http.StatusOK, status)
+	status, _ = get("/tenant-a/ipfs/"+aCid.String(), carAccept)
+	req.Equal(http.StatusOK, status)
+	status, _ = get("/ipfs/"+aCid.String(), carAccept)
+	req.NotEqual(http.StatusOK, status)
+	status, _ = get("/tenant-a/ipfs/"+rootCid.String(), carAccept)
+	req.NotEqual(http.StatusOK, status)
+	status, _ = get("/tenant-c/ipfs/"+aCid.String(), carAccept)
+	req.Equal(http.StatusNotFound, status)
+
+	// a response cached for one prefix isn't served for another
+	status, _ = get("/ipfs/"+shared.String(), carAccept)
+	req.Equal(http.StatusOK, status)
+	req.Greater(responseCache.Size(), int64(0))
+	status, _ = get("/tenant-b/ipfs/"+shared.String(), carAccept)
+	req.NotEqual(http.StatusOK, status)
+
+	// directory index links include the prefix
+	status, body := get("/tenant-a/ipfs/"+aDirCid.String(), browserAccept)
+	req.Equal(http.StatusOK, status)
+	req.Contains(body, `<a href="/tenant-a/ipfs/`+aDirCid.String()+`/a.txt">a.txt</a>`)
+
+	// prefix options apply after the server's and can be replaced
+	req.NoError(server.SetPrefixHttpOptions("tenant-a", frisbii.WithDirectoryIndex(false)))
+	status, body = get("/tenant-a/ipfs/"+aDirCid.String(), browserAccept)
+	req.Equal(http.StatusOK, status) // as a CAR, the browser accepts */*
+	req.NotContains(body, "<a href")
+	req.ErrorContains(server.SetPrefixHttpOptions("tenant-c"), "unknown path prefix")
+
+	req.Equal("frisbii/tenant-a", string(frisbii.PrefixContextID("tenant-a")))
+}
+
+func TestFrisbiiServerBasePath(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := &testutil.CorrectedMemStore{ParentStore: &memstore.Store{}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.TrustedStorage = true
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	unixfsnode.AddUnixFSReificationToLinkSystem(&lsys)
+	fileLnk := mkUnixfsFile(t, lsys, []byte("base path content"))
+	fileCid := fileLnk.(cidlink.Link).Cid
+	dirCid := mkUnixfsDir(t, lsys, map[string]datamodel.Link{"file.txt": fileLnk}).(cidlink.Link).Cid
+	const carAccept = "application/vnd.ipld.car"
+
+	_, err := frisbii.NewFrisbiiServer(ctx, lsys, "localhost:0", frisbii.WithBasePath("/gate?way"))
+	require.ErrorContains(t, err, "invalid base path")
+
+	for basePath, expected := range map[string]string{"": "", "/": "", "gateway": "/gateway", "/gateway/": "/gateway", "/a/b//": "/a/b"} {
+		require.Equal(t, expected, frisbii.CleanBasePath(basePath), basePath)
+	}
+
+	for _, basePath := range []string{"/gateway", "/gateway/", "gateway"} {
+		t.Run(basePath, func(t *testing.T) {
+			req := require.New(t)
+
+			server, err := frisbii.NewFrisbiiServer(ctx, lsys, "localhost:0", frisbii.WithBasePath(basePath), frisbii.WithDirectoryIndex(true))
+			req.NoError(err)
+			defer server.Close()
+			req.NoError(server.AddPrefix("tenant-a", lsys))
+			go server.Serve()
+
+			get := func(path string, accept string) (int, string) {
+				request, err := http.NewRequest(http.MethodGet, "http://"+server.Addr().String()+path, nil)
+				req.NoError(err)
+				request.Header.Set("Accept", accept)
+				res, err := http.DefaultClient.Do(request)
+				req.NoError(err)
+				defer res.Body.Close()
+				body, err := io.ReadAll(res.Body)
+				req.NoError(err)
+				return res.StatusCode, string(body)
+			}
+
+			for path, expectedStatus := range map[string]int{
+				"/gateway/ipfs/" + fileCid.String():                 http.StatusOK,
+				"/gateway/tenant-a/ipfs/" + fileCid.String():        http.StatusOK,
+				"/gateway/ipfs/" + fileCid.String() + "?format=raw": http.StatusOK,
+				"/ipfs/" + fileCid.String():                         http.StatusNotFound,
+				"/tenant-a/ipfs/" + fileCid.String():                http.StatusNotFound,
+				"/gatewayx/ipfs/" + fileCid.String():                http.StatusNotFound,
+				"/other/gateway/ipfs/" + fileCid.String():           http.StatusNotFound,
+				"/gateway":  http.StatusNotFound,
+				"/gateway/": http.StatusNotFound,
+				"/":         http.StatusNotFound,
+			} {
+				status, _ := get(path, carAccept)
+				req.Equal(expectedStatus, status, path)
+			}
+
+			// links in directory indexes include the base path
+			status, body := get("/gateway/ipfs/"+dirCid.String(), browserAccept)
+			req.Equal(http.StatusOK, status)
+			req.Contains(body, `<a href="/gateway/ipfs/`+dirCid.String()+`/file.txt">file.txt</a>`)
+			status, body = get("/gateway/tenant-a/ipfs/"+dirCid.String(), browserAccept)
+			req.Equal(http.StatusOK, status)
+			req.Contains(body, `<a href="/gateway/tenant-a/ipfs/`+dirCid.String()+`/file.txt">file.txt</a>`)
+		})
+	}
+}
+
+func TestNotifyPutExpiry(t *testing.T) {
+	req := require.New(t)
+
+	ip := &recordingIndexerProvider{}
+	_, err := frisbii.NotifyPut(context.Background(), ip)
+	req.NoError(err)
+	md := ip.roundTrip(t)
+	req.True(md.Equal(frisbii.AdvertisementMetadata()))
+	_, ok := frisbii.AdvertisementExpiry(md)
+	req.False(ok)
+
+	expiry := time.Unix(1700000000, 0)
+	_, err = frisbii.NotifyPutPrefix(context.Background(), ip, "tenant-a", nil, frisbii.WithExpiry(expiry))
+	req.NoError(err)
+	req.Equal(frisbii.PrefixContextID("tenant-a"), ip.contextID)
+	md = ip.roundTrip(t)
+	// the Trustless Gateway protocol is still advertised alongside the expiry
+	req.NotNil(md.Get(multicodec.TransportIpfsGatewayHttp))
+	got, ok := frisbii.AdvertisementExpiry(md)
+	req.True(ok)
+	req.True(expiry.Equal(got))
+
+	// a later expiry is different metadata, so is advertised anew
+	_, err = frisbii.NotifyPut(context.Background(), ip, frisbii.WithExpiry(expiry.Add(time.Hour)))
+	req.NoError(err)
+	req.False(md.Equal(ip.roundTrip(t)))
+}
+
+type recordingIndexerProvider struct {
+	contextID []byte
+	md        metadata.Metadata
+}
+
+func (ip *recordingIndexerProvider) GetPublisherHttpFunc() (http.HandlerFunc, error) {
+	return http.NotFound, nil
+}
+
+func (ip *recordingIndexerProvider) NotifyPut(_ context.Context, _ *peer.AddrInfo, contextID []byte, md metadata.Metadata) (cid.Cid, error) {
+	ip.contextID = contextID
+	ip.md = md
+	return cid.Undef, nil
+}
+
+// roundTrip returns the last metadata put, as an indexer would decode it
+// from an advertisement.
+func (ip *recordingIndexerProvider) roundTrip(t *testing.T) metadata.Metadata {
+	byts, err := ip.md.MarshalBinary()
+	require.NoError(t, err)
+	md := metadata.Default.New()
+	require.NoError(t, md.UnmarshalBinary(byts))
+	return md
+}
+
+type syncBuilder struct {
+	lk sync.Mutex
+	sb strings.Builder
+}
+
+func (b *syncBuilder) Write(p []byte) (int, error) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	return b.sb.Write(p)
+}
+
+func (b *syncBuilder) String() string {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	return b.sb.String()
+}
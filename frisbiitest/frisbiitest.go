@@ -0,0 +1,207 @@
+// Package frisbiitest provides utilities for testing against a frisbii server
+// running in-process, serving content from CAR files, without installing or
+// running the frisbii binary.
+package frisbiitest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipld/frisbii"
+	util "github.com/ipld/frisbii/internal/util"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/announce/message"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"go.uber.org/multierr"
+)
+
+// ipniPath is the path IPNI requests are handled under, as with the frisbii
+// command's default --ipni-path.
+const ipniPath = "/ipni/"
+
+type config struct {
+	httpOptions []frisbii.HttpOption
+	announceUrl string
+}
+
+// Option configures a server started with Start.
+type Option func(*config)
+
+// WithHttpOptions sets the options the server is created with, e.g. to
+// enable directory indexes or to log requests.
+func WithHttpOptions(httpOptions ...frisbii.HttpOption) Option {
+	return func(c *config) {
+		c.httpOptions = append(c.httpOptions, httpOptions...)
+	}
+}
+
+// WithAnnounce announces the roots of the CARs, as the frisbii command does
+// with --announce=roots, to the indexer at announceUrl, which may be the URL
+// of an AnnounceStub. The advertisement chain is published by the server under
+// /ipni/v1/ad/, with a newly generated peer identity.
+//
+// By default, nothing is announced.
+func WithAnnounce(announceUrl string) Option {
+	return func(c *config) {
+		c.announceUrl = announceUrl
+	}
+}
+
+// Start serves the content of the CAR files at carPaths, under /ipfs/ on a
+// random loopback port, until shutdown is called. It returns the base URL of
+// the server, e.g. "http://127.0.0.1:12345", which requests such as
+// baseUrl+"/ipfs/<cid>" can be made against. shutdown stops accepting
+// requests and releases the CARs, it should be called once requests to the
+// server have completed.
+func Start(ctx context.Context, carPaths []string, opts ...Option) (baseUrl string, shutdown func() error, err error) {
+	cfg := &config{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	var closers []io.Closer
+	closeAll := func() error {
+		var errs error
+		for i := len(closers) - 1; i >= 0; i-- {
+			errs = multierr.Append(errs, closers[i].Close())
+		}
+		return errs
+	}
+	defer func() {
+		if err != nil {
+			closeAll()
+		}
+	}()
+
+	multicar := frisbii.NewMultiReadableStorage()
+	for _, carPath := range carPaths {
+		car, err := util.OpenCar(carPath)
+		if err != nil {
+			return "", nil, err
+		}
+		closers = append(closers, car)
+		multicar.AddStore(car.Store, car.Store.Roots())
+	}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.TrustedStorage = true
+	unixfsnode.AddUnixFSReificationToLinkSystem(&lsys)
+	lsys.SetReadStorage(multicar)
+
+	httpOptions := append([]frisbii.HttpOption{frisbii.WithPresenceCheck(multicar)}, cfg.httpOptions...)
+	server, err := frisbii.NewFrisbiiServer(ctx, lsys, "127.0.0.1:0", httpOptions...)
+	if err != nil {
+		return "", nil, err
+	}
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		_ = server.Serve()
+	}()
+	closers = append(closers, closerFunc(func() error {
+		err := server.Close()
+		<-served
+		return err
+	}))
+
+	listenAddr, err := util.GetListenAddr(server.Addr().String(), "")
+	if err != nil {
+		return "", nil, err
+	}
+
+	if cfg.announceUrl != "" {
+		privKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		if err != nil {
+			return "", nil, err
+		}
+		eng, err := util.NewEngine(privKey, listenAddr.Maddr, ipniPath, cfg.announceUrl, util.DefaultEntryChunkSize)
+		if err != nil {
+			return "", nil, err
+		}
+		eng.RegisterMultihashLister(util.RootsLister(multicar.Roots(), nil))
+		if err := eng.Start(ctx); err != nil {
+			return "", nil, err
+		}
+		closers = append(closers, closerFunc(eng.Shutdown))
+		if err := server.SetIndexerProvider(ipniPath, eng); err != nil {
+			return "", nil, err
+		}
+		if _, err := frisbii.NotifyPut(ctx, eng); err != nil {
+			return "", nil, err
+		}
+	}
+
+	return listenAddr.Url.String(), closeAll, nil
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+// AnnounceStub is a stand-in for the announce endpoint of an indexer, which
+// records the announcements it receives rather than acting on them. Its URL
+// can be supplied to WithAnnounce.
+type AnnounceStub struct {
+	// URL is the announce endpoint of the stub.
+	URL string
+
+	server    *httptest.Server
+	lk        sync.Mutex
+	announced []message.Message
+}
+
+// NewAnnounceStub starts an AnnounceStub on a random loopback port, it should
+// be closed when it's no longer needed.
+func NewAnnounceStub() *AnnounceStub {
+	stub := &AnnounceStub{}
+	stub.server = httptest.NewServer(http.HandlerFunc(stub.serveAnnounce))
+	stub.URL = stub.server.URL + "/announce"
+	return stub
+}
+
+func (s *AnnounceStub) serveAnnounce(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPut && req.Method != http.MethodPost {
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var msg message.Message
+	var err error
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		err = json.NewDecoder(req.Body).Decode(&msg)
+	} else {
+		err = msg.UnmarshalCBOR(req.Body)
+	}
+	if err == nil && !msg.Cid.Defined() {
+		err = errors.New("missing advertisement CID")
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.lk.Lock()
+	s.announced = append(s.announced, msg)
+	s.lk.Unlock()
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// Announced returns the announcements received so far, in the order they
+// were received. The Cid of each is that of the advertisement announced.
+func (s *AnnounceStub) Announced() []message.Message {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return append([]message.Message{}, s.announced...)
+}
+
+// Close stops the stub.
+func (s *AnnounceStub) Close() {
+	s.server.Close()
+}
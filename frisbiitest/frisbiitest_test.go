@@ -0,0 +1,93 @@
+package frisbiitest_test
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	unixfs "github.com/ipfs/go-unixfsnode/testutil"
+	"github.com/ipld/frisbii"
+	"github.com/ipld/frisbii/frisbiitest"
+	"github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/storage"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStart(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	carPath, root := mkCar(t)
+
+	stub := frisbiitest.NewAnnounceStub()
+	defer stub.Close()
+
+	baseUrl, shutdown, err := frisbiitest.Start(ctx, []string{carPath},
+		frisbiitest.WithAnnounce(stub.URL),
+		frisbiitest.WithHttpOptions(frisbii.WithCompressionLevel(0)),
+	)
+	req.NoError(err)
+	req.True(strings.HasPrefix(baseUrl, "http://127.0.0.1:"))
+
+	request, err := http.NewRequest(http.MethodGet, baseUrl+"/ipfs/"+root.String(), nil)
+	req.NoError(err)
+	request.Header.Set("Accept", trustlesshttp.DefaultContentType().String())
+	res, err := http.DefaultClient.Do(request)
+	req.NoError(err)
+	br, err := car.NewBlockReader(res.Body)
+	req.NoError(err)
+	req.Equal([]cid.Cid{root}, br.Roots)
+	var blocks int
+	for {
+		if _, err := br.Next(); err != nil {
+			req.ErrorIs(err, io.EOF)
+			break
+		}
+		blocks++
+	}
+	req.Greater(blocks, 1)
+	req.NoError(res.Body.Close())
+
+	// the advertisement that was announced is published by the server
+	announced := stub.Announced()
+	req.Len(announced, 1)
+	res, err = http.Get(baseUrl + "/ipni/v1/ad/head")
+	req.NoError(err)
+	head, err := io.ReadAll(res.Body)
+	req.NoError(err)
+	req.NoError(res.Body.Close())
+	req.Equal(http.StatusOK, res.StatusCode, string(head))
+	req.Contains(string(head), announced[0].Cid.String())
+
+	req.NoError(shutdown())
+	_, err = http.Get(baseUrl + "/ipfs/" + root.String())
+	req.Error(err)
+}
+
+func TestStartMissingCar(t *testing.T) {
+	_, _, err := frisbiitest.Start(context.Background(), []string{filepath.Join(t.TempDir(), "nope.car")})
+	require.Error(t, err)
+}
+
+func mkCar(t *testing.T) (string, cid.Cid) {
+	req := require.New(t)
+	carPath := filepath.Join(t.TempDir(), "test.car")
+	carFile, err := os.Create(carPath)
+	req.NoError(err)
+	carWriter, err := storage.NewWritable(carFile, []cid.Cid{cid.MustParse("baeaaaiaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")}, car.WriteAsCarV1(true))
+	req.NoError(err)
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetWriteStorage(carWriter)
+	ent := unixfs.GenerateFile(t, &lsys, rand.New(rand.NewSource(1)), 1<<20)
+	req.NoError(carFile.Close())
+	req.NoError(car.ReplaceRootsInFile(carPath, []cid.Cid{ent.Root}))
+	return carPath, ent.Root
+}
@@ -5,8 +5,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"html/template"
 	"io"
 	"net/http"
+	"net/url"
+	"os"
 	"strings"
 	"sync"
 	"time"
@@ -16,8 +19,10 @@ import (
 	"github.com/ipld/go-ipld-prime/datamodel"
 	"github.com/ipld/go-ipld-prime/linking"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage"
 	trustlessutils "github.com/ipld/go-trustless-utils"
 	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var _ http.Handler = (*HttpIpfs)(nil)
@@ -37,7 +42,27 @@ type httpOptions struct {
 	MaxResponseBytes    int64
 	CompressionLevel    int
 	LogWriter           io.Writer
+	LogFallbackWriter   io.Writer
 	LogHandler          LogHandler
+	LogRedactQuery      bool
+	LogMinStatus        int
+	RequestObserver     RequestObserver
+	Authorizer          Authorizer
+	ResponseCache       *ResponseCache
+	DirectoryIndex      bool
+	Deserialized        bool
+	LastModified        time.Time
+	PresenceCheck       storage.Storage
+	ServableRoots       map[string]struct{}
+	Denylist            map[string][]DenylistEntry
+	DenylistMessage     string
+	ErrorTemplate       *template.Template
+	MaxHeaderBytes      int
+	MaxRequestURIBytes  int
+	MaxConnections      int
+	TCPKeepAlive        time.Duration
+	BasePath            string
+	PathPrefix          string
 }
 
 type HttpOption func(*httpOptions)
@@ -66,13 +91,18 @@ func WithMaxResponseBytes(b int64) HttpOption {
 	}
 }
 
-// WithCompressionLevel sets the compression level for the gzip compression
-// applied to the response. This allows for a trade-off between CPU and
-// bandwidth. By default, the compression level is set to gzip.NoCompression;
-// which means compression will be disabled.
+// WithCompressionLevel sets the compression level for the gzip or zstd
+// compression applied to the response. This allows for a trade-off between CPU
+// and bandwidth. By default, the compression level is set to
+// gzip.NoCompression; which means compression will be disabled.
 //
 // Other recommended choices are gzip.BestSpeed (1), gzip.BestCompression (9),
 // and gzip.DefaultCompression (typically 6).
+//
+// zstd is used in preference to gzip where a client accepts both. Levels are
+// mapped to the nearest zstd encoder level: 1-3 is the fastest, 4-6 (and
+// gzip.DefaultCompression) is the default, 7-8 is better compression and 9 is
+// the best compression.
 func WithCompressionLevel(l int) HttpOption {
 	return func(o *httpOptions) {
 		o.CompressionLevel = l
@@ -87,7 +117,7 @@ func WithCompressionLevel(l int) HttpOption {
 // elements, where the elements that may contain spaces are quoted. The format
 // of each line can be specified as:
 //
-//	%s %s %s "%s" %d %d %d %s "%s" "%s"
+//	%s %s %s "%s" %d %d %d %s "%s" "%s" %s %s
 //
 // Where the elements are:
 //
@@ -101,12 +131,28 @@ func WithCompressionLevel(l int) HttpOption {
 // 8. Compression ratio (or `-` if no compression)
 // 9. User agent
 // 10. Error (or `""` if no error)
+// 11. Response duration (in milliseconds, with microsecond precision)
+// 12. Time to first byte (in milliseconds, with microsecond precision), or the
+// response duration where no response body was written
 func WithLogWriter(w io.Writer) HttpOption {
 	return func(o *httpOptions) {
 		o.LogWriter = w
 	}
 }
 
+// WithLogFallbackWriter sets the writer that request logs are written to
+// where the writer set with WithLogWriter repeatedly fails, e.g. because it is
+// a closed pipe or is on a full disk. A warning is logged when this happens.
+// Where the fallback writer also repeatedly fails, or is nil, request logging
+// is disabled with a warning rather than failing on every request.
+//
+// The default is os.Stderr.
+func WithLogFallbackWriter(w io.Writer) HttpOption {
+	return func(o *httpOptions) {
+		o.LogFallbackWriter = w
+	}
+}
+
 // WithLogHandler sets a handler function that will be used to log requests. By
 // default, requests are not logged. This is an alternative to WithLogWriter
 // that allows for more control over the logging.
@@ -116,6 +162,313 @@ func WithLogHandler(h LogHandler) HttpOption {
 	}
 }
 
+// WithLogRedactQuery masks the values of query string parameters in request
+// logs, so that sensitive values such as capability tokens are not logged.
+// Parameters that are known to be safe, and are useful for understanding a
+// request, such as "format" and "dag-scope", are logged as-is.
+//
+// Query values are logged as-is by default.
+func WithLogRedactQuery(redact bool) HttpOption {
+	return func(o *httpOptions) {
+		o.LogRedactQuery = redact
+	}
+}
+
+// WithLogMinStatus sets the minimum response status code for a request to be
+// written to the request log, e.g. 400 to only log client and server errors.
+// Errors logged via ErrorLogger are always logged regardless of this setting.
+//
+// All requests are logged by default.
+func WithLogMinStatus(status int) HttpOption {
+	return func(o *httpOptions) {
+		o.LogMinStatus = status
+	}
+}
+
+// WithAuthorizer sets an Authorizer that decides whether each request for
+// content may be served, see Authorizer for when it's consulted. By default,
+// all requests are allowed.
+func WithAuthorizer(authorizer Authorizer) HttpOption {
+	return func(o *httpOptions) {
+		o.Authorizer = authorizer
+	}
+}
+
+// WithRequestObserver sets a RequestObserver that will be notified of each
+// completed request, as an alternative to parsing the request log. See
+// RequestObserver for details.
+func WithRequestObserver(observer RequestObserver) HttpOption {
+	return func(o *httpOptions) {
+		o.RequestObserver = observer
+	}
+}
+
+// WithResponseCache sets a ResponseCache that complete CAR responses will be
+// stored in, and served from for subsequent identical requests. See
+// ResponseCache for details.
+//
+// Responses are not cached by default.
+func WithResponseCache(cache *ResponseCache) HttpOption {
+	return func(o *httpOptions) {
+		o.ResponseCache = cache
+	}
+}
+
+// WithDirectoryIndex enables an HTML listing of UnixFS directories for clients
+// that request HTML (such as web browsers) rather than a CAR or raw block.
+// Requests for non-directory content, or from clients that accept a CAR or raw
+// block, are unaffected.
+//
+// Directory indexes are disabled by default.
+func WithDirectoryIndex(enabled bool) HttpOption {
+	return func(o *httpOptions) {
+		o.DirectoryIndex = enabled
+	}
+}
+
+// WithDeserialized enables serving the bytes of UnixFS files, as a plain web
+// server would, to clients that request application/octet-stream or HTML
+// (such as web browsers) rather than a CAR or raw block. Range requests on the
+// file are supported. A request for a UnixFS directory receives a directory
+// index where they are enabled with WithDirectoryIndex, or otherwise a 300
+// Multiple Choices listing the paths of its entries. Requests for content that
+// isn't UnixFS, and from clients that accept a CAR or raw block, or anything
+// (*/*), are unaffected.
+//
+// Deserialized responses can't be verified by the client, and aren't subject
+// to WithMaxResponseBytes or the response cache. They are disabled by default.
+func WithDeserialized(enabled bool) HttpOption {
+	return func(o *httpOptions) {
+		o.Deserialized = enabled
+	}
+}
+
+// WithLastModified sets the time reported in the Last-Modified header of CAR
+// and raw block responses, and enables conditional requests using
+// If-Modified-Since, which will receive a 304 Not Modified response where the
+// content hasn't been modified since the supplied time. Content addressed data
+// is immutable, so this would typically be the modification time of the
+// underlying data store (e.g. the most recently modified CAR file), or a fixed
+// epoch.
+//
+// As per RFC 9110, If-Modified-Since is ignored where the request includes an
+// If-None-Match header.
+//
+// By default, no Last-Modified header is sent and If-Modified-Since is
+// ignored.
+func WithLastModified(t time.Time) HttpOption {
+	return func(o *httpOptions) {
+		o.LastModified = t
+	}
+}
+
+// WithPresenceCheck sets a store that is checked for the presence of the root
+// CID of each request before any traversal begins, such that requests for a
+// root that isn't available are rejected with a 404 Not Found before any
+// response is sent. This should be a store with a fast Has(), such as a
+// MultiReadableStorage of indexed CARs where each check is an index lookup.
+//
+// Without a presence check, a request for an unavailable root fails when the
+// traversal attempts to load it. By default, no presence check is performed.
+func WithPresenceCheck(store storage.Storage) HttpOption {
+	return func(o *httpOptions) {
+		o.PresenceCheck = store
+	}
+}
+
+// WithServableRoots restricts the content that will be served to DAGs with
+// the given root CIDs, requests for any other root are rejected with a 404 Not
+// Found, even where the blocks are available. Roots are matched by multihash,
+// so CIDv0 and CIDv1 forms of the same root are treated equally. Blocks within
+// a servable DAG may still be fetched via a path from its root.
+//
+// By default, any available content may be served.
+func WithServableRoots(roots []cid.Cid) HttpOption {
+	return func(o *httpOptions) {
+		o.ServableRoots = make(map[string]struct{}, len(roots))
+		for _, root := range roots {
+			o.ServableRoots[string(root.Hash())] = struct{}{}
+		}
+	}
+}
+
+// WithDenylist refuses requests for the denied content with a 410 Gone,
+// before anything is resolved or traversed. An entry with an empty Path denies
+// the whole DAG under its root, otherwise only requests for a path at or below
+// Path within it. Roots are matched by multihash, so a denied root can't be
+// fetched by re-encoding its CID as another version or codec. Content reached
+// by a path from a root that isn't denied is not matched.
+//
+// By default, nothing is denied.
+func WithDenylist(entries []DenylistEntry) HttpOption {
+	return func(o *httpOptions) {
+		o.Denylist = make(map[string][]DenylistEntry, len(entries))
+		for _, entry := range entries {
+			key := string(entry.Root.Hash())
+			o.Denylist[key] = append(o.Denylist[key], entry)
+		}
+	}
+}
+
+// WithDenylistMessage sets the body of the 410 Gone response to a request for
+// content denied by WithDenylist. The default is DefaultDenylistMessage.
+func WithDenylistMessage(message string) HttpOption {
+	return func(o *httpOptions) {
+		o.DenylistMessage = message
+	}
+}
+
+// WithErrorTemplate sets an HTML template, executed with an ErrorPage, that
+// is rendered as the body of an error response to a client that prefers HTML,
+// such as a web browser, in place of the plain text message. Other clients,
+// and errors that occur once a response has started, are unaffected, as is
+// the message recorded in the request log. Where the template fails to
+// execute, the plain text message is sent. By default, no template is used.
+func WithErrorTemplate(tmpl *template.Template) HttpOption {
+	return func(o *httpOptions) {
+		o.ErrorTemplate = tmpl
+	}
+}
+
+// WithMaxHeaderBytes sets the maximum size of a request's request line and
+// headers. Requests that exceed it are rejected with a 431 Request Header
+// Fields Too Large. This also sets the http.Server MaxHeaderBytes of a
+// FrisbiiServer, which rejects grossly oversized requests before they reach
+// the handler, and so before they can be logged; net/http allows some slack
+// over this limit so requests slightly larger than it are rejected, and
+// logged, by the handler.
+//
+// A value of 0 disables the check in the handler and uses the net/http default
+// of http.DefaultMaxHeaderBytes (1 MiB) for a FrisbiiServer. The default is
+// DefaultMaxHeaderBytes.
+func WithMaxHeaderBytes(b int) HttpOption {
+	return func(o *httpOptions) {
+		o.MaxHeaderBytes = b
+	}
+}
+
+// WithMaxRequestURIBytes sets the maximum size of a request's URI, including
+// its query string, which is checked before any other part of the request is
+// parsed. Requests with a longer URI are rejected with a 414 URI Too Long.
+//
+// A value of 0 will disable the limitation. The default is
+// DefaultMaxRequestURIBytes.
+func WithMaxRequestURIBytes(b int) HttpOption {
+	return func(o *httpOptions) {
+		o.MaxRequestURIBytes = b
+	}
+}
+
+// WithMaxConnections sets the maximum number of connections that a
+// FrisbiiServer will have open at once, so that a server with limited file
+// descriptors can't exhaust them. Once the limit is reached, new connections
+// aren't accepted until an open connection is closed; they wait in the
+// listen backlog rather than being dropped. Idle keep-alive connections count
+// towards the limit. Reaching the limit is logged, at most once a minute.
+//
+// This is only applied to a FrisbiiServer when it is created, it has no
+// effect on an HttpIpfs handler. A value of 0 will disable the limitation.
+// This is the default.
+func WithMaxConnections(n int) HttpOption {
+	return func(o *httpOptions) {
+		o.MaxConnections = n
+	}
+}
+
+// WithTCPKeepAlive sets the keep-alive period of TCP connections accepted by a
+// FrisbiiServer, see net.ListenConfig.
+//
+// This is only applied to a FrisbiiServer listening on TCP when it is
+// created, it has no effect on an HttpIpfs handler. A value of 0 uses the Go
+// default (currently 15 seconds), which is the default, and a negative value
+// disables keep-alives.
+func WithTCPKeepAlive(d time.Duration) HttpOption {
+	return func(o *httpOptions) {
+		o.TCPKeepAlive = d
+	}
+}
+
+// WithBasePath serves all of a FrisbiiServer's content, including that of its
+// AddPrefix prefixes and its IPNI advertisements, under basePath, e.g.
+// "/gateway" for a server behind a reverse proxy that mounts it at /gateway/
+// without stripping the path. basePath is removed from request paths before
+// they are resolved, requests outside of it receive a 404 Not Found, and it is
+// included in the paths of links in directory indexes and in X-Ipfs-Path
+// headers. A trailing slash is ignored, and basePath can't include any of
+// "?#%".
+//
+// This is only applied to a FrisbiiServer when Serve is called, it has no
+// effect on an HttpIpfs handler, which can be mounted under a path with
+// http.StripPrefix. By default, content is served from the root.
+func WithBasePath(basePath string) HttpOption {
+	return func(o *httpOptions) {
+		o.BasePath = CleanBasePath(basePath)
+	}
+}
+
+// CleanBasePath returns basePath with a leading slash and no trailing slash,
+// or "" where it is empty or "/", as WithBasePath applies it. It is the path
+// that content is served under, which others, such as the path of the
+// announced IPNI handler, are relative to.
+func CleanBasePath(basePath string) string {
+	basePath = strings.TrimRight(basePath, "/")
+	if basePath != "" && basePath[0] != '/' {
+		basePath = "/" + basePath
+	}
+	return basePath
+}
+
+// stripBasePath serves requests under basePath with handler, with basePath
+// removed from their path, and responds to any other request with a 404 Not
+// Found.
+func stripBasePath(basePath string, handler http.Handler) http.Handler {
+	if basePath == "" {
+		return handler
+	}
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != basePath && !strings.HasPrefix(req.URL.Path, basePath+"/") {
+			http.NotFound(res, req)
+			return
+		}
+		r2 := new(http.Request)
+		*r2 = *req
+		r2.URL = new(url.URL)
+		*r2.URL = *req.URL
+		r2.URL.Path = rootIfEmpty(strings.TrimPrefix(req.URL.Path, basePath))
+		if req.URL.RawPath != "" {
+			// basePath has no escapes, so it prefixes RawPath as it does Path
+			r2.URL.RawPath = rootIfEmpty(strings.TrimPrefix(req.URL.RawPath, basePath))
+		}
+		handler.ServeHTTP(res, r2)
+	})
+}
+
+func rootIfEmpty(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+// withPathPrefix sets the path prefix that the handler is being served under,
+// which is stripped before the handler sees the request, so that links in
+// directory indexes can include it and responses are cached separately from
+// those of other prefixes.
+func withPathPrefix(prefix string) HttpOption {
+	return func(o *httpOptions) {
+		o.PathPrefix = prefix
+	}
+}
+
+// servable returns true if the DAG with the given root may be served.
+func (o *httpOptions) servable(root cid.Cid) bool {
+	if o.ServableRoots == nil {
+		return true
+	}
+	_, ok := o.ServableRoots[string(root.Hash())]
+	return ok
+}
+
 // NewHttpIpfs returns an http.Handler that serves IPLD data via HTTP according
 // to the Trustless Gateway specification.
 func NewHttpIpfs(
@@ -130,14 +483,54 @@ func NewHttpIpfs(
 		// mildly awkward level of wrapping going on here but HttpIpfs is really
 		// just a HandlerFunc->Handler converter
 		handlerFunc = gzipWrapper(&HttpIpfs{handlerFunc: handlerFunc}).ServeHTTP
+		// zstd wraps gzip so it can take precedence where both are accepted
+		handlerFunc = newZstdHandler(cfg.CompressionLevel, &HttpIpfs{handlerFunc: handlerFunc}).ServeHTTP
 		logger.Debugf("enabling compression with a level of %d", cfg.CompressionLevel)
 	}
 	return &HttpIpfs{handlerFunc: handlerFunc}
 }
 
+// allowedMethods is the value of the Allow header sent in response to OPTIONS
+// and to requests with an unsupported method.
+const allowedMethods = "GET, HEAD, OPTIONS"
+
+// supportedFormats lists the media types of the responses that may be served.
+func supportedFormats(cfg *httpOptions) string {
+	formats := trustlesshttp.MimeTypeCar + ", " + trustlesshttp.MimeTypeRaw
+	if cfg.Deserialized {
+		formats += ", " + MimeTypeOctetStream
+	}
+	if cfg.DirectoryIndex {
+		formats += ", text/html"
+	}
+	return formats
+}
+
+// encodedEtag returns etag with a suffix for the compression that will be
+// applied to the response, if any, so that compressed and uncompressed
+// responses are distinguished. The ETag of a compressed response is weak, as
+// its bytes depend on the compression level and implementation rather than
+// only on the content.
+func encodedEtag(res http.ResponseWriter, etag string) string {
+	switch res.(type) {
+	case *gziphandler.GzipResponseWriter, gziphandler.GzipResponseWriterWithCloseNotify:
+		// there are conditions where we may have a GzipResponseWriter but the
+		// response will not be compressed, but they are related to very small
+		// response sizes so this shouldn't matter (much)
+		return "W/" + etag[:len(etag)-1] + ".gz\""
+	case *zstdResponseWriter:
+		return "W/" + etag[:len(etag)-1] + ".zst\""
+	}
+	return etag
+}
+
 func toConfig(opts []HttpOption) *httpOptions {
 	cfg := &httpOptions{
-		CompressionLevel: gzip.NoCompression,
+		CompressionLevel:   gzip.NoCompression,
+		LogFallbackWriter:  os.Stderr,
+		MaxHeaderBytes:     DefaultMaxHeaderBytes,
+		MaxRequestURIBytes: DefaultMaxRequestURIBytes,
+		DenylistMessage:    DefaultDenylistMessage,
 	}
 	for _, opt := range opts {
 		opt(cfg)
@@ -163,11 +556,17 @@ func NewHttpIpfsHandlerFunc(
 			reqCtx, cancel = context.WithTimeout(ctx, cfg.MaxResponseDuration)
 			defer cancel()
 		}
+		reqCtx, span := startRequestSpan(reqCtx, req)
+		defer span.End()
 
 		var rootCid cid.Cid
 		bytesWrittenCh := make(chan struct{})
 
 		logError := func(status int, err error) {
+			recordSpanError(span, status, err)
+			if lrw := unwrapLoggingResponseWriter(res); lrw != nil {
+				lrw.err = err
+			}
 			select {
 			case <-bytesWrittenCh:
 				cs := "unknown"
@@ -184,9 +583,12 @@ func NewHttpIpfsHandlerFunc(
 				}
 				return
 			default:
-				res.WriteHeader(status)
-				if _, werr := res.Write([]byte(err.Error())); werr != nil {
-					logger.Debugw("unable to write error to response", "err", werr)
+				// a browser may be sent a rendered page in place of the message
+				if cfg.ErrorTemplate == nil || !acceptsHtml(req) || !writeErrorPage(res, req, cfg.ErrorTemplate, status, err.Error()) {
+					res.WriteHeader(status)
+					if _, werr := res.Write([]byte(err.Error())); werr != nil {
+						logger.Debugw("unable to write error to response", "err", werr)
+					}
 				}
 			}
 
@@ -197,17 +599,35 @@ func NewHttpIpfsHandlerFunc(
 			}
 		}
 
-		// filter out everything but GET requests
+		authorize := newAuthorize(reqCtx, req, cfg.Authorizer, logError)
+
+		if status, err := checkRequestSize(req, cfg); err != nil {
+			logError(status, err)
+			return
+		}
+
+		// filter out everything but GET and HEAD requests, and OPTIONS, which
+		// describes what we support without resolving or traversing anything
 		switch req.Method {
-		case http.MethodGet:
+		case http.MethodGet, http.MethodHead:
 			break
+		case http.MethodOptions:
+			res.Header().Set("Allow", allowedMethods)
+			res.Header().Set("X-Ipfs-Supported-Formats", supportedFormats(cfg))
+			res.WriteHeader(http.StatusNoContent)
+			return
 		default:
-			res.Header().Add("Allow", http.MethodGet)
+			res.Header().Set("Allow", allowedMethods)
 			logError(http.StatusMethodNotAllowed, errors.New("method not allowed"))
 			return
 		}
 
-		path := datamodel.ParsePath(req.URL.Path)
+		path, err := parseUrlPath(req.URL)
+		if err != nil {
+			logError(http.StatusBadRequest, err)
+			return
+		}
+		fullPath := path
 		_, path = path.Shift() // remove /ipfs
 
 		// check if CID path param is missing
@@ -217,12 +637,76 @@ func NewHttpIpfsHandlerFunc(
 			return
 		}
 
+		// denied content is refused before anything is resolved or traversed
+		if cfg.Denylist != nil {
+			cidSeg, subPath := path.Shift()
+			if c, err := cid.Parse(cidSeg.String()); err == nil {
+				if entry, denied := cfg.denied(c, subPath); denied {
+					if lrw := unwrapLoggingResponseWriter(res); lrw != nil {
+						lrw.rootCid = c
+					}
+					logError(http.StatusGone, &deniedError{entry: entry, root: c, path: subPath, message: cfg.DenylistMessage})
+					return
+				}
+			}
+		}
+
+		if probe, err := probeRequested(req); err != nil {
+			logError(http.StatusBadRequest, err)
+			return
+		} else if probe {
+			serveProbe(reqCtx, lsys, res, cfg, path, authorize, logError)
+			return
+		}
+
+		if cfg.DirectoryIndex && acceptsHtml(req) {
+			cidSeg, dirPath := path.Shift()
+			if dirRoot, err := cid.Parse(cidSeg.String()); err == nil && cfg.servable(dirRoot) {
+				if !authorize(dirRoot) {
+					return
+				}
+				if serveDirectoryIndex(reqCtx, lsys, res, cfg.PathPrefix, dirRoot, dirPath, logError) {
+					return
+				}
+			}
+		}
+
+		if cfg.Deserialized && acceptsDeserialized(req) {
+			cidSeg, filePath := path.Shift()
+			if rootCid, err = cid.Parse(cidSeg.String()); err != nil {
+				logError(http.StatusBadRequest, errors.New("failed to parse CID path parameter"))
+				return
+			}
+			span.SetAttributes(attribute.String("cid", rootCid.String()))
+			if lrw := unwrapLoggingResponseWriter(res); lrw != nil {
+				lrw.rootCid = rootCid
+			}
+			if !cfg.servable(rootCid) {
+				logError(http.StatusNotFound, fmt.Errorf("root not found: %s", rootCid))
+				return
+			}
+			if !authorize(rootCid) {
+				return
+			}
+			handled, err := serveDeserialized(reqCtx, lsys, res, req, cfg, rootCid, filePath, logError)
+			if err != nil {
+				close(bytesWrittenCh) // the file has started streaming
+				logError(http.StatusInternalServerError, err)
+			}
+			if handled {
+				return
+			}
+		}
+
 		// get the preferred list of  `Accept` headers if one exists; we should be
 		// able to handle whatever comes back from here.
 		// firsly we are looking for raw vs car, secondarily we're looking for the
 		// `dups` parameter if car.
 		accepts, err := trustlesshttp.CheckFormat(req)
 		if err != nil {
+			if carErr := checkCarAccept(req); carErr != nil {
+				err = carErr // more specific than the generic Accept error
+			}
 			logError(http.StatusBadRequest, err)
 			return
 		}
@@ -241,6 +725,10 @@ func NewHttpIpfsHandlerFunc(
 			logError(http.StatusBadRequest, errors.New("failed to parse CID path parameter"))
 			return
 		}
+		span.SetAttributes(attribute.String("cid", rootCid.String()))
+		if lrw := unwrapLoggingResponseWriter(res); lrw != nil {
+			lrw.rootCid = rootCid
+		}
 
 		var (
 			dagScope  trustlessutils.DagScope   = trustlessutils.DagScopeAll
@@ -254,6 +742,10 @@ func NewHttpIpfsHandlerFunc(
 			}
 		} else {
 			accept = accept.WithMimeType(trustlesshttp.MimeTypeCar) // correct for application/* and */*
+			if accept, err = negotiateCarParams(req, accept); err != nil {
+				logError(http.StatusBadRequest, err)
+				return
+			}
 
 			dagScope, err = trustlesshttp.ParseScope(req)
 			if err != nil {
@@ -276,57 +768,149 @@ func NewHttpIpfsHandlerFunc(
 			Duplicates: accept.Duplicates,
 		}
 
+		if !cfg.servable(rootCid) {
+			// indistinguishable from content that isn't present
+			logError(http.StatusNotFound, fmt.Errorf("root not found: %s", rootCid))
+			return
+		}
+
+		if cfg.PresenceCheck != nil {
+			if has, err := cfg.PresenceCheck.Has(reqCtx, rootCid.KeyString()); err != nil {
+				logError(http.StatusInternalServerError, err)
+				return
+			} else if !has {
+				logError(http.StatusNotFound, fmt.Errorf("root not found: %s", rootCid))
+				return
+			}
+		}
+
+		// before a response, even a 304 Not Modified, says anything of the content
+		if !authorize(rootCid) {
+			return
+		}
+
 		if fileName == "" {
 			fileName = fmt.Sprintf("%s%s", rootCid.String(), trustlesshttp.FilenameExtCar)
 		}
 
-		var writer io.Writer = newIpfsResponseWriter(res, cfg.MaxResponseBytes, func() {
-			// called once we start writing blocks into the CAR (on the first Put())
+		requestEtag := requestEtag(request, path)
+		etag := encodedEtag(res, requestEtag)
+		if accept.IsRaw() {
+			// a raw block is the same whatever the CAR parameters, but must not
+			// share a validator with a CAR of it
+			etag = encodedEtag(res, `"`+rootCid.String()+`.raw"`)
+		}
 
-			close(bytesWrittenCh) // signal that we've started writing, so we can't log errors to the response now
+		if noneMatch(req, etag) || notModifiedSince(req, cfg.LastModified) {
+			res.Header().Set("Cache-Control", trustlesshttp.ResponseCacheControlHeader)
+			res.Header().Set("Etag", etag)
+			if !cfg.LastModified.IsZero() {
+				res.Header().Set("Last-Modified", cfg.LastModified.UTC().Format(http.TimeFormat))
+			}
+			res.Header().Set("Vary", "Accept, Accept-Encoding")
+			res.WriteHeader(http.StatusNotModified)
+			return
+		}
 
+		setHeaders := func() {
 			res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
 			res.Header().Set("Cache-Control", trustlesshttp.ResponseCacheControlHeader)
 			res.Header().Set("Content-Type", accept.WithQuality(1).String())
-			etag := request.Etag()
-			switch res.(type) {
-			case *gziphandler.GzipResponseWriter, gziphandler.GzipResponseWriterWithCloseNotify:
-				// there are conditions where we may have a GzipResponseWriter but the
-				// response will not be compressed, but they are related to very small
-				// response sizes so this shouldn't matter (much)
-				etag = etag[:len(etag)-1] + ".gz\""
-			}
 			res.Header().Set("Etag", etag)
+			if !cfg.LastModified.IsZero() {
+				res.Header().Set("Last-Modified", cfg.LastModified.UTC().Format(http.TimeFormat))
+			}
 			res.Header().Set("X-Content-Type-Options", "nosniff")
-			res.Header().Set("X-Ipfs-Path", "/"+datamodel.ParsePath(req.URL.Path).String())
+			res.Header().Set("X-Ipfs-Path", urlPathEscape(fullPath))
 			res.Header().Set("Vary", "Accept, Accept-Encoding")
+		}
+
+		if req.Method == http.MethodHead {
+			// the same checks and headers as a GET, without the block being
+			// loaded or the DAG traversed
+			setHeaders()
+			res.WriteHeader(http.StatusOK)
+			return
+		}
+
+		var writer io.Writer = newIpfsResponseWriter(res, cfg.MaxResponseBytes, func() {
+			// called once we start writing blocks into the CAR (on the first Put())
+
+			close(bytesWrittenCh) // signal that we've started writing, so we can't log errors to the response now
+			setHeaders()
 		})
 
-		if lrw, ok := res.(*LoggingResponseWriter); ok {
+		if lrw := unwrapLoggingResponseWriter(res); lrw != nil {
 			writer = &countingWriter{writer, lrw}
-		} else if grw, ok := res.(*gziphandler.GzipResponseWriter); ok {
-			if lrw, ok := grw.ResponseWriter.(*LoggingResponseWriter); ok {
-				writer = &countingWriter{writer, lrw}
-			}
 		}
 
 		if accept.IsRaw() {
 			// send the raw block bytes as the response
-			if byts, err := lsys.LoadRaw(linking.LinkContext{Ctx: reqCtx}, cidlink.Link{Cid: rootCid}); err != nil {
+			loadCtx, loadSpan := tracer.Start(reqCtx, "LoadRaw")
+			byts, err := lsys.LoadRaw(linking.LinkContext{Ctx: loadCtx}, cidlink.Link{Cid: rootCid})
+			loadSpan.End()
+			if err != nil {
 				logError(http.StatusInternalServerError, err)
 			} else if _, err := writer.Write(byts); err != nil {
 				logError(http.StatusInternalServerError, err)
 			}
-		} else {
+		} else if cfg.ResponseCache == nil {
 			// IsCar, so stream the CAR as the response
-			if err := StreamCar(reqCtx, lsys, writer, request); err != nil {
+			if err := streamCar(reqCtx, lsys, writer, request, path); err != nil {
 				logger.Debugw("error streaming CAR", "cid", rootCid, "err", err)
 				logError(http.StatusInternalServerError, err)
 			}
+		} else if cached, err := cfg.ResponseCache.serve(cfg.PathPrefix+requestEtag, writer); cached {
+			if err != nil {
+				logger.Debugw("error sending cached CAR", "cid", rootCid, "err", err)
+				logError(http.StatusInternalServerError, err)
+			}
+		} else {
+			// stream the CAR as the response, collecting a copy for the cache
+			cacheWriter := cfg.ResponseCache.create(cfg.PathPrefix + requestEtag)
+			if err := streamCar(reqCtx, lsys, io.MultiWriter(writer, cacheWriter), request, path); err != nil {
+				cacheWriter.abort()
+				logger.Debugw("error streaming CAR", "cid", rootCid, "err", err)
+				logError(http.StatusInternalServerError, err)
+			} else {
+				cacheWriter.commit()
+			}
 		}
 	}
 }
 
+// noneMatch returns true if the request has an If-None-Match header that
+// matches etag, using the weak comparison that RFC 9110 requires for
+// If-None-Match, so W/"x" matches "x".
+func noneMatch(req *http.Request, etag string) bool {
+	inm := req.Header.Get("If-None-Match")
+	if inm == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(inm, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// notModifiedSince returns true if the request has an If-Modified-Since header
+// that is not earlier than lastModified, and no If-None-Match header which
+// would take precedence over it.
+func notModifiedSince(req *http.Request, lastModified time.Time) bool {
+	if lastModified.IsZero() || req.Header.Get("If-None-Match") != "" {
+		return false
+	}
+	ims, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	// HTTP dates have a resolution of one second
+	return !lastModified.Truncate(time.Second).After(ims)
+}
+
 var _ io.Writer = (*countingWriter)(nil)
 
 type countingWriter struct {
@@ -1,13 +1,17 @@
 package frisbii_test
 
 import (
+	"compress/gzip"
 	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-unixfsnode"
@@ -339,3 +343,471 @@ func mkDupy(lsys linking.LinkSystem) ([]cid.Cid, []cid.Cid) {
 
 	return dupyLinks, dupyLinksDeduped
 }
+
+func TestHttpIpfsLastModified(t *testing.T) {
+	store := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	dupyLinks, _ := mkDupy(lsys)
+
+	lastModified := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	lastModifiedStr := lastModified.Format(http.TimeFormat)
+
+	for _, tc := range []struct {
+		name                 string
+		lastModified         time.Time
+		headers              map[string]string
+		expectedStatusCode   int
+		expectedLastModified string
+	}{
+		{
+			name:               "not configured",
+			headers:            map[string]string{"If-Modified-Since": lastModifiedStr},
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:                 "unconditional",
+			lastModified:         lastModified,
+			expectedStatusCode:   http.StatusOK,
+			expectedLastModified: lastModifiedStr,
+		},
+		{
+			name:                 "not modified since same time",
+			lastModified:         lastModified,
+			headers:              map[string]string{"If-Modified-Since": lastModifiedStr},
+			expectedStatusCode:   http.StatusNotModified,
+			expectedLastModified: lastModifiedStr,
+		},
+		{
+			name:                 "not modified since later time",
+			lastModified:         lastModified,
+			headers:              map[string]string{"If-Modified-Since": lastModified.Add(time.Hour).Format(http.TimeFormat)},
+			expectedStatusCode:   http.StatusNotModified,
+			expectedLastModified: lastModifiedStr,
+		},
+		{
+			name:                 "modified since earlier time",
+			lastModified:         lastModified,
+			headers:              map[string]string{"If-Modified-Since": lastModified.Add(-time.Hour).Format(http.TimeFormat)},
+			expectedStatusCode:   http.StatusOK,
+			expectedLastModified: lastModifiedStr,
+		},
+		{
+			name:         "If-None-Match takes precedence",
+			lastModified: lastModified,
+			headers: map[string]string{
+				"If-Modified-Since": lastModifiedStr,
+				"If-None-Match":     `"nope"`,
+			},
+			expectedStatusCode:   http.StatusOK,
+			expectedLastModified: lastModifiedStr,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+
+			handler := frisbii.NewHttpIpfs(context.Background(), lsys, frisbii.WithLastModified(tc.lastModified))
+			testServer := httptest.NewServer(handler)
+			defer testServer.Close()
+
+			request, err := http.NewRequest(http.MethodGet, testServer.URL+"/ipfs/"+dupyLinks[0].String(), nil)
+			req.NoError(err)
+			request.Header.Set("Accept", trustlesshttp.DefaultContentType().String())
+			for k, v := range tc.headers {
+				request.Header.Set(k, v)
+			}
+			res, err := http.DefaultClient.Do(request)
+			req.NoError(err)
+			body, err := io.ReadAll(res.Body)
+			req.NoError(err)
+			req.Equal(tc.expectedStatusCode, res.StatusCode)
+			req.Equal(tc.expectedLastModified, res.Header.Get("Last-Modified"))
+			req.NotEmpty(res.Header.Get("Etag"))
+			if tc.expectedStatusCode == http.StatusNotModified {
+				req.Empty(body)
+			} else {
+				req.NotEmpty(body)
+			}
+		})
+	}
+}
+
+func TestHttpIpfsEtag(t *testing.T) {
+	req := require.New(t)
+
+	store := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	dupyLinks, _ := mkDupy(lsys)
+	root := dupyLinks[0].String()
+
+	handler := frisbii.NewHttpIpfs(context.Background(), lsys, frisbii.WithCompressionLevel(gzip.BestSpeed))
+	testServer := httptest.NewServer(handler)
+	defer testServer.Close()
+
+	get := func(req *require.Assertions, accept, acceptEncoding, ifNoneMatch string) *http.Response {
+		request, err := http.NewRequest(http.MethodGet, testServer.URL+"/ipfs/"+root, nil)
+		req.NoError(err)
+		request.Header.Set("Accept", accept)
+		// set explicitly so the client doesn't negotiate, or decode, gzip itself
+		request.Header.Set("Accept-Encoding", acceptEncoding)
+		if ifNoneMatch != "" {
+			request.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		res, err := http.DefaultClient.Do(request)
+		req.NoError(err)
+		_, err = io.ReadAll(res.Body)
+		req.NoError(err)
+		req.NoError(res.Body.Close())
+		return res
+	}
+
+	carType := trustlesshttp.DefaultContentType().String()
+	etags := make(map[string]string)
+	for _, encoding := range []string{"identity", "gzip", "zstd"} {
+		res := get(req, carType, encoding, "")
+		req.Equal(http.StatusOK, res.StatusCode)
+		req.Equal("Accept, Accept-Encoding", res.Header.Get("Vary"))
+		etags[encoding] = res.Header.Get("Etag")
+	}
+	req.Regexp(`^"`+root+`\.car\.\w{12,13}"$`, etags["identity"])
+	req.Equal("W/"+strings.TrimSuffix(etags["identity"], `"`)+`.gz"`, etags["gzip"])
+	req.Equal("W/"+strings.TrimSuffix(etags["identity"], `"`)+`.zst"`, etags["zstd"])
+
+	rawRes := get(req, trustlesshttp.MimeTypeRaw, "identity", "")
+	req.Equal(http.StatusOK, rawRes.StatusCode)
+	req.Equal("Accept, Accept-Encoding", rawRes.Header.Get("Vary"))
+	req.Equal(`"`+root+`.raw"`, rawRes.Header.Get("Etag"))
+
+	for _, tc := range []struct {
+		name           string
+		accept         string
+		acceptEncoding string
+		ifNoneMatch    string
+		expectedStatus int
+	}{
+		{"identity matches", carType, "identity", etags["identity"], http.StatusNotModified},
+		{"gzip matches", carType, "gzip", etags["gzip"], http.StatusNotModified},
+		{"zstd matches", carType, "zstd", etags["zstd"], http.StatusNotModified},
+		{"weak comparison", carType, "gzip", strings.TrimPrefix(etags["gzip"], "W/"), http.StatusNotModified},
+		{"list", carType, "zstd", `"nope", ` + etags["zstd"], http.StatusNotModified},
+		{"any", carType, "identity", "*", http.StatusNotModified},
+		{"raw matches", trustlesshttp.MimeTypeRaw, "identity", `"` + root + `.raw"`, http.StatusNotModified},
+		{"identity validator, gzip response", carType, "gzip", etags["identity"], http.StatusOK},
+		{"gzip validator, identity response", carType, "identity", etags["gzip"], http.StatusOK},
+		{"CAR validator, raw response", trustlesshttp.MimeTypeRaw, "identity", etags["identity"], http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+			res := get(req, tc.accept, tc.acceptEncoding, tc.ifNoneMatch)
+			req.Equal(tc.expectedStatus, res.StatusCode)
+			req.Equal("Accept, Accept-Encoding", res.Header.Get("Vary"))
+			req.NotEmpty(res.Header.Get("Etag"))
+			// without WithLastModified, there's no date to send, even on a 304
+			_, hasLastModified := res.Header["Last-Modified"]
+			req.False(hasLastModified)
+		})
+	}
+}
+
+func TestHttpIpfsPresenceCheck(t *testing.T) {
+	store := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	dupyLinks, _ := mkDupy(lsys)
+	multistore := frisbii.NewMultiReadableStorage()
+	multistore.AddStore(store, []cid.Cid{dupyLinks[0]})
+	missing := randBlock().cid
+
+	for _, tc := range []struct {
+		name               string
+		root               cid.Cid
+		accept             string
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{
+			name:               "car present",
+			root:               dupyLinks[0],
+			accept:             trustlesshttp.DefaultContentType().String(),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "raw present",
+			root:               dupyLinks[0],
+			accept:             trustlesshttp.MimeTypeRaw,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "car missing",
+			root:               missing,
+			accept:             trustlesshttp.DefaultContentType().String(),
+			expectedStatusCode: http.StatusNotFound,
+			expectedBody:       "root not found: " + missing.String(),
+		},
+		{
+			name:               "raw missing",
+			root:               missing,
+			accept:             trustlesshttp.MimeTypeRaw,
+			expectedStatusCode: http.StatusNotFound,
+			expectedBody:       "root not found: " + missing.String(),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+
+			var loggedStatus int
+			var loggedMsg string
+			opts := []frisbii.HttpOption{
+				frisbii.WithPresenceCheck(multistore),
+				frisbii.WithLogHandler(func(time time.Time, remoteAddr, method string, url url.URL, status int, duration time.Duration, bytes int, compressionRatio, userAgent, msg string) {
+					loggedStatus = status
+					loggedMsg = msg
+				}),
+			}
+			handler := frisbii.NewLogMiddleware(frisbii.NewHttpIpfs(context.Background(), lsys, opts...), opts...)
+			testServer := httptest.NewServer(handler)
+			defer testServer.Close()
+
+			request, err := http.NewRequest(http.MethodGet, testServer.URL+"/ipfs/"+tc.root.String(), nil)
+			req.NoError(err)
+			request.Header.Set("Accept", tc.accept)
+			res, err := http.DefaultClient.Do(request)
+			req.NoError(err)
+			body, err := io.ReadAll(res.Body)
+			req.NoError(err)
+			testServer.Close() // wait for the request to complete and be logged
+			req.Equal(tc.expectedStatusCode, res.StatusCode)
+			req.Equal(tc.expectedStatusCode, loggedStatus)
+			if tc.expectedStatusCode == http.StatusOK {
+				req.NotEmpty(body)
+				req.Equal(`""`, loggedMsg)
+			} else {
+				req.Equal(tc.expectedBody, string(body))
+				req.Equal(strconv.Quote(tc.expectedBody), loggedMsg)
+				req.Empty(res.Header.Get("Etag"))
+			}
+		})
+	}
+}
+
+func TestHttpIpfsServableRoots(t *testing.T) {
+	store := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	unixfsnode.AddUnixFSReificationToLinkSystem(&lsys)
+
+	public := mkUnixfsDir(t, lsys, map[string]datamodel.Link{"file": mkUnixfsFile(t, lsys, []byte("public"))}).(cidlink.Link).Cid
+	private := mkUnixfsDir(t, lsys, map[string]datamodel.Link{"file": mkUnixfsFile(t, lsys, []byte("private"))}).(cidlink.Link).Cid
+	// roots are matched by multihash, regardless of CID version
+	publicV0 := cid.NewCidV0(public.Hash())
+
+	handler := frisbii.NewHttpIpfs(context.Background(), lsys, frisbii.WithServableRoots([]cid.Cid{publicV0}), frisbii.WithDirectoryIndex(true))
+	testServer := httptest.NewServer(handler)
+	defer testServer.Close()
+
+	for _, tc := range []struct {
+		name               string
+		path               string
+		accept             string
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{
+			name:               "servable",
+			path:               "/ipfs/" + public.String(),
+			accept:             trustlesshttp.DefaultContentType().String(),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "servable with path",
+			path:               "/ipfs/" + public.String() + "/file",
+			accept:             trustlesshttp.DefaultContentType().String(),
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "servable raw",
+			path:               "/ipfs/" + public.String(),
+			accept:             trustlesshttp.MimeTypeRaw,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "not servable",
+			path:               "/ipfs/" + private.String(),
+			accept:             trustlesshttp.DefaultContentType().String(),
+			expectedStatusCode: http.StatusNotFound,
+			expectedBody:       "root not found: " + private.String(),
+		},
+		{
+			name:               "not servable raw",
+			path:               "/ipfs/" + private.String(),
+			accept:             trustlesshttp.MimeTypeRaw,
+			expectedStatusCode: http.StatusNotFound,
+			expectedBody:       "root not found: " + private.String(),
+		},
+		{
+			// handled as it would be for absent content, with no index
+			name:               "not servable directory index",
+			path:               "/ipfs/" + private.String(),
+			accept:             "text/html",
+			expectedStatusCode: http.StatusBadRequest,
+			expectedBody:       `invalid Accept header; unsupported: "text/html"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+			request, err := http.NewRequest(http.MethodGet, testServer.URL+tc.path, nil)
+			req.NoError(err)
+			request.Header.Set("Accept", tc.accept)
+			res, err := http.DefaultClient.Do(request)
+			req.NoError(err)
+			body, err := io.ReadAll(res.Body)
+			req.NoError(err)
+			req.Equal(tc.expectedStatusCode, res.StatusCode)
+			if tc.expectedStatusCode == http.StatusOK {
+				req.NotEmpty(body)
+			} else {
+				req.Equal(tc.expectedBody, string(body))
+			}
+		})
+	}
+}
+
+func TestHttpIpfsOptions(t *testing.T) {
+	// nothing should be loaded to answer an OPTIONS request
+	var loads int
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.StorageReadOpener = func(linking.LinkContext, datamodel.Link) (io.Reader, error) {
+		loads++
+		return nil, fmt.Errorf("unexpected load")
+	}
+	someCid := "bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi"
+
+	for _, tc := range []struct {
+		name          string
+		method        string
+		path          string
+		opts          []frisbii.HttpOption
+		expectStatus  int
+		expectFormats string
+	}{
+		{
+			name:          "OPTIONS",
+			method:        http.MethodOptions,
+			path:          "/ipfs/" + someCid + "/some/path?format=car",
+			expectStatus:  http.StatusNoContent,
+			expectFormats: "application/vnd.ipld.car, application/vnd.ipld.raw",
+		},
+		{
+			name:          "OPTIONS with directory index",
+			method:        http.MethodOptions,
+			path:          "/ipfs/" + someCid,
+			opts:          []frisbii.HttpOption{frisbii.WithDirectoryIndex(true)},
+			expectStatus:  http.StatusNoContent,
+			expectFormats: "application/vnd.ipld.car, application/vnd.ipld.raw, text/html",
+		},
+		{
+			name:          "OPTIONS with invalid CID",
+			method:        http.MethodOptions,
+			path:          "/ipfs/nope",
+			expectStatus:  http.StatusNoContent,
+			expectFormats: "application/vnd.ipld.car, application/vnd.ipld.raw",
+		},
+		{
+			name:         "POST",
+			method:       http.MethodPost,
+			path:         "/ipfs/" + someCid,
+			expectStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:         "PUT",
+			method:       http.MethodPut,
+			path:         "/ipfs/" + someCid,
+			expectStatus: http.StatusMethodNotAllowed,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+			handler := frisbii.NewHttpIpfs(context.Background(), lsys, tc.opts...)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+
+			req.Equal(tc.expectStatus, rec.Code)
+			req.Equal("GET, HEAD, OPTIONS", rec.Header().Get("Allow"))
+			req.Equal(tc.expectFormats, rec.Header().Get("X-Ipfs-Supported-Formats"))
+			req.Zero(loads)
+		})
+	}
+}
+
+func TestHttpIpfsHead(t *testing.T) {
+	store := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	unixfsnode.AddUnixFSReificationToLinkSystem(&lsys)
+	fileCid := mkUnixfsFile(t, lsys, []byte("hello world")).(cidlink.Link).Cid
+	missing := randBlock().cid
+	carType := trustlesshttp.DefaultContentType().String()
+
+	// count the blocks loaded, a HEAD of a CAR or raw block shouldn't load any
+	var loads int
+	countingLsys := lsys
+	countingLsys.StorageReadOpener = func(lc linking.LinkContext, l datamodel.Link) (io.Reader, error) {
+		loads++
+		return lsys.StorageReadOpener(lc, l)
+	}
+	handler := frisbii.NewHttpIpfs(context.Background(), countingLsys,
+		frisbii.WithPresenceCheck(store),
+		frisbii.WithDeserialized(true),
+		frisbii.WithLastModified(time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)),
+	)
+	testServer := httptest.NewServer(handler)
+	defer testServer.Close()
+
+	do := func(req *require.Assertions, method, path, accept string) *http.Response {
+		request, err := http.NewRequest(method, testServer.URL+path, nil)
+		req.NoError(err)
+		request.Header.Set("Accept", accept)
+		res, err := http.DefaultClient.Do(request)
+		req.NoError(err)
+		_, err = io.ReadAll(res.Body)
+		req.NoError(err)
+		req.NoError(res.Body.Close())
+		return res
+	}
+
+	for _, tc := range []struct {
+		name         string
+		path         string
+		accept       string
+		expectStatus int
+		expectLoads  bool
+	}{
+		{"CAR", "/ipfs/" + fileCid.String(), carType, http.StatusOK, false},
+		{"raw", "/ipfs/" + fileCid.String(), trustlesshttp.MimeTypeRaw, http.StatusOK, false},
+		{"deserialized", "/ipfs/" + fileCid.String(), frisbii.MimeTypeOctetStream, http.StatusOK, true},
+		{"missing root", "/ipfs/" + missing.String(), carType, http.StatusNotFound, false},
+		{"bad request", "/ipfs/" + fileCid.String() + "?dag-scope=nope", carType, http.StatusBadRequest, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+
+			getRes := do(req, http.MethodGet, tc.path, tc.accept)
+			loads = 0
+			headRes := do(req, http.MethodHead, tc.path, tc.accept)
+			req.Equal(tc.expectStatus, getRes.StatusCode)
+			req.Equal(tc.expectStatus, headRes.StatusCode)
+			if !tc.expectLoads {
+				req.Zero(loads)
+			}
+			for _, header := range []string{"Content-Type", "Content-Disposition", "Cache-Control", "Etag", "Last-Modified", "X-Ipfs-Path", "Vary"} {
+				req.Equal(getRes.Header.Get(header), headRes.Header.Get(header), header)
+			}
+		})
+	}
+}
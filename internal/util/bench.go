@@ -0,0 +1,218 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+)
+
+// BenchConfig describes a load test against a Trustless Gateway server.
+type BenchConfig struct {
+	// Target is the base URL of the server, e.g. http://localhost:3747.
+	Target      string
+	Root        cid.Cid
+	Scope       trustlessutils.DagScope
+	Concurrency int
+	Duration    time.Duration
+	Client      *http.Client
+}
+
+// BenchResult summarises a load test. Latencies are measured from the start of
+// a request until the complete CAR has been received and verified.
+type BenchResult struct {
+	Requests      int           `json:"requests"`
+	Errors        int           `json:"errors"`
+	ErrorRate     float64       `json:"errorRate"`
+	Bytes         int64         `json:"bytes"`
+	Elapsed       time.Duration `json:"elapsedNs"`
+	RequestsPerS  float64       `json:"requestsPerSecond"`
+	BytesPerS     float64       `json:"bytesPerSecond"`
+	LatencyP50    time.Duration `json:"latencyP50Ns"`
+	LatencyP95    time.Duration `json:"latencyP95Ns"`
+	LatencyP99    time.Duration `json:"latencyP99Ns"`
+	LatencyMax    time.Duration `json:"latencyMaxNs"`
+	FirstError    string        `json:"firstError,omitempty"`
+	ErrorsByClass []ErrorCount  `json:"errorsByClass,omitempty"`
+}
+
+// ErrorCount is the number of failed requests sharing the same error.
+type ErrorCount struct {
+	Error string `json:"error"`
+	Count int    `json:"count"`
+}
+
+type benchSample struct {
+	latency time.Duration
+	bytes   int64
+	err     error
+}
+
+// Bench issues CAR requests for cfg.Root from cfg.Concurrency workers until
+// cfg.Duration has elapsed, verifying that each response is a well-formed CAR
+// containing correctly hashed blocks, with the requested root. Requests in
+// flight when the duration elapses are allowed to complete.
+func Bench(ctx context.Context, cfg BenchConfig) (BenchResult, error) {
+	if cfg.Concurrency < 1 {
+		return BenchResult{}, errors.New("concurrency must be at least 1")
+	}
+	if cfg.Duration <= 0 {
+		return BenchResult{}, errors.New("duration must be greater than zero")
+	}
+	if cfg.Scope == "" {
+		cfg.Scope = trustlessutils.DagScopeAll
+	}
+	client := cfg.Client
+	if client == nil {
+		client = &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: cfg.Concurrency}}
+	}
+	url := fmt.Sprintf("%s/ipfs/%s?dag-scope=%s", strings.TrimSuffix(cfg.Target, "/"), cfg.Root, cfg.Scope)
+
+	var (
+		lk      sync.Mutex
+		samples = make([]benchSample, 0)
+		wg      sync.WaitGroup
+	)
+	start := time.Now()
+	deadline := start.Add(cfg.Duration)
+	for i := 0; i < cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) && ctx.Err() == nil {
+				reqStart := time.Now()
+				n, err := benchRequest(ctx, client, url, cfg.Root)
+				if ctx.Err() != nil {
+					return // interrupted, not a failure of the server
+				}
+				lk.Lock()
+				samples = append(samples, benchSample{latency: time.Since(reqStart), bytes: n, err: err})
+				lk.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return BenchResult{}, err
+	}
+	return summarise(samples, time.Since(start)), nil
+}
+
+func benchRequest(ctx context.Context, client *http.Client, url string, root cid.Cid) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", trustlesshttp.DefaultContentType().String())
+	req.Header.Set("User-Agent", "frisbii-bench")
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, res.Body)
+		return 0, fmt.Errorf("unexpected status: %s", res.Status)
+	}
+	cr := &countingReader{r: res.Body}
+	err = VerifyCar(cr, root)
+	return cr.n, err
+}
+
+// VerifyCar reads a complete CARv1 from r, checking that it has the single
+// root expected, that the first block is the root, and that every block's
+// content matches its CID.
+func VerifyCar(r io.Reader, root cid.Cid) error {
+	// an untrusted BlockReader (the default) verifies each block's hash
+	carReader, err := car.NewBlockReader(r)
+	if err != nil {
+		return fmt.Errorf("invalid CAR: %w", err)
+	}
+	if len(carReader.Roots) != 1 || !carReader.Roots[0].Equals(root) {
+		return fmt.Errorf("unexpected CAR roots: %v", carReader.Roots)
+	}
+	for i := 0; ; i++ {
+		blk, err := carReader.Next()
+		if err == io.EOF {
+			if i == 0 {
+				return errors.New("CAR has no blocks")
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid CAR block %d: %w", i, err)
+		}
+		if i == 0 && !blk.Cid().Equals(root) {
+			return fmt.Errorf("first CAR block is %s, not the root", blk.Cid())
+		}
+	}
+}
+
+func summarise(samples []benchSample, elapsed time.Duration) BenchResult {
+	result := BenchResult{Requests: len(samples), Elapsed: elapsed}
+	if len(samples) == 0 {
+		return result
+	}
+	latencies := make([]time.Duration, 0, len(samples))
+	errorCounts := make(map[string]int)
+	for _, s := range samples {
+		latencies = append(latencies, s.latency)
+		result.Bytes += s.bytes
+		if s.err != nil {
+			result.Errors++
+			if result.FirstError == "" {
+				result.FirstError = s.err.Error()
+			}
+			errorCounts[s.err.Error()]++
+		}
+	}
+	for e, n := range errorCounts {
+		result.ErrorsByClass = append(result.ErrorsByClass, ErrorCount{Error: e, Count: n})
+	}
+	sort.Slice(result.ErrorsByClass, func(i, j int) bool {
+		if result.ErrorsByClass[i].Count != result.ErrorsByClass[j].Count {
+			return result.ErrorsByClass[i].Count > result.ErrorsByClass[j].Count
+		}
+		return result.ErrorsByClass[i].Error < result.ErrorsByClass[j].Error
+	})
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	result.ErrorRate = float64(result.Errors) / float64(result.Requests)
+	result.RequestsPerS = float64(result.Requests) / elapsed.Seconds()
+	result.BytesPerS = float64(result.Bytes) / elapsed.Seconds()
+	result.LatencyP50 = percentile(latencies, 0.50)
+	result.LatencyP95 = percentile(latencies, 0.95)
+	result.LatencyP99 = percentile(latencies, 0.99)
+	result.LatencyMax = latencies[len(latencies)-1]
+	return result
+}
+
+// percentile returns the nearest-rank percentile p of sorted.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	idx := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return sorted[idx]
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,99 @@
+package util_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/frisbii"
+	util "github.com/ipld/frisbii/internal/util"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+	trustlesstestutil "github.com/ipld/go-trustless-utils/testutil"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBench(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	store := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	lnk, err := lsys.Store(linking.LinkContext{}, cidlink.LinkPrototype{Prefix: cid.Prefix{
+		Version:  1,
+		Codec:    uint64(multicodec.Raw),
+		MhType:   uint64(multicodec.Sha2_256),
+		MhLength: -1,
+	}}, basicnode.NewBytes([]byte("bench block")))
+	req.NoError(err)
+	root := lnk.(cidlink.Link).Cid
+
+	t.Run("valid", func(t *testing.T) {
+		req := require.New(t)
+		server := httptest.NewServer(frisbii.NewHttpIpfs(ctx, lsys))
+		defer server.Close()
+
+		result, err := util.Bench(ctx, util.BenchConfig{Target: server.URL, Root: root, Concurrency: 4, Duration: 200 * time.Millisecond})
+		req.NoError(err)
+		req.Greater(result.Requests, 0)
+		req.Zero(result.Errors)
+		req.Zero(result.ErrorRate)
+		req.Greater(result.Bytes, int64(0))
+		req.Greater(result.RequestsPerS, float64(0))
+		req.LessOrEqual(result.LatencyP50, result.LatencyP95)
+		req.LessOrEqual(result.LatencyP95, result.LatencyP99)
+		req.LessOrEqual(result.LatencyP99, result.LatencyMax)
+	})
+
+	t.Run("corrupt", func(t *testing.T) {
+		req := require.New(t)
+		rec := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/ipfs/"+root.String(), nil)
+		request.Header.Set("Accept", trustlesshttp.DefaultContentType().String())
+		frisbii.NewHttpIpfs(ctx, lsys).ServeHTTP(rec, request)
+		req.Equal(http.StatusOK, rec.Code)
+		corrupt := rec.Body.Bytes()
+		corrupt[len(corrupt)-1] ^= 0xff // within the block data
+		server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			res.Write(corrupt)
+		}))
+		defer server.Close()
+
+		result, err := util.Bench(ctx, util.BenchConfig{Target: server.URL, Root: root, Concurrency: 2, Duration: 100 * time.Millisecond})
+		req.NoError(err)
+		req.Greater(result.Requests, 0)
+		req.Equal(result.Requests, result.Errors)
+		req.Equal(float64(1), result.ErrorRate)
+		req.Len(result.ErrorsByClass, 1)
+		req.True(strings.HasPrefix(result.FirstError, "invalid CAR block 0: mismatch in content integrity"), result.FirstError)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		req := require.New(t)
+		server := httptest.NewServer(http.NotFoundHandler())
+		defer server.Close()
+
+		result, err := util.Bench(ctx, util.BenchConfig{Target: server.URL, Root: root, Concurrency: 2, Duration: 100 * time.Millisecond})
+		req.NoError(err)
+		req.Equal(result.Requests, result.Errors)
+		req.Equal("unexpected status: 404 Not Found", result.FirstError)
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		req := require.New(t)
+		_, err := util.Bench(ctx, util.BenchConfig{Target: "http://localhost", Root: root, Duration: time.Second})
+		req.ErrorContains(err, "concurrency must be at least 1")
+		_, err = util.Bench(ctx, util.BenchConfig{Target: "http://localhost", Root: root, Concurrency: 1})
+		req.ErrorContains(err, "duration must be greater than zero")
+	})
+}
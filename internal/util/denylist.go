@@ -0,0 +1,87 @@
+package util
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/frisbii"
+	"github.com/ipld/go-ipld-prime/datamodel"
+)
+
+// LoadDenylist reads a list of content that must not be served from the file
+// at path, one entry per line. An entry is a CID, denying the whole DAG under
+// it, optionally followed by a path within the DAG, e.g. "<cid>/dir/file",
+// denying only that path and anything below it. A leading "/ipfs/" is
+// optional, and path segments may be URL escaped as they are in requests.
+// Blank lines and lines starting with "#" are ignored. An empty denylist is
+// valid.
+func LoadDenylist(path string) ([]frisbii.DenylistEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := make([]frisbii.DenylistEntry, 0)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		entry, err := parseDenylistEntry(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid denylist entry %q: %w", path, line, text, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func parseDenylistEntry(text string) (frisbii.DenylistEntry, error) {
+	text = strings.TrimPrefix(strings.TrimPrefix(text, "/"), "ipfs/")
+	segs := strings.FieldsFunc(text, func(r rune) bool { return r == '/' })
+	if len(segs) == 0 {
+		return frisbii.DenylistEntry{}, errors.New("missing CID")
+	}
+	root, err := cid.Parse(segs[0])
+	if err != nil {
+		return frisbii.DenylistEntry{}, err
+	}
+	pathSegs := make([]datamodel.PathSegment, 0, len(segs)-1)
+	for _, seg := range segs[1:] {
+		unescaped, err := url.PathUnescape(seg)
+		if err != nil {
+			return frisbii.DenylistEntry{}, fmt.Errorf("invalid path segment: %w", err)
+		}
+		pathSegs = append(pathSegs, datamodel.PathSegmentOfString(unescaped))
+	}
+	return frisbii.DenylistEntry{Root: root, Path: datamodel.NewPath(pathSegs)}, nil
+}
+
+// ExcludeDeniedRoots returns the roots that aren't wholly denied by an entry
+// of denylist, matched by multihash, in their original order. A root with
+// only some paths denied is still included.
+func ExcludeDeniedRoots(roots []cid.Cid, denylist []frisbii.DenylistEntry) []cid.Cid {
+	denied := make(map[string]struct{}, len(denylist))
+	for _, entry := range denylist {
+		if entry.Path.Len() == 0 {
+			denied[string(entry.Root.Hash())] = struct{}{}
+		}
+	}
+	filtered := make([]cid.Cid, 0, len(roots))
+	for _, c := range roots {
+		if _, ok := denied[string(c.Hash())]; !ok {
+			filtered = append(filtered, c)
+		}
+	}
+	return filtered
+}
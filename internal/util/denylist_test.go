@@ -0,0 +1,52 @@
+package util_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	util "github.com/ipld/frisbii/internal/util"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDenylist(t *testing.T) {
+	req := require.New(t)
+	dir := t.TempDir()
+
+	mkCid := func(data string) cid.Cid {
+		h, err := multihash.Sum([]byte(data), multihash.SHA2_256, -1)
+		req.NoError(err)
+		return cid.NewCidV1(cid.DagProtobuf, h)
+	}
+	a, b, c := mkCid("a"), mkCid("b"), mkCid("c")
+	aV0 := cid.NewCidV0(a.Hash())
+
+	path := filepath.Join(dir, "denylist")
+	req.NoError(os.WriteFile(path, []byte("# takedowns\n"+aV0.String()+"\n\n  /ipfs/"+b.String()+"/sub%20dir/file  \n"), 0644))
+	denylist, err := util.LoadDenylist(path)
+	req.NoError(err)
+	req.Len(denylist, 2)
+	req.Equal(aV0, denylist[0].Root)
+	req.Zero(denylist[0].Path.Len())
+	req.Equal(b, denylist[1].Root)
+	req.Equal([]datamodel.PathSegment{datamodel.PathSegmentOfString("sub dir"), datamodel.PathSegmentOfString("file")}, denylist[1].Path.Segments())
+
+	// only wholly denied roots are excluded, matched by multihash
+	req.Equal([]cid.Cid{b, c}, util.ExcludeDeniedRoots([]cid.Cid{a, b, c}, denylist))
+
+	req.NoError(os.WriteFile(path, []byte("# nothing denied\n"), 0644))
+	denylist, err = util.LoadDenylist(path)
+	req.NoError(err)
+	req.Empty(denylist)
+
+	req.NoError(os.WriteFile(path, []byte(a.String()+"\nnope/file\n"), 0644))
+	_, err = util.LoadDenylist(path)
+	req.ErrorContains(err, `denylist:2: invalid denylist entry "nope/file"`)
+
+	req.NoError(os.WriteFile(path, []byte("/ipfs/\n"), 0644))
+	_, err = util.LoadDenylist(path)
+	req.ErrorContains(err, `denylist:1: invalid denylist entry "/ipfs/": missing CID`)
+}
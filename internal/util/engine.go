@@ -0,0 +1,159 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	carstorage "github.com/ipld/go-car/v2/storage"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/ipni/go-libipni/maurl"
+	"github.com/ipni/index-provider/engine"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+const (
+	// DefaultEntryChunkSize is the default maximum number of multihashes in each
+	// entries block of an advertisement, as used by the index-provider engine.
+	DefaultEntryChunkSize = 16384
+	// MinEntryChunkSize is the smallest entry chunk size accepted; smaller
+	// chunks make for very long entries chains that are slow to ingest.
+	MinEntryChunkSize = 256
+	// MaxEntryChunkSize is the largest entry chunk size accepted, which keeps
+	// an entries block of sha2-256 multihashes under 1MiB, a block size limit
+	// commonly enforced when transferring blocks.
+	MaxEntryChunkSize = 25000
+)
+
+// ValidateEntryChunkSize returns an error if size is outside of the range
+// MinEntryChunkSize to MaxEntryChunkSize.
+func ValidateEntryChunkSize(size int) error {
+	if size < MinEntryChunkSize || size > MaxEntryChunkSize {
+		return fmt.Errorf("entry chunk size must be between %d and %d", MinEntryChunkSize, MaxEntryChunkSize)
+	}
+	return nil
+}
+
+// NewEngine creates an index-provider engine that advertises retrieval from
+// the given address, publishing the advertisement chain over HTTP at ipniPath
+// via the frisbii server. If announceUrl is empty, there is no publisher,
+// nothing is announced and the advertisements are only stored locally, in
+// memory. The entries of each advertisement are chained in blocks of up to
+// entryChunkSize multihashes.
+func NewEngine(privKey crypto.PrivKey, maddr multiaddr.Multiaddr, ipniPath string, announceUrl string, entryChunkSize int) (*engine.Engine, error) {
+	if err := ValidateEntryChunkSize(entryChunkSize); err != nil {
+		return nil, err
+	}
+	id, err := peer.IDFromPrivateKey(privKey)
+	if err != nil {
+		return nil, err
+	}
+
+	listenUrl, err := maurl.ToURL(maddr)
+	if err != nil {
+		return nil, err
+	}
+
+	announceAddr := maddr
+	if strings.HasPrefix("/ipni/v1/ad/", ipniPath) {
+		// if it's some subset of /ipni/v1/ad/ then we won't need to add it,
+		// ipnisync's ServeHTTP is agnostic and only cares about the path.Base()
+		// of the path.
+		ipniPath = ""
+	}
+	if ipniPath != "" {
+		httpath, err := multiaddr.NewComponent("httpath", url.PathEscape(ipniPath))
+		if err != nil {
+			return nil, err
+		}
+		announceAddr = multiaddr.Join(announceAddr, httpath)
+	}
+
+	opts := []engine.Option{
+		engine.WithPrivateKey(privKey),
+		engine.WithProvider(peer.AddrInfo{ID: id, Addrs: []multiaddr.Multiaddr{maddr}}),
+		engine.WithChainedEntries(entryChunkSize),
+	}
+	if announceUrl != "" {
+		// the publisher only affects how the advertisements are announced and
+		// served, not their content, so without it we produce the same chain
+		opts = append(opts,
+			engine.WithDirectAnnounce(announceUrl),
+			engine.WithPublisherKind(engine.HttpPublisher),
+			engine.WithHttpPublisherWithoutServer(),
+			engine.WithHttpPublisherHandlerPath(ipniPath),
+			engine.WithHttpPublisherListenAddr(listenUrl.Host),
+			engine.WithHttpPublisherAnnounceAddr(announceAddr.String()),
+		)
+	}
+	return engine.New(opts...)
+}
+
+// ExportAdvertisementsCar writes the advertisement chain starting at head,
+// along with the entries of each advertisement, to w as a CARv1 with head as
+// its root.
+func ExportAdvertisementsCar(ctx context.Context, eng *engine.Engine, head cid.Cid, w io.Writer) error {
+	car, err := carstorage.NewWritable(w, []cid.Cid{head}, carv2.WriteAsCarV1(true), carv2.AllowDuplicatePuts(false))
+	if err != nil {
+		return err
+	}
+	lsys := eng.LinkSystem()
+	lctx := linking.LinkContext{Ctx: ctx}
+	put := func(c cid.Cid) error {
+		byts, err := lsys.LoadRaw(lctx, cidlink.Link{Cid: c})
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %w", c, err)
+		}
+		return car.Put(ctx, c.KeyString(), byts)
+	}
+
+	for adCid := head; adCid.Defined(); {
+		if err := put(adCid); err != nil {
+			return err
+		}
+		ad, err := eng.GetAdv(ctx, adCid)
+		if err != nil {
+			return err
+		}
+		for entries := ad.Entries; entries != nil && entries != schema.NoEntries; {
+			entriesCid := entries.(cidlink.Link).Cid
+			if err := put(entriesCid); err != nil {
+				return err
+			}
+			n, err := lsys.Load(lctx, entries, schema.EntryChunkPrototype)
+			if err != nil {
+				return err
+			}
+			chunk, err := schema.UnwrapEntryChunk(n)
+			if err != nil {
+				return err
+			}
+			entries = chunk.Next
+		}
+		adCid = cid.Undef
+		if ad.PreviousID != nil {
+			adCid = ad.PreviousID.(cidlink.Link).Cid
+		}
+	}
+	return car.Finalize()
+}
+
+// ExportAdvertisementDagJson writes the head advertisement block, which is
+// encoded as DAG-JSON, to w. Unlike ExportAdvertisementsCar, neither the
+// entries nor the rest of the advertisement chain are included.
+func ExportAdvertisementDagJson(ctx context.Context, eng *engine.Engine, head cid.Cid, w io.Writer) error {
+	byts, err := eng.LinkSystem().LoadRaw(linking.LinkContext{Ctx: ctx}, cidlink.Link{Cid: head})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(byts)
+	return err
+}
@@ -0,0 +1,169 @@
+package util_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/frisbii"
+	util "github.com/ipld/frisbii/internal/util"
+	"github.com/ipld/go-car/v2"
+	"github.com/ipld/go-ipld-prime/linking"
+	"github.com/ipni/go-libipni/ingest/schema"
+	provider "github.com/ipni/index-provider"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportAdvertisements(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+
+	privKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	req.NoError(err)
+	maddr, err := multiaddr.NewMultiaddr("/dns/example.com/tcp/3747/http")
+	req.NoError(err)
+
+	mhs := make([]multihash.Multihash, 0)
+	for _, s := range []string{"one", "two", "three"} {
+		mh, err := multihash.Sum([]byte(s), multihash.SHA2_256, -1)
+		req.NoError(err)
+		mhs = append(mhs, mh)
+	}
+	lister := func(ctx context.Context, id peer.ID, contextID []byte) (provider.MultihashIterator, error) {
+		return provider.SliceMultihashIterator(mhs), nil
+	}
+
+	// the advertisement produced when announcing to an indexer
+	var announced int
+	announceServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		announced++
+		res.WriteHeader(http.StatusNoContent)
+	}))
+	defer announceServer.Close()
+
+	announceEngine, err := util.NewEngine(privKey, maddr, "/ipni/", announceServer.URL+"/announce", util.DefaultEntryChunkSize)
+	req.NoError(err)
+	announceEngine.RegisterMultihashLister(lister)
+	req.NoError(announceEngine.Start(ctx))
+	defer announceEngine.Shutdown()
+	announcedHead, err := frisbii.NotifyPut(ctx, announceEngine)
+	req.NoError(err)
+	req.Equal(1, announced)
+
+	// the advertisement produced for export, which should be identical
+	exportEngine, err := util.NewEngine(privKey, maddr, "/ipni/", "", util.DefaultEntryChunkSize)
+	req.NoError(err)
+	exportEngine.RegisterMultihashLister(lister)
+	req.NoError(exportEngine.Start(ctx))
+	defer exportEngine.Shutdown()
+	head, err := frisbii.NotifyPut(ctx, exportEngine)
+	req.NoError(err)
+	req.Equal(announcedHead, head)
+
+	t.Run("car", func(t *testing.T) {
+		req := require.New(t)
+		var buf bytes.Buffer
+		req.NoError(util.ExportAdvertisementsCar(ctx, exportEngine, head, &buf))
+
+		carReader, err := car.NewBlockReader(&buf)
+		req.NoError(err)
+		req.Equal(uint64(1), carReader.Version)
+		req.Equal([]cid.Cid{head}, carReader.Roots)
+
+		adBlock, err := carReader.Next()
+		req.NoError(err)
+		req.Equal(head, adBlock.Cid())
+		ad, err := exportEngine.GetAdv(ctx, head)
+		req.NoError(err)
+		req.NotEqual(schema.NoEntries, ad.Entries)
+
+		entriesBlock, err := carReader.Next()
+		req.NoError(err)
+		req.Equal(ad.Entries.String(), entriesBlock.Cid().String())
+
+		_, err = carReader.Next()
+		req.ErrorIs(err, io.EOF)
+	})
+
+	t.Run("dag-json", func(t *testing.T) {
+		req := require.New(t)
+		var buf bytes.Buffer
+		req.NoError(util.ExportAdvertisementDagJson(ctx, exportEngine, head, &buf))
+
+		c, err := head.Prefix().Sum(buf.Bytes())
+		req.NoError(err)
+		req.Equal(head, c)
+	})
+}
+
+func TestNewEngineEntryChunkSize(t *testing.T) {
+	ctx := context.Background()
+
+	privKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	maddr, err := multiaddr.NewMultiaddr("/dns/example.com/tcp/3747/http")
+	require.NoError(t, err)
+
+	mhs := make([]multihash.Multihash, 0, 10000)
+	for i := 0; i < cap(mhs); i++ {
+		mh, err := multihash.Sum([]byte(strconv.Itoa(i)), multihash.SHA2_256, -1)
+		require.NoError(t, err)
+		mhs = append(mhs, mh)
+	}
+	lister := func(ctx context.Context, id peer.ID, contextID []byte) (provider.MultihashIterator, error) {
+		return provider.SliceMultihashIterator(mhs), nil
+	}
+
+	for _, tc := range []struct {
+		chunkSize      int
+		expectedChunks int
+	}{
+		{util.MinEntryChunkSize, 40},
+		{1000, 10},
+		{3000, 4},
+		{util.DefaultEntryChunkSize, 1},
+	} {
+		t.Run(strconv.Itoa(tc.chunkSize), func(t *testing.T) {
+			req := require.New(t)
+
+			eng, err := util.NewEngine(privKey, maddr, "/ipni/", "", tc.chunkSize)
+			req.NoError(err)
+			eng.RegisterMultihashLister(lister)
+			req.NoError(eng.Start(ctx))
+			defer eng.Shutdown()
+			head, err := frisbii.NotifyPut(ctx, eng)
+			req.NoError(err)
+
+			ad, err := eng.GetAdv(ctx, head)
+			req.NoError(err)
+			var chunks, entries int
+			for next := ad.Entries; next != nil && next != schema.NoEntries; {
+				n, err := eng.LinkSystem().Load(linking.LinkContext{Ctx: ctx}, next, schema.EntryChunkPrototype)
+				req.NoError(err)
+				chunk, err := schema.UnwrapEntryChunk(n)
+				req.NoError(err)
+				req.LessOrEqual(len(chunk.Entries), tc.chunkSize)
+				chunks++
+				entries += len(chunk.Entries)
+				next = chunk.Next
+			}
+			req.Equal(tc.expectedChunks, chunks)
+			req.Equal(len(mhs), entries)
+		})
+	}
+
+	for _, chunkSize := range []int{0, util.MinEntryChunkSize - 1, util.MaxEntryChunkSize + 1} {
+		_, err := util.NewEngine(privKey, maddr, "/ipni/", "", chunkSize)
+		require.ErrorContains(t, err, "entry chunk size must be between")
+	}
+}
@@ -0,0 +1,23 @@
+package util
+
+import (
+	"fmt"
+	"html/template"
+	"path/filepath"
+
+	"github.com/ipld/frisbii"
+)
+
+// LoadErrorTemplate parses the HTML template at path, for
+// frisbii.WithErrorTemplate, and checks that it can be executed with a
+// frisbii.ErrorPage.
+func LoadErrorTemplate(path string) (*template.Template, error) {
+	tmpl, err := template.New(filepath.Base(path)).ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid error template: %w", err)
+	}
+	if err := frisbii.ValidateErrorTemplate(tmpl); err != nil {
+		return nil, fmt.Errorf("invalid error template: %w", err)
+	}
+	return tmpl, nil
+}
@@ -0,0 +1,32 @@
+package util_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	util "github.com/ipld/frisbii/internal/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadErrorTemplate(t *testing.T) {
+	req := require.New(t)
+
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		req.NoError(os.WriteFile(path, []byte(content), 0644))
+		return path
+	}
+
+	tmpl, err := util.LoadErrorTemplate(write("error.html", "<h1>{{.Status}} {{.StatusText}}</h1><p>{{.Message}}</p><p>{{.Path}}</p>"))
+	req.NoError(err)
+	req.NotNil(tmpl)
+
+	_, err = util.LoadErrorTemplate(write("unparsable.html", "<h1>{{.Status</h1>"))
+	req.ErrorContains(err, "invalid error template")
+	_, err = util.LoadErrorTemplate(write("unknown.html", "<h1>{{.Code}}</h1>"))
+	req.ErrorContains(err, "invalid error template")
+	_, err = util.LoadErrorTemplate(filepath.Join(dir, "missing.html"))
+	req.ErrorContains(err, "invalid error template")
+}
@@ -0,0 +1,77 @@
+package util
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+// ParseMultihashCodes parses a list of multihash function names, as found in
+// the multicodec table, e.g. "sha2-256", or numeric codes, e.g. "0x12".
+func ParseMultihashCodes(names []string) ([]uint64, error) {
+	codes := make([]uint64, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if code, ok := multihash.Names[strings.ToLower(name)]; ok {
+			codes = append(codes, code)
+			continue
+		}
+		code, err := strconv.ParseUint(name, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unknown multihash code %q", name)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// AnnouncedMultihashes returns the multihashes of roots that are announced,
+// along with the number that are excluded because their hash function isn't
+// one of mhCodes. Where mhCodes is empty all multihashes are announced.
+// Announcements are of multihashes rather than CIDs, so CIDv0 and CIDv1 forms
+// of the same root are announced, and counted, once.
+func AnnouncedMultihashes(roots []cid.Cid, mhCodes []uint64) (included []multihash.Multihash, filtered int) {
+	allowed := make(map[uint64]struct{}, len(mhCodes))
+	for _, code := range mhCodes {
+		allowed[code] = struct{}{}
+	}
+	seen := make(map[string]struct{}, len(roots))
+	included = make([]multihash.Multihash, 0, len(roots))
+	for _, r := range roots {
+		mh := r.Hash()
+		if _, ok := seen[string(mh)]; ok {
+			continue
+		}
+		seen[string(mh)] = struct{}{}
+		if len(allowed) > 0 {
+			if _, ok := allowed[r.Prefix().MhType]; !ok {
+				filtered++
+				continue
+			}
+		}
+		included = append(included, mh)
+	}
+	return included, filtered
+}
+
+// AnnouncementDigest returns a digest of a set of announced multihashes, such
+// as those returned by AnnouncedMultihashes, that is the same for the same set
+// regardless of order, so that an announcement that would be identical to the
+// previous one can be detected.
+func AnnouncementDigest(mhs []multihash.Multihash) []byte {
+	sorted := make([]multihash.Multihash, len(mhs))
+	copy(sorted, mhs)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i], sorted[j]) < 0 })
+	h := sha256.New()
+	for _, mh := range sorted {
+		// multihashes are self-delimiting, so can be written back to back
+		h.Write(mh)
+	}
+	return h.Sum(nil)
+}
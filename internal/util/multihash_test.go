@@ -0,0 +1,127 @@
+package util_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	util "github.com/ipld/frisbii/internal/util"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMultihashCodes(t *testing.T) {
+	req := require.New(t)
+
+	codes, err := util.ParseMultihashCodes([]string{"sha2-256", " BLAKE2B-256 ", "0x13", "18"})
+	req.NoError(err)
+	req.Equal([]uint64{multihash.SHA2_256, multihash.BLAKE2B_MIN + 31, multihash.SHA2_512, multihash.SHA2_256}, codes)
+
+	_, err = util.ParseMultihashCodes([]string{"sha2-256", "sha9-1"})
+	req.ErrorContains(err, `unknown multihash code "sha9-1"`)
+}
+
+func TestAnnouncedMultihashes(t *testing.T) {
+	mkCid := func(data string, codec uint64, mhCode uint64) cid.Cid {
+		h, err := multihash.Sum([]byte(data), mhCode, -1)
+		require.NoError(t, err)
+		return cid.NewCidV1(codec, h)
+	}
+	sha256Pb := mkCid("a", cid.DagProtobuf, multihash.SHA2_256)
+	sha256PbV0 := cid.NewCidV0(sha256Pb.Hash())
+	sha256Raw := mkCid("b", cid.Raw, multihash.SHA2_256)
+	sha512Raw := mkCid("c", cid.Raw, multihash.SHA2_512)
+	blake2bCbor := mkCid("d", cid.DagCBOR, multihash.BLAKE2B_MIN+31)
+	roots := []cid.Cid{sha256Pb, sha512Raw, sha256PbV0, blake2bCbor, sha256Raw}
+
+	for _, tc := range []struct {
+		name           string
+		mhCodes        []uint64
+		expected       []multihash.Multihash
+		expectFiltered int
+	}{
+		{
+			name:     "no filter",
+			expected: []multihash.Multihash{sha256Pb.Hash(), sha512Raw.Hash(), blake2bCbor.Hash(), sha256Raw.Hash()},
+		},
+		{
+			name:           "sha2-256",
+			mhCodes:        []uint64{multihash.SHA2_256},
+			expected:       []multihash.Multihash{sha256Pb.Hash(), sha256Raw.Hash()},
+			expectFiltered: 2,
+		},
+		{
+			name:           "sha2-512 and blake2b-256",
+			mhCodes:        []uint64{multihash.SHA2_512, multihash.BLAKE2B_MIN + 31},
+			expected:       []multihash.Multihash{sha512Raw.Hash(), blake2bCbor.Hash()},
+			expectFiltered: 2,
+		},
+		{
+			name:           "none match",
+			mhCodes:        []uint64{multihash.SHA3_256},
+			expected:       []multihash.Multihash{},
+			expectFiltered: 4,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+
+			included, filtered := util.AnnouncedMultihashes(roots, tc.mhCodes)
+			req.Equal(tc.expected, included)
+			req.Equal(tc.expectFiltered, filtered)
+
+			itr, err := util.RootsLister(roots, tc.mhCodes)(context.Background(), "", nil)
+			req.NoError(err)
+			listed := make([]multihash.Multihash, 0)
+			for {
+				mh, err := itr.Next()
+				if err != nil {
+					break
+				}
+				listed = append(listed, mh)
+			}
+			req.Equal(tc.expected, listed)
+		})
+	}
+}
+
+func TestAnnouncementDigest(t *testing.T) {
+	req := require.New(t)
+	mkRoots := func(data ...string) []cid.Cid {
+		roots := make([]cid.Cid, 0, len(data))
+		for _, d := range data {
+			h, err := multihash.Sum([]byte(d), multihash.SHA2_256, -1)
+			req.NoError(err)
+			roots = append(roots, cid.NewCidV1(cid.DagProtobuf, h))
+		}
+		return roots
+	}
+	digest := func(roots []cid.Cid, mhCodes []uint64) []byte {
+		included, _ := util.AnnouncedMultihashes(roots, mhCodes)
+		return util.AnnouncementDigest(included)
+	}
+
+	// the roots of a set of CARs, which is modified by adding a CAR, then
+	// reverted by removing it again
+	carA, carB, carC := mkRoots("a1", "a2"), mkRoots("b1"), mkRoots("c1", "c2")
+	initial := digest(append(append([]cid.Cid{}, carA...), carB...), nil)
+	modified := digest(append(append(append([]cid.Cid{}, carA...), carB...), carC...), nil)
+	reverted := digest(append(append([]cid.Cid{}, carA...), carB...), nil)
+	req.NotEqual(initial, modified)
+	req.Equal(initial, reverted)
+
+	// neither order nor the CID version of a root changes what's announced
+	reordered := digest(append(append([]cid.Cid{}, carB...), cid.NewCidV0(carA[1].Hash()), carA[0]), nil)
+	req.Equal(initial, reordered)
+
+	// nor does a root that's excluded by multihash code
+	sha512, err := multihash.Sum([]byte("d1"), multihash.SHA2_512, -1)
+	req.NoError(err)
+	withExcluded := append(append(append([]cid.Cid{}, carA...), carB...), cid.NewCidV1(cid.Raw, sha512))
+	req.Equal(initial, digest(withExcluded, []uint64{multihash.SHA2_256}))
+	req.NotEqual(initial, digest(withExcluded, nil))
+
+	// an empty set has a digest too, distinct from any other
+	req.NotEqual(initial, util.AnnouncementDigest(nil))
+	req.Equal(util.AnnouncementDigest(nil), digest(nil, nil))
+}
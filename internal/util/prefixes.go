@@ -0,0 +1,99 @@
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// PrefixesConfig is the format of the file used to describe sets of CARs that
+// are served under path prefixes, e.g.:
+//
+//	{
+//	  "prefixes": [
+//	    {
+//	      "name": "tenant-a",
+//	      "cars": ["/data/tenant-a/*.car"],
+//	      "publicAddr": "https://tenant-a.example.com"
+//	    }
+//	  ]
+//	}
+//
+// The content of each prefix is served under "/<name>/ipfs/". CAR paths may be
+// globs, and relative paths are resolved against the directory of the config
+// file. The public address of a prefix is where its content is available at
+// the root, e.g. via a reverse proxy, and is what its roots are announced
+// with, since clients of the indexer retrieve from "<address>/ipfs/<cid>".
+type PrefixesConfig struct {
+	Prefixes []PrefixConfig `json:"prefixes"`
+}
+
+type PrefixConfig struct {
+	Name       string   `json:"name"`
+	Cars       []string `json:"cars"`
+	PublicAddr string   `json:"publicAddr,omitempty"`
+}
+
+// Prefix is a validated PrefixConfig, with its CAR globs expanded.
+type Prefix struct {
+	Name       string
+	Cars       []string
+	PublicAddr string
+}
+
+// LoadPrefixes reads and validates a PrefixesConfig file, expanding the CAR
+// globs of each prefix.
+func LoadPrefixes(path string) ([]Prefix, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config PrefixesConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse prefixes config [%s]: %w", path, err)
+	}
+	if len(config.Prefixes) == 0 {
+		return nil, fmt.Errorf("prefixes config [%s] contains no prefixes", path)
+	}
+
+	prefixes := make([]Prefix, 0, len(config.Prefixes))
+	seen := make(map[string]struct{}, len(config.Prefixes))
+	for ii, pc := range config.Prefixes {
+		prefix, err := pc.toPrefix(filepath.Dir(path))
+		if err != nil {
+			return nil, fmt.Errorf("invalid prefix #%d in [%s]: %w", ii, path, err)
+		}
+		if _, ok := seen[prefix.Name]; ok {
+			return nil, fmt.Errorf("duplicate prefix [%s] in [%s]", prefix.Name, path)
+		}
+		seen[prefix.Name] = struct{}{}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+func (pc PrefixConfig) toPrefix(configDir string) (Prefix, error) {
+	if pc.Name == "" {
+		return Prefix{}, errors.New("no name")
+	}
+	if len(pc.Cars) == 0 {
+		return Prefix{}, errors.New("no CAR files")
+	}
+	carPaths := make([]string, 0, len(pc.Cars))
+	for _, car := range pc.Cars {
+		if !filepath.IsAbs(car) {
+			car = filepath.Join(configDir, car)
+		}
+		matches, err := filepath.Glob(car)
+		if err != nil {
+			return Prefix{}, fmt.Errorf("invalid CAR path [%s]: %w", car, err)
+		}
+		carPaths = append(carPaths, matches...)
+	}
+	if len(carPaths) == 0 {
+		return Prefix{}, fmt.Errorf("no CAR files match %v", pc.Cars)
+	}
+	return Prefix{Name: pc.Name, Cars: carPaths, PublicAddr: pc.PublicAddr}, nil
+}
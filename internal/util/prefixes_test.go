@@ -0,0 +1,50 @@
+package util_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	util "github.com/ipld/frisbii/internal/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadPrefixes(t *testing.T) {
+	req := require.New(t)
+	dir := t.TempDir()
+	req.NoError(os.MkdirAll(filepath.Join(dir, "a"), 0755))
+	for _, name := range []string{"a/1.car", "a/2.car", "b.car"} {
+		req.NoError(os.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+	path := filepath.Join(dir, "prefixes.json")
+	write := func(config string) {
+		req.NoError(os.WriteFile(path, []byte(config), 0644))
+	}
+
+	write(`{"prefixes": [
+		{"name": "tenant-a", "cars": ["a/*.car"], "publicAddr": "https://a.example.com"},
+		{"name": "tenant-b", "cars": ["` + filepath.Join(dir, "b.car") + `"]}
+	]}`)
+	prefixes, err := util.LoadPrefixes(path)
+	req.NoError(err)
+	req.Equal([]util.Prefix{
+		{Name: "tenant-a", Cars: []string{filepath.Join(dir, "a/1.car"), filepath.Join(dir, "a/2.car")}, PublicAddr: "https://a.example.com"},
+		{Name: "tenant-b", Cars: []string{filepath.Join(dir, "b.car")}},
+	}, prefixes)
+
+	for _, tc := range []struct {
+		config string
+		err    string
+	}{
+		{`{"prefixes": []}`, "contains no prefixes"},
+		{`{"prefixes": [{"cars": ["b.car"]}]}`, "invalid prefix #0 in [" + path + "]: no name"},
+		{`{"prefixes": [{"name": "a"}]}`, "invalid prefix #0 in [" + path + "]: no CAR files"},
+		{`{"prefixes": [{"name": "a", "cars": ["nope/*.car"]}]}`, "no CAR files match [nope/*.car]"},
+		{`{"prefixes": [{"name": "a", "cars": ["b.car"]}, {"name": "a", "cars": ["b.car"]}]}`, "duplicate prefix [a]"},
+		{`{"prefixes": `, "failed to parse prefixes config"},
+	} {
+		write(tc.config)
+		_, err := util.LoadPrefixes(path)
+		req.ErrorContains(err, tc.err)
+	}
+}
@@ -0,0 +1,71 @@
+package util
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	provider "github.com/ipni/index-provider"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// LoadServableRoots reads an allow-list of root CIDs from the file at path,
+// one CID per line. Blank lines and lines starting with "#" are ignored.
+func LoadServableRoots(path string) ([]cid.Cid, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	roots := make([]cid.Cid, 0)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		c, err := cid.Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid CID %q: %w", path, line, text, err)
+		}
+		roots = append(roots, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(roots) == 0 {
+		return nil, errors.New("servable roots file contains no roots")
+	}
+	return roots, nil
+}
+
+// FilterRoots returns the roots that are present in servable, matched by
+// multihash, in their original order.
+func FilterRoots(roots []cid.Cid, servable []cid.Cid) []cid.Cid {
+	allowed := make(map[string]struct{}, len(servable))
+	for _, c := range servable {
+		allowed[string(c.Hash())] = struct{}{}
+	}
+	filtered := make([]cid.Cid, 0, len(roots))
+	for _, c := range roots {
+		if _, ok := allowed[string(c.Hash())]; ok {
+			filtered = append(filtered, c)
+		}
+	}
+	return filtered
+}
+
+// RootsLister returns a MultihashLister for a fixed list of roots, for
+// announcing a subset of the roots of a frisbii.MultiReadableStorage. Only the
+// multihashes with one of mhCodes are listed, see AnnouncedMultihashes.
+func RootsLister(roots []cid.Cid, mhCodes []uint64) provider.MultihashLister {
+	return func(ctx context.Context, id peer.ID, contextID []byte) (provider.MultihashIterator, error) {
+		mh, _ := AnnouncedMultihashes(roots, mhCodes)
+		return provider.SliceMultihashIterator(mh), nil
+	}
+}
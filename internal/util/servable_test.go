@@ -0,0 +1,58 @@
+package util_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	util "github.com/ipld/frisbii/internal/util"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServableRoots(t *testing.T) {
+	req := require.New(t)
+	dir := t.TempDir()
+
+	mkCid := func(data string) cid.Cid {
+		h, err := multihash.Sum([]byte(data), multihash.SHA2_256, -1)
+		req.NoError(err)
+		return cid.NewCidV1(cid.DagProtobuf, h)
+	}
+	a, b, c := mkCid("a"), mkCid("b"), mkCid("c")
+	aV0 := cid.NewCidV0(a.Hash())
+
+	path := filepath.Join(dir, "roots")
+	req.NoError(os.WriteFile(path, []byte("# servable\n"+aV0.String()+"\n\n  "+c.String()+"  \n"), 0644))
+	servable, err := util.LoadServableRoots(path)
+	req.NoError(err)
+	req.Equal([]cid.Cid{aV0, c}, servable)
+
+	roots := util.FilterRoots([]cid.Cid{a, b, c}, servable)
+	req.Equal([]cid.Cid{a, c}, roots)
+
+	itr, err := util.RootsLister(roots, nil)(context.Background(), "", nil)
+	req.NoError(err)
+	got := make([]multihash.Multihash, 0)
+	for {
+		mh, err := itr.Next()
+		if err != nil {
+			break
+		}
+		got = append(got, mh)
+	}
+	req.Equal([]multihash.Multihash{a.Hash(), c.Hash()}, got)
+
+	req.NoError(os.WriteFile(path, []byte(a.String()+"\nnope\n"), 0644))
+	_, err = util.LoadServableRoots(path)
+	req.ErrorContains(err, `roots:2: invalid CID "nope"`)
+
+	req.NoError(os.WriteFile(path, []byte("# nothing\n"), 0644))
+	_, err = util.LoadServableRoots(path)
+	req.ErrorContains(err, "contains no roots")
+
+	_, err = util.LoadServableRoots(filepath.Join(dir, "missing"))
+	req.ErrorIs(err, os.ErrNotExist)
+}
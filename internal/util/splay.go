@@ -0,0 +1,31 @@
+package util
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Splay spreads out periodic events, such as re-announcements to an indexer,
+// across instances that start at the same time, by adding a random delay of
+// up to a fraction of the interval to each one.
+type Splay struct {
+	fraction float64
+	rng      *rand.Rand
+}
+
+// NewSplay returns a Splay that adds up to fraction of an interval to it, with
+// delays drawn from a source seeded with seed, so a given seed always produces
+// the same sequence of delays.
+func NewSplay(fraction float64, seed int64) *Splay {
+	return &Splay{fraction: fraction, rng: rand.New(rand.NewSource(seed))}
+}
+
+// Next returns the time to wait until the next event; interval plus a random
+// delay in [0, fraction*interval).
+func (s *Splay) Next(interval time.Duration) time.Duration {
+	max := int64(float64(interval) * s.fraction)
+	if max <= 0 {
+		return interval
+	}
+	return interval + time.Duration(s.rng.Int63n(max))
+}
@@ -0,0 +1,39 @@
+package util_test
+
+import (
+	"testing"
+	"time"
+
+	util "github.com/ipld/frisbii/internal/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplay(t *testing.T) {
+	req := require.New(t)
+	const interval = 6 * time.Hour
+
+	next := func(splay *util.Splay, n int) []time.Duration {
+		delays := make([]time.Duration, n)
+		for i := range delays {
+			delays[i] = splay.Next(interval)
+		}
+		return delays
+	}
+
+	a := next(util.NewSplay(0.1, 42), 100)
+	for _, d := range a {
+		req.GreaterOrEqual(d, interval)
+		req.Less(d, interval+interval/10)
+	}
+	// not all the same
+	req.NotEqual(a[0], a[1])
+
+	// deterministic for a given seed
+	req.Equal(a, next(util.NewSplay(0.1, 42), 100))
+	req.NotEqual(a, next(util.NewSplay(0.1, 43), 100))
+
+	// no splay
+	for _, d := range next(util.NewSplay(0, 42), 10) {
+		req.Equal(interval, d)
+	}
+}
@@ -2,13 +2,17 @@ package util
 
 import (
 	"crypto/rand"
+	"encoding/base64"
 	"fmt"
+	"io"
 	"net"
 	"net/url"
 	"os"
 	"path"
+	"strings"
 	"time"
 
+	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-log/v2"
 	"github.com/ipld/frisbii"
 	car "github.com/ipld/go-car/v2"
@@ -24,19 +28,127 @@ const FrisbiiConfigDir = ".frisbii"
 var logger = log.Logger("frisbii")
 
 func LoadCar(multicar *frisbii.MultiReadableStorage, carPath string) error {
+	c, err := OpenCar(carPath)
+	if err != nil {
+		return err
+	}
+	multicar.AddStore(c.Store, c.Store.Roots())
+	return nil
+}
+
+// Car is an open CAR file, along with the file details that identify the
+// version of it that was opened.
+type Car struct {
+	Path    string
+	ModTime time.Time
+	Size    int64
+	Store   carstorage.ReadableCar
+	// Indexed is true where the CARv2's own index is used for block lookups,
+	// rather than one generated by scanning its blocks.
+	Indexed bool
+	file    *os.File
+}
+
+// OpenCar opens the CAR file at carPath for reading. The index of a CARv2 is
+// used for block lookups where it has one, otherwise the blocks are scanned
+// to generate an index in memory, as they are for a CARv1 and for a CARv2
+// whose index can't be read.
+func OpenCar(carPath string) (*Car, error) {
 	start := time.Now()
 	logger.Infof("Opening CAR file [%s]...", carPath)
 	carFile, err := os.Open(carPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	store, err := carstorage.OpenReadable(carFile, car.UseWholeCIDs(false))
+	fi, err := carFile.Stat()
 	if err != nil {
-		return err
+		carFile.Close()
+		return nil, err
 	}
-	logger.Infof("CAR file [%s] opened in %s", carPath, time.Since(start))
-	multicar.AddStore(store, store.Roots())
-	return nil
+	store, indexed, err := openReadableCar(carPath, carFile)
+	if err != nil {
+		carFile.Close()
+		return nil, err
+	}
+	if indexed {
+		logger.Infof("CAR file [%s] opened in %s, using its index", carPath, time.Since(start))
+	} else {
+		logger.Infof("CAR file [%s] opened in %s, indexed in memory", carPath, time.Since(start))
+	}
+	return &Car{Path: carPath, ModTime: fi.ModTime(), Size: fi.Size(), Store: store, Indexed: indexed, file: carFile}, nil
+}
+
+// openReadableCar opens r, the CAR at carPath, returning whether the index of
+// a CARv2 was used rather than the blocks being scanned. Where a CARv2's index
+// can't be read, e.g. as it's truncated or of an unsupported kind, its data
+// payload is opened as a CARv1 instead, which is scanned.
+func openReadableCar(carPath string, r io.ReaderAt) (carstorage.ReadableCar, bool, error) {
+	store, err := carstorage.OpenReadable(r, car.UseWholeCIDs(false))
+	if err == nil {
+		v2r, err := car.NewReader(r)
+		return store, err == nil && v2r.Version == 2 && v2r.Header.HasIndex(), nil
+	}
+	v2r, v2err := car.NewReader(r)
+	if v2err != nil || v2r.Version != 2 || !v2r.Header.HasIndex() {
+		return nil, false, err
+	}
+	logger.Warnf("Unable to use the index of CAR file [%s], scanning it instead: %s", carPath, err)
+	dr, drerr := v2r.DataReader()
+	if drerr != nil {
+		return nil, false, err
+	}
+	if store, err = carstorage.OpenReadable(dr, car.UseWholeCIDs(false)); err != nil {
+		return nil, false, err
+	}
+	return store, false, nil
+}
+
+// Unchanged returns true if the file at the CAR's path appears to be the same
+// as the one that was opened, based on its modification time and size.
+func (c *Car) Unchanged() bool {
+	fi, err := os.Stat(c.Path)
+	return err == nil && fi.ModTime().Equal(c.ModTime) && fi.Size() == c.Size
+}
+
+// Close closes the CAR file.
+func (c *Car) Close() error {
+	return c.file.Close()
+}
+
+// SharedRoot is a root that is listed by more than one CAR.
+type SharedRoot struct {
+	Root cid.Cid
+	// Paths are the paths of the CARs that list Root, in the order the CARs
+	// were loaded. The first is authoritative, a frisbii.MultiReadableStorage
+	// of the CARs reads the root block from it.
+	Paths []string
+}
+
+// SharedRoots returns the roots that are listed by more than one of cars, in
+// the order they are first listed.
+func SharedRoots(cars []*Car) []SharedRoot {
+	byRoot := make(map[string]int)
+	var all []SharedRoot
+	for _, c := range cars {
+		for _, root := range c.Store.Roots() {
+			idx, ok := byRoot[root.KeyString()]
+			if !ok {
+				idx = len(all)
+				byRoot[root.KeyString()] = idx
+				all = append(all, SharedRoot{Root: root})
+			}
+			if paths := all[idx].Paths; len(paths) == 0 || paths[len(paths)-1] != c.Path {
+				all[idx].Paths = append(paths, c.Path)
+			}
+		}
+	}
+	shared := make([]SharedRoot, 0)
+	for _, sr := range all {
+		if len(sr.Paths) > 1 {
+			shared = append(shared, sr)
+		}
+	}
+	return shared
 }
 
 type ListenAddr struct {
@@ -45,7 +157,24 @@ type ListenAddr struct {
 	Unspecified bool
 }
 
+// GetListenAddr determines the address that frisbii is available at, from
+// its listen address and an optional public address that overrides it. A
+// Unix domain socket listen address (with a frisbii.UnixSocketPrefix) can't be
+// reached by other peers without a public address, so it is reported as
+// Unspecified.
 func GetListenAddr(serverAddr string, publicAddr string) (ListenAddr, error) {
+	if socketPath, ok := strings.CutPrefix(serverAddr, frisbii.UnixSocketPrefix); ok && publicAddr == "" {
+		maddr, err := multiaddr.NewComponent("unix", socketPath)
+		if err != nil {
+			return ListenAddr{}, err
+		}
+		return ListenAddr{
+			Maddr:       maddr,
+			Url:         &url.URL{Scheme: "unix", Opaque: socketPath},
+			Unspecified: true,
+		}, nil
+	}
+
 	frisbiiAddr := "http://" + serverAddr
 	if publicAddr != "" {
 		frisbiiAddr = publicAddr
@@ -86,8 +215,27 @@ func GetListenAddr(serverAddr string, publicAddr string) (ListenAddr, error) {
 	return la, nil
 }
 
+// PrivateKeyEnvVar is the environment variable that frisbii's identity may
+// be supplied with, as a base64 encoded, libp2p marshalled private key, in
+// place of the key file in the config dir.
+const PrivateKeyEnvVar = "FRISBII_PRIVATE_KEY"
+
+// LoadPrivKey returns frisbii's identity, from PrivateKeyEnvVar where it's set,
+// otherwise from the key file in confDir, which is generated where it doesn't
+// exist.
 func LoadPrivKey(confDir string) (crypto.PrivKey, peer.ID, error) {
-	// make the config dir in the user's home dir if it doesn't exist
+	if encoded, ok := os.LookupEnv(PrivateKeyEnvVar); ok {
+		privKey, err := privKeyFromEnv(encoded)
+		if err != nil {
+			return nil, peer.ID(""), err
+		}
+		id, err := peer.IDFromPrivateKey(privKey)
+		if err != nil {
+			return nil, peer.ID(""), err
+		}
+		return privKey, id, nil
+	}
+
 	keyFile := path.Join(confDir, "key")
 	data, err := os.ReadFile(keyFile)
 	var privKey crypto.PrivKey
@@ -121,6 +269,29 @@ func LoadPrivKey(confDir string) (crypto.PrivKey, peer.ID, error) {
 	return privKey, id, nil
 }
 
+// ValidatePrivKeyEnv returns an error if PrivateKeyEnvVar is set, but not to a
+// valid private key, so that a malformed identity can be rejected on startup
+// rather than when it's first used.
+func ValidatePrivKeyEnv() error {
+	if encoded, ok := os.LookupEnv(PrivateKeyEnvVar); ok {
+		_, err := privKeyFromEnv(encoded)
+		return err
+	}
+	return nil
+}
+
+func privKeyFromEnv(encoded string) (crypto.PrivKey, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s, must be a base64 encoded private key: %w", PrivateKeyEnvVar, err)
+	}
+	privKey, err := crypto.UnmarshalPrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s, must be a base64 encoded private key: %w", PrivateKeyEnvVar, err)
+	}
+	return privKey, nil
+}
+
 func ConfigDir() (string, error) {
 	homedir, err := os.UserHomeDir()
 	if err != nil {
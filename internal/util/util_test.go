@@ -0,0 +1,224 @@
+package util_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/frisbii"
+	util "github.com/ipld/frisbii/internal/util"
+	car "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/storage"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSharedRoots(t *testing.T) {
+	req := require.New(t)
+
+	root, common, onlyA, onlyB := rawCid(t, "root"), rawCid(t, "common"), rawCid(t, "a"), rawCid(t, "b")
+	mkCar := func(name string, roots []cid.Cid, blocks map[cid.Cid]string) string {
+		carPath := filepath.Join(t.TempDir(), name)
+		writeCar(t, carPath, roots, blocks)
+		return carPath
+	}
+	// both CARs have the shared root, and a block in common, along with blocks
+	// of their own
+	pathA := mkCar("a.car", []cid.Cid{root, onlyA}, map[cid.Cid]string{root: "root", common: "common", onlyA: "a"})
+	pathB := mkCar("b.car", []cid.Cid{onlyB, root}, map[cid.Cid]string{root: "root", common: "common", onlyB: "b"})
+
+	var cars []*util.Car
+	multicar := frisbii.NewMultiReadableStorage()
+	for _, carPath := range []string{pathA, pathB} {
+		c, err := util.OpenCar(carPath)
+		req.NoError(err)
+		defer c.Close()
+		cars = append(cars, c)
+		multicar.AddStore(c.Store, c.Store.Roots())
+	}
+
+	req.Equal([]util.SharedRoot{{Root: root, Paths: []string{pathA, pathB}}}, util.SharedRoots(cars))
+	req.Empty(util.SharedRoots(cars[:1]))
+	req.Empty(util.SharedRoots(append(cars[:1:1], cars[0])))
+
+	// the shared root is listed, and so announced, once
+	req.Equal([]cid.Cid{root, onlyA, onlyB}, multicar.Roots())
+	included, _ := util.AnnouncedMultihashes(multicar.Roots(), nil)
+	req.Len(included, 3)
+	for c, data := range map[cid.Cid]string{root: "root", common: "common", onlyA: "a", onlyB: "b"} {
+		byts, err := multicar.Get(context.Background(), c.KeyString())
+		req.NoError(err)
+		req.Equal(data, string(byts))
+	}
+}
+
+func TestLoadPrivKeyEnv(t *testing.T) {
+	req := require.New(t)
+
+	privKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	req.NoError(err)
+	data, err := crypto.MarshalPrivateKey(privKey)
+	req.NoError(err)
+	expectedId, err := peer.IDFromPrivateKey(privKey)
+	req.NoError(err)
+
+	// the environment takes precedence over, and doesn't write, the key file
+	confDir := t.TempDir()
+	t.Setenv(util.PrivateKeyEnvVar, base64.StdEncoding.EncodeToString(data)+"\n")
+	req.NoError(util.ValidatePrivKeyEnv())
+	loaded, id, err := util.LoadPrivKey(confDir)
+	req.NoError(err)
+	req.True(privKey.Equals(loaded))
+	req.Equal(expectedId, id)
+	_, err = os.Stat(filepath.Join(confDir, "key"))
+	req.True(os.IsNotExist(err))
+
+	for _, malformed := range []string{"", "not base64!", base64.StdEncoding.EncodeToString([]byte("not a key"))} {
+		t.Setenv(util.PrivateKeyEnvVar, malformed)
+		req.ErrorContains(util.ValidatePrivKeyEnv(), "invalid "+util.PrivateKeyEnvVar)
+		_, _, err = util.LoadPrivKey(confDir)
+		req.ErrorContains(err, "invalid "+util.PrivateKeyEnvVar)
+	}
+
+	// without it, the key file is generated and then reused
+	req.NoError(os.Unsetenv(util.PrivateKeyEnvVar))
+	req.NoError(util.ValidatePrivKeyEnv())
+	_, generatedId, err := util.LoadPrivKey(confDir)
+	req.NoError(err)
+	req.NotEqual(expectedId, generatedId)
+	_, id, err = util.LoadPrivKey(confDir)
+	req.NoError(err)
+	req.Equal(generatedId, id)
+}
+
+func TestOpenCarIndex(t *testing.T) {
+	req := require.New(t)
+
+	blocks := make(map[cid.Cid]string)
+	var roots []cid.Cid
+	for i := 0; i < 10; i++ {
+		c := rawCid(t, fmt.Sprintf("block %d", i))
+		blocks[c] = fmt.Sprintf("block %d", i)
+		roots = append(roots, c)
+	}
+	dir := t.TempDir()
+	indexedPath := filepath.Join(dir, "indexed.car")
+	writeCar(t, indexedPath, roots[:1], blocks)
+	v1Path := filepath.Join(dir, "v1.car")
+	writeCar(t, v1Path, roots[:1], blocks, car.WriteAsCarV1(true))
+
+	// a CARv2 whose index is cut short, leaving the header claiming one
+	truncatedPath := filepath.Join(dir, "truncated.car")
+	writeCar(t, truncatedPath, roots[:1], blocks)
+	carFile, err := os.Open(truncatedPath)
+	req.NoError(err)
+	v2r, err := car.NewReader(carFile)
+	req.NoError(err)
+	req.True(v2r.Header.HasIndex())
+	indexOffset := v2r.Header.IndexOffset
+	req.NoError(carFile.Close())
+	req.NoError(os.Truncate(truncatedPath, int64(indexOffset)+4))
+
+	for _, tc := range []struct {
+		path          string
+		expectIndexed bool
+	}{
+		{indexedPath, true},
+		{v1Path, false},
+		{truncatedPath, false},
+	} {
+		t.Run(filepath.Base(tc.path), func(t *testing.T) {
+			req := require.New(t)
+			c, err := util.OpenCar(tc.path)
+			req.NoError(err)
+			defer c.Close()
+			req.Equal(tc.expectIndexed, c.Indexed)
+			req.Equal(roots[:1], c.Store.Roots())
+			for bc, data := range blocks {
+				byts, err := c.Store.Get(context.Background(), bc.KeyString())
+				req.NoError(err)
+				req.Equal(data, string(byts))
+			}
+			has, err := c.Store.Has(context.Background(), rawCid(t, "missing").KeyString())
+			req.NoError(err)
+			req.False(has)
+		})
+	}
+}
+
+func BenchmarkOpenCar(b *testing.B) {
+	blocks := make(map[cid.Cid]string)
+	var root cid.Cid
+	for i := 0; i < 50000; i++ {
+		data := fmt.Sprintf("%01024d", i)
+		root = rawCid(b, data)
+		blocks[root] = data
+	}
+	dir := b.TempDir()
+	indexedPath := filepath.Join(dir, "indexed.car")
+	writeCar(b, indexedPath, []cid.Cid{root}, blocks)
+	// the same CARv2, without the index, which is generated by scanning it
+	unindexedPath := filepath.Join(dir, "unindexed.car")
+	writeCar(b, unindexedPath, []cid.Cid{root}, blocks)
+	stripIndex(b, unindexedPath)
+
+	for _, bc := range []struct {
+		name string
+		path string
+	}{
+		{"embedded index", indexedPath},
+		{"scan", unindexedPath},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				c, err := util.OpenCar(bc.path)
+				if err != nil {
+					b.Fatal(err)
+				}
+				c.Close()
+			}
+		})
+	}
+}
+
+func rawCid(t testing.TB, data string) cid.Cid {
+	c, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: 0x12, MhLength: -1}.Sum([]byte(data))
+	require.NoError(t, err)
+	return c
+}
+
+func writeCar(t testing.TB, carPath string, roots []cid.Cid, blocks map[cid.Cid]string, opts ...car.Option) {
+	req := require.New(t)
+	carFile, err := os.Create(carPath)
+	req.NoError(err)
+	carWriter, err := storage.NewWritable(carFile, roots, opts...)
+	req.NoError(err)
+	for c, data := range blocks {
+		req.NoError(carWriter.Put(context.Background(), c.KeyString(), []byte(data)))
+	}
+	req.NoError(carWriter.Finalize())
+	req.NoError(carFile.Close())
+}
+
+// stripIndex rewrites the header of the CARv2 at carPath to have no index, and
+// truncates the index that follows its data payload.
+func stripIndex(t testing.TB, carPath string) {
+	req := require.New(t)
+	carFile, err := os.OpenFile(carPath, os.O_RDWR, 0)
+	req.NoError(err)
+	defer carFile.Close()
+	v2r, err := car.NewReader(carFile)
+	req.NoError(err)
+	header := v2r.Header
+	header.IndexOffset = 0
+	_, err = header.WriteTo(io.NewOffsetWriter(carFile, car.PragmaSize))
+	req.NoError(err)
+	req.NoError(carFile.Truncate(int64(header.DataOffset + header.DataSize)))
+}
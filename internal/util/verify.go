@@ -0,0 +1,168 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync"
+
+	"github.com/ipni/go-libipni/find/client"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+// VerifyAnnounceConfig describes a check that an indexer has ingested the
+// announcements of a provider.
+type VerifyAnnounceConfig struct {
+	// Indexer is the base URL of the indexer's find API, e.g.
+	// https://cid.contact.
+	Indexer     string
+	Provider    peer.ID
+	Multihashes []multihash.Multihash
+	Concurrency int
+	Client      *http.Client
+}
+
+// VerifyAnnounceResult summarises a check of an indexer. Each multihash that
+// wasn't found for the provider is listed, as a base58 string, in the order
+// it was checked.
+type VerifyAnnounceResult struct {
+	Checked       int                   `json:"checked"`
+	Present       int                   `json:"present"`
+	Coverage      float64               `json:"coverage"`
+	Missing       []string              `json:"missing,omitempty"`
+	OtherProvider []OtherProviderResult `json:"otherProvider,omitempty"`
+	Failed        []FailedFindResult    `json:"failed,omitempty"`
+}
+
+// OtherProviderResult is a multihash that the indexer has records for, but
+// only from providers other than the one being verified.
+type OtherProviderResult struct {
+	Multihash string   `json:"multihash"`
+	Providers []string `json:"providers"`
+}
+
+// FailedFindResult is a multihash whose find query failed.
+type FailedFindResult struct {
+	Multihash string `json:"multihash"`
+	Error     string `json:"error"`
+}
+
+type findOutcome struct {
+	present   bool
+	providers []string
+	err       error
+}
+
+// VerifyAnnounce queries the indexer for each of cfg.Multihashes, from
+// cfg.Concurrency workers, and reports which have a record from cfg.Provider,
+// which are missing entirely and which only have records from other
+// providers. A query that fails counts against the coverage, as the multihash
+// couldn't be confirmed to be present.
+func VerifyAnnounce(ctx context.Context, cfg VerifyAnnounceConfig) (VerifyAnnounceResult, error) {
+	if cfg.Concurrency < 1 {
+		return VerifyAnnounceResult{}, errors.New("concurrency must be at least 1")
+	}
+	if cfg.Provider == "" {
+		return VerifyAnnounceResult{}, errors.New("provider must be set")
+	}
+	httpClient := cfg.Client
+	if httpClient == nil {
+		httpClient = &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: cfg.Concurrency}}
+	}
+	finder, err := client.New(cfg.Indexer, client.WithClient(httpClient))
+	if err != nil {
+		return VerifyAnnounceResult{}, err
+	}
+
+	outcomes := make([]findOutcome, len(cfg.Multihashes))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range next {
+				outcomes[idx] = find(ctx, finder, cfg.Provider, cfg.Multihashes[idx])
+			}
+		}()
+	}
+dispatch:
+	for idx := range cfg.Multihashes {
+		select {
+		case next <- idx:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(next)
+	wg.Wait()
+	if ctx.Err() != nil {
+		return VerifyAnnounceResult{}, ctx.Err()
+	}
+
+	result := VerifyAnnounceResult{Checked: len(cfg.Multihashes), Coverage: 1}
+	for idx, outcome := range outcomes {
+		mh := cfg.Multihashes[idx].B58String()
+		switch {
+		case outcome.err != nil:
+			result.Failed = append(result.Failed, FailedFindResult{Multihash: mh, Error: outcome.err.Error()})
+		case outcome.present:
+			result.Present++
+		case len(outcome.providers) > 0:
+			result.OtherProvider = append(result.OtherProvider, OtherProviderResult{Multihash: mh, Providers: outcome.providers})
+		default:
+			result.Missing = append(result.Missing, mh)
+		}
+	}
+	if result.Checked > 0 {
+		result.Coverage = float64(result.Present) / float64(result.Checked)
+	}
+	return result, nil
+}
+
+func find(ctx context.Context, finder *client.Client, provider peer.ID, mh multihash.Multihash) findOutcome {
+	res, err := finder.Find(ctx, mh)
+	if err != nil {
+		return findOutcome{err: err}
+	}
+	var outcome findOutcome
+	seen := make(map[peer.ID]struct{})
+	for _, mhr := range res.MultihashResults {
+		for _, pr := range mhr.ProviderResults {
+			if pr.Provider == nil {
+				continue
+			}
+			if pr.Provider.ID == provider {
+				return findOutcome{present: true}
+			}
+			if _, ok := seen[pr.Provider.ID]; !ok {
+				seen[pr.Provider.ID] = struct{}{}
+				outcome.providers = append(outcome.providers, pr.Provider.ID.String())
+			}
+		}
+	}
+	return outcome
+}
+
+// SampleMultihashes returns up to n of mhs, chosen at random with the given
+// seed, in their original order. Where n is 0 or at least len(mhs), mhs is
+// returned as-is.
+func SampleMultihashes(mhs []multihash.Multihash, n int, seed int64) []multihash.Multihash {
+	if n <= 0 || n >= len(mhs) {
+		return mhs
+	}
+	chosen := rand.New(rand.NewSource(seed)).Perm(len(mhs))[:n]
+	keep := make(map[int]struct{}, n)
+	for _, idx := range chosen {
+		keep[idx] = struct{}{}
+	}
+	sample := make([]multihash.Multihash, 0, n)
+	for idx, mh := range mhs {
+		if _, ok := keep[idx]; ok {
+			sample = append(sample, mh)
+		}
+	}
+	return sample
+}
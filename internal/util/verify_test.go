@@ -0,0 +1,158 @@
+package util_test
+
+import (
+	"context"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	util "github.com/ipld/frisbii/internal/util"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyAnnounce(t *testing.T) {
+	req := require.New(t)
+
+	newPeer := func() peer.ID {
+		_, pubKey, err := crypto.GenerateEd25519Key(rand.Reader)
+		req.NoError(err)
+		id, err := peer.IDFromPublicKey(pubKey)
+		req.NoError(err)
+		return id
+	}
+	provider, other := newPeer(), newPeer()
+
+	mhs := make([]multihash.Multihash, 0)
+	for i := 0; i < 10; i++ {
+		mh, err := multihash.Sum([]byte(strconv.Itoa(i)), multihash.SHA2_256, -1)
+		req.NoError(err)
+		mhs = append(mhs, mh)
+	}
+	// 0-5 are present, 6 and 7 only have other providers, 8 is missing and the
+	// query for 9 fails
+	providers := func(idx int) []peer.ID {
+		switch {
+		case idx < 3:
+			return []peer.ID{provider}
+		case idx < 6:
+			return []peer.ID{other, provider}
+		case idx < 8:
+			return []peer.ID{other, other}
+		}
+		return nil
+	}
+
+	indexer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || !strings.HasPrefix(r.URL.Path, "/multihash/") {
+			http.NotFound(res, r)
+			return
+		}
+		mh, err := multihash.FromB58String(strings.TrimPrefix(r.URL.Path, "/multihash/"))
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		idx := -1
+		for i := range mhs {
+			if string(mhs[i]) == string(mh) {
+				idx = i
+			}
+		}
+		if idx == 9 {
+			http.Error(res, "boom", http.StatusInternalServerError)
+			return
+		}
+		ids := providers(idx)
+		if len(ids) == 0 {
+			http.NotFound(res, r)
+			return
+		}
+		mhr := model.MultihashResult{Multihash: mh}
+		for _, id := range ids {
+			mhr.ProviderResults = append(mhr.ProviderResults, model.ProviderResult{
+				ContextID: []byte("frisbii"),
+				Provider:  &peer.AddrInfo{ID: id},
+			})
+		}
+		byts, err := model.MarshalFindResponse(&model.FindResponse{MultihashResults: []model.MultihashResult{mhr}})
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.Header().Set("Content-Type", "application/json")
+		_, _ = res.Write(byts)
+	}))
+	defer indexer.Close()
+
+	result, err := util.VerifyAnnounce(context.Background(), util.VerifyAnnounceConfig{
+		Indexer:     indexer.URL,
+		Provider:    provider,
+		Multihashes: mhs,
+		Concurrency: 3,
+	})
+	req.NoError(err)
+	req.Equal(10, result.Checked)
+	req.Equal(6, result.Present)
+	req.InDelta(0.6, result.Coverage, 0.0001)
+	req.Equal([]string{mhs[8].B58String()}, result.Missing)
+	req.Equal([]util.OtherProviderResult{
+		{Multihash: mhs[6].B58String(), Providers: []string{other.String()}},
+		{Multihash: mhs[7].B58String(), Providers: []string{other.String()}},
+	}, result.OtherProvider)
+	req.Len(result.Failed, 1)
+	req.Equal(mhs[9].B58String(), result.Failed[0].Multihash)
+
+	// nothing to check is complete coverage
+	result, err = util.VerifyAnnounce(context.Background(), util.VerifyAnnounceConfig{
+		Indexer:     indexer.URL,
+		Provider:    provider,
+		Concurrency: 1,
+	})
+	req.NoError(err)
+	req.Equal(0, result.Checked)
+	req.Equal(1.0, result.Coverage)
+
+	_, err = util.VerifyAnnounce(context.Background(), util.VerifyAnnounceConfig{Indexer: indexer.URL, Concurrency: 1})
+	req.ErrorContains(err, "provider must be set")
+	_, err = util.VerifyAnnounce(context.Background(), util.VerifyAnnounceConfig{Indexer: indexer.URL, Provider: provider})
+	req.ErrorContains(err, "concurrency must be at least 1")
+}
+
+func TestSampleMultihashes(t *testing.T) {
+	req := require.New(t)
+
+	mhs := make([]multihash.Multihash, 0)
+	for i := 0; i < 100; i++ {
+		mh, err := multihash.Sum([]byte(strconv.Itoa(i)), multihash.SHA2_256, -1)
+		req.NoError(err)
+		mhs = append(mhs, mh)
+	}
+
+	req.Equal(mhs, util.SampleMultihashes(mhs, 0, 1))
+	req.Equal(mhs, util.SampleMultihashes(mhs, 100, 1))
+	req.Equal(mhs, util.SampleMultihashes(mhs, 1000, 1))
+
+	sample := util.SampleMultihashes(mhs, 10, 1)
+	req.Len(sample, 10)
+	req.Equal(sample, util.SampleMultihashes(mhs, 10, 1))
+	req.NotEqual(sample, util.SampleMultihashes(mhs, 10, 2))
+	// in their original order, without duplicates
+	last := -1
+	for _, mh := range sample {
+		idx := -1
+		for i := range mhs {
+			if string(mhs[i]) == string(mh) {
+				idx = i
+			}
+		}
+		req.Greater(idx, last)
+		last = idx
+	}
+}
@@ -0,0 +1,179 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/frisbii"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/ipni/index-provider/engine"
+	"github.com/ipni/index-provider/engine/xproviders"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// ExtendedProvidersConfig is the format of the file used to describe sibling
+// providers that the content announced by frisbii is also retrievable from,
+// e.g.:
+//
+//	{
+//	  "override": false,
+//	  "providers": [
+//	    {
+//	      "id": "12D3KooW...",
+//	      "addresses": ["/dns/mirror.example.com/tcp/443/https"],
+//	      "key": "mirror.key"
+//	    }
+//	  ]
+//	}
+//
+// Each provider must sign its own record, so the key of each provider is
+// required, in the same format as frisbii's own key file. Relative key paths
+// are resolved against the directory of the config file. Metadata is optional,
+// base64 encoded, and defaults to the same metadata that frisbii advertises
+// for itself.
+type ExtendedProvidersConfig struct {
+	Override  bool                     `json:"override"`
+	Providers []ExtendedProviderConfig `json:"providers"`
+}
+
+type ExtendedProviderConfig struct {
+	ID        string   `json:"id"`
+	Addresses []string `json:"addresses"`
+	Key       string   `json:"key"`
+	Metadata  []byte   `json:"metadata,omitempty"`
+}
+
+// ExtendedProviders is a validated ExtendedProvidersConfig, ready to be
+// published.
+type ExtendedProviders struct {
+	Override  bool
+	Providers []xproviders.Info
+}
+
+// LoadExtendedProviders reads and validates an ExtendedProvidersConfig file,
+// including loading the key of each provider.
+func LoadExtendedProviders(path string) (*ExtendedProviders, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config ExtendedProvidersConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse extended providers config [%s]: %w", path, err)
+	}
+	if len(config.Providers) == 0 {
+		return nil, fmt.Errorf("extended providers config [%s] contains no providers", path)
+	}
+
+	xp := &ExtendedProviders{Override: config.Override}
+	for ii, pc := range config.Providers {
+		info, err := pc.toInfo(filepath.Dir(path))
+		if err != nil {
+			return nil, fmt.Errorf("invalid extended provider #%d in [%s]: %w", ii, path, err)
+		}
+		xp.Providers = append(xp.Providers, info)
+	}
+	return xp, nil
+}
+
+func (pc ExtendedProviderConfig) toInfo(configDir string) (xproviders.Info, error) {
+	id, err := peer.Decode(pc.ID)
+	if err != nil {
+		return xproviders.Info{}, fmt.Errorf("invalid peer ID [%s]: %w", pc.ID, err)
+	}
+
+	if len(pc.Addresses) == 0 {
+		return xproviders.Info{}, errors.New("no addresses")
+	}
+	addrs := make([]multiaddr.Multiaddr, 0, len(pc.Addresses))
+	for _, a := range pc.Addresses {
+		maddr, err := multiaddr.NewMultiaddr(a)
+		if err != nil {
+			return xproviders.Info{}, fmt.Errorf("invalid address [%s]: %w", a, err)
+		}
+		addrs = append(addrs, maddr)
+	}
+
+	if pc.Key == "" {
+		return xproviders.Info{}, errors.New("no key")
+	}
+	keyPath := pc.Key
+	if !filepath.IsAbs(keyPath) {
+		keyPath = filepath.Join(configDir, keyPath)
+	}
+	keyData, err := os.ReadFile(keyPath)
+	if err != nil {
+		return xproviders.Info{}, err
+	}
+	privKey, err := crypto.UnmarshalPrivateKey(keyData)
+	if err != nil {
+		return xproviders.Info{}, fmt.Errorf("invalid key [%s]: %w", keyPath, err)
+	}
+	if !id.MatchesPrivateKey(privKey) {
+		return xproviders.Info{}, fmt.Errorf("key [%s] does not match peer ID [%s]", keyPath, pc.ID)
+	}
+
+	md := pc.Metadata
+	if len(md) == 0 {
+		if md, err = advertisementMetadata(); err != nil {
+			return xproviders.Info{}, err
+		}
+	} else {
+		m := metadata.Default.New()
+		if err := m.UnmarshalBinary(md); err != nil {
+			return xproviders.Info{}, fmt.Errorf("invalid metadata: %w", err)
+		}
+	}
+
+	return xproviders.NewInfo(id, privKey, md, addrs), nil
+}
+
+// PublishExtendedProviders publishes an advertisement declaring that the
+// content announced by frisbii is also available from the extended providers.
+// It is chained on to the engine's latest advertisement and applies to
+// frisbii's context ID, so it should be published after frisbii's own
+// advertisement. frisbii itself is included in the list of providers.
+func PublishExtendedProviders(
+	ctx context.Context,
+	eng *engine.Engine,
+	privKey crypto.PrivKey,
+	maddr multiaddr.Multiaddr,
+	xp *ExtendedProviders,
+) (cid.Cid, error) {
+	id, err := peer.IDFromPrivateKey(privKey)
+	if err != nil {
+		return cid.Undef, err
+	}
+	md, err := advertisementMetadata()
+	if err != nil {
+		return cid.Undef, err
+	}
+	lastAdCid, _, err := eng.GetLatestAdv(ctx)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	ad, err := xproviders.NewAdBuilder(id, privKey, []multiaddr.Multiaddr{maddr}).
+		WithContextID([]byte(frisbii.ContextID)).
+		WithMetadata(md).
+		WithOverride(xp.Override).
+		WithExtendedProviders(xp.Providers...).
+		WithLastAdID(lastAdCid).
+		BuildAndSign()
+	if err != nil {
+		return cid.Undef, err
+	}
+	return eng.Publish(ctx, *ad)
+}
+
+func advertisementMetadata() ([]byte, error) {
+	md := frisbii.AdvertisementMetadata()
+	return md.MarshalBinary()
+}
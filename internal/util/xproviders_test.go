@@ -0,0 +1,188 @@
+package util_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/frisbii"
+	util "github.com/ipld/frisbii/internal/util"
+	"github.com/ipld/go-car/v2"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/ingest/schema"
+	provider "github.com/ipni/index-provider"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtendedProviders(t *testing.T) {
+	req := require.New(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	mkKey := func(name string) peer.ID {
+		privKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		req.NoError(err)
+		data, err := crypto.MarshalPrivateKey(privKey)
+		req.NoError(err)
+		req.NoError(os.WriteFile(filepath.Join(dir, name), data, 0600))
+		id, err := peer.IDFromPrivateKey(privKey)
+		req.NoError(err)
+		return id
+	}
+	mirror1 := mkKey("mirror1.key")
+	mirror2 := mkKey("mirror2.key")
+
+	writeConfig := func(t *testing.T, config any) string {
+		data, err := json.Marshal(config)
+		require.NoError(t, err)
+		path := filepath.Join(dir, filepath.Base(t.Name())+".json")
+		require.NoError(t, os.WriteFile(path, data, 0600))
+		return path
+	}
+
+	t.Run("publish", func(t *testing.T) {
+		req := require.New(t)
+
+		path := writeConfig(t, util.ExtendedProvidersConfig{
+			Providers: []util.ExtendedProviderConfig{
+				{ID: mirror1.String(), Addresses: []string{"/dns/mirror1.example.com/tcp/443/https"}, Key: "mirror1.key"},
+				{ID: mirror2.String(), Addresses: []string{"/dns/mirror2.example.com/tcp/443/https"}, Key: filepath.Join(dir, "mirror2.key")},
+			},
+		})
+		xp, err := util.LoadExtendedProviders(path)
+		req.NoError(err)
+		req.Len(xp.Providers, 2)
+
+		privKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		req.NoError(err)
+		id, err := peer.IDFromPrivateKey(privKey)
+		req.NoError(err)
+		maddr, err := multiaddr.NewMultiaddr("/dns/example.com/tcp/3747/http")
+		req.NoError(err)
+
+		eng, err := util.NewEngine(privKey, maddr, "/ipni/", "", util.DefaultEntryChunkSize)
+		req.NoError(err)
+		eng.RegisterMultihashLister(func(ctx context.Context, id peer.ID, contextID []byte) (provider.MultihashIterator, error) {
+			return provider.SliceMultihashIterator(nil), nil
+		})
+		req.NoError(eng.Start(ctx))
+		defer eng.Shutdown()
+		mainAd, err := frisbii.NotifyPut(ctx, eng)
+		req.NoError(err)
+
+		head, err := util.PublishExtendedProviders(ctx, eng, privKey, maddr, xp)
+		req.NoError(err)
+
+		// round-trip through the exported CAR to check the serialized form
+		var buf bytes.Buffer
+		req.NoError(util.ExportAdvertisementsCar(ctx, eng, head, &buf))
+		carReader, err := car.NewBlockReader(&buf)
+		req.NoError(err)
+		req.Equal([]cid.Cid{head}, carReader.Roots)
+		blk, err := carReader.Next()
+		req.NoError(err)
+		req.Equal(head, blk.Cid())
+
+		ad, err := eng.GetAdv(ctx, head)
+		req.NoError(err)
+		signer, err := ad.VerifySignature()
+		req.NoError(err)
+		req.Equal(id, signer)
+		req.Equal(cidlink.Link{Cid: mainAd}, ad.PreviousID)
+		req.Equal([]byte(frisbii.ContextID), ad.ContextID)
+		req.Equal(schema.NoEntries, ad.Entries)
+		req.NotNil(ad.ExtendedProvider)
+		req.False(ad.ExtendedProvider.Override)
+
+		md := frisbii.AdvertisementMetadata()
+		mdBytes, err := md.MarshalBinary()
+		req.NoError(err)
+		req.Len(ad.ExtendedProvider.Providers, 3)
+		for ii, expected := range []struct {
+			id   peer.ID
+			addr string
+		}{
+			{mirror1, "/dns/mirror1.example.com/tcp/443/https"},
+			{mirror2, "/dns/mirror2.example.com/tcp/443/https"},
+			{id, maddr.String()},
+		} {
+			p := ad.ExtendedProvider.Providers[ii]
+			req.Equal(expected.id.String(), p.ID)
+			req.Equal([]string{expected.addr}, p.Addresses)
+			req.Equal(mdBytes, p.Metadata)
+			req.NotEmpty(p.Signature)
+		}
+	})
+
+	for _, tc := range []struct {
+		name     string
+		config   any
+		expected string
+	}{
+		{
+			name:     "no providers",
+			config:   util.ExtendedProvidersConfig{},
+			expected: "contains no providers",
+		},
+		{
+			name:     "malformed",
+			config:   "not a config",
+			expected: "failed to parse extended providers config",
+		},
+		{
+			name: "bad peer ID",
+			config: util.ExtendedProvidersConfig{Providers: []util.ExtendedProviderConfig{
+				{ID: "bork", Addresses: []string{"/dns/mirror1.example.com/tcp/443/https"}, Key: "mirror1.key"},
+			}},
+			expected: "invalid peer ID [bork]",
+		},
+		{
+			name: "no addresses",
+			config: util.ExtendedProvidersConfig{Providers: []util.ExtendedProviderConfig{
+				{ID: mirror1.String(), Key: "mirror1.key"},
+			}},
+			expected: "no addresses",
+		},
+		{
+			name: "bad address",
+			config: util.ExtendedProvidersConfig{Providers: []util.ExtendedProviderConfig{
+				{ID: mirror1.String(), Addresses: []string{"mirror1.example.com:443"}, Key: "mirror1.key"},
+			}},
+			expected: "invalid address [mirror1.example.com:443]",
+		},
+		{
+			name: "no key",
+			config: util.ExtendedProvidersConfig{Providers: []util.ExtendedProviderConfig{
+				{ID: mirror1.String(), Addresses: []string{"/dns/mirror1.example.com/tcp/443/https"}},
+			}},
+			expected: "no key",
+		},
+		{
+			name: "mismatched key",
+			config: util.ExtendedProvidersConfig{Providers: []util.ExtendedProviderConfig{
+				{ID: mirror1.String(), Addresses: []string{"/dns/mirror1.example.com/tcp/443/https"}, Key: "mirror2.key"},
+			}},
+			expected: "does not match peer ID",
+		},
+		{
+			name: "bad metadata",
+			config: util.ExtendedProvidersConfig{Providers: []util.ExtendedProviderConfig{
+				{ID: mirror1.String(), Addresses: []string{"/dns/mirror1.example.com/tcp/443/https"}, Key: "mirror1.key", Metadata: []byte("bork")},
+			}},
+			expected: "invalid metadata",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := util.LoadExtendedProviders(writeConfig(t, tc.config))
+			require.ErrorContains(t, err, tc.expected)
+		})
+	}
+}
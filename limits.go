@@ -0,0 +1,55 @@
+package frisbii
+
+import (
+	"errors"
+	"net/http"
+)
+
+const (
+	// DefaultMaxHeaderBytes is the default maximum size of a request's request
+	// line and headers, see WithMaxHeaderBytes.
+	DefaultMaxHeaderBytes = 16 << 10
+	// DefaultMaxRequestURIBytes is the default maximum size of a request's URI,
+	// including the query string, see WithMaxRequestURIBytes.
+	DefaultMaxRequestURIBytes = 8 << 10
+)
+
+var (
+	errRequestURITooLong = errors.New("request URI too long")
+	errHeaderTooLarge    = errors.New("request header fields too large")
+)
+
+// checkRequestSize checks the size of the request URI and headers against the
+// configured limits, returning the status and error to respond with where a
+// limit is exceeded. The URI is checked first as overly long URIs are the most
+// likely cause of an oversized request, and deserve the more specific status.
+func checkRequestSize(req *http.Request, cfg *httpOptions) (int, error) {
+	uri := req.RequestURI
+	if uri == "" {
+		uri = req.URL.RequestURI()
+	}
+	if cfg.MaxRequestURIBytes > 0 && len(uri) > cfg.MaxRequestURIBytes {
+		return http.StatusRequestURITooLong, errRequestURITooLong
+	}
+	if cfg.MaxHeaderBytes > 0 && requestHeaderSize(req, uri) > cfg.MaxHeaderBytes {
+		return http.StatusRequestHeaderFieldsTooLarge, errHeaderTooLarge
+	}
+	return 0, nil
+}
+
+// requestHeaderSize approximates the size of the request line and headers as
+// they were received.
+func requestHeaderSize(req *http.Request, uri string) int {
+	// METHOD URI PROTO\r\n
+	size := len(req.Method) + len(uri) + len(req.Proto) + 4
+	if req.Host != "" {
+		size += len("Host: \r\n") + len(req.Host)
+	}
+	for k, vs := range req.Header {
+		for _, v := range vs {
+			// Key: Value\r\n
+			size += len(k) + len(v) + 4
+		}
+	}
+	return size
+}
@@ -0,0 +1,131 @@
+package frisbii_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ipld/frisbii"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+	trustlesstestutil "github.com/ipld/go-trustless-utils/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHttpIpfsRequestSizeLimits(t *testing.T) {
+	store := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	dupyLinks, _ := mkDupy(lsys)
+	path := "/ipfs/" + dupyLinks[0].String()
+
+	testCases := []struct {
+		name         string
+		opts         []frisbii.HttpOption
+		query        string
+		header       string
+		expectStatus int
+		expectErr    string
+	}{
+		{
+			name:         "within defaults",
+			query:        "?dag-scope=all",
+			expectStatus: http.StatusOK,
+		},
+		{
+			name:         "long query with default limit",
+			query:        "?selector=" + strings.Repeat("a", frisbii.DefaultMaxRequestURIBytes),
+			expectStatus: http.StatusRequestURITooLong,
+			expectErr:    "request URI too long",
+		},
+		{
+			name:         "long query with raised limit",
+			opts:         []frisbii.HttpOption{frisbii.WithMaxRequestURIBytes(frisbii.DefaultMaxRequestURIBytes * 2)},
+			query:        "?selector=" + strings.Repeat("a", frisbii.DefaultMaxRequestURIBytes),
+			expectStatus: http.StatusOK,
+		},
+		{
+			name:         "long query with no limit",
+			opts:         []frisbii.HttpOption{frisbii.WithMaxRequestURIBytes(0), frisbii.WithMaxHeaderBytes(0)},
+			query:        "?selector=" + strings.Repeat("a", 1<<20),
+			expectStatus: http.StatusOK,
+		},
+		{
+			name:         "large header with default limit",
+			header:       strings.Repeat("a", frisbii.DefaultMaxHeaderBytes),
+			expectStatus: http.StatusRequestHeaderFieldsTooLarge,
+			expectErr:    "request header fields too large",
+		},
+		{
+			name:         "long query within a small header limit",
+			opts:         []frisbii.HttpOption{frisbii.WithMaxHeaderBytes(1024)},
+			query:        "?selector=" + strings.Repeat("a", 1024),
+			expectStatus: http.StatusRequestHeaderFieldsTooLarge,
+			expectErr:    "request header fields too large",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+			var logBuf bytes.Buffer
+			opts := append([]frisbii.HttpOption{frisbii.WithLogWriter(&logBuf)}, tc.opts...)
+			handler := frisbii.NewLogMiddleware(frisbii.NewHttpIpfs(context.Background(), lsys, opts...), opts...)
+
+			request := httptest.NewRequest(http.MethodGet, path+tc.query, nil)
+			request.Header.Set("Accept", trustlesshttp.DefaultContentType().String())
+			if tc.header != "" {
+				request.Header.Set("X-Padding", tc.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, request)
+
+			req.Equal(tc.expectStatus, rec.Code)
+			if tc.expectErr != "" {
+				req.Equal(tc.expectErr, rec.Body.String())
+				req.Contains(logBuf.String(), `"`+tc.expectErr+`"`)
+			}
+		})
+	}
+}
+
+func TestFrisbiiServerMaxHeaderBytes(t *testing.T) {
+	req := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	dupyLinks, _ := mkDupy(lsys)
+
+	server, err := frisbii.NewFrisbiiServer(ctx, lsys, "localhost:0", frisbii.WithMaxHeaderBytes(1024))
+	req.NoError(err)
+	defer server.Close()
+	go server.Serve()
+
+	get := func(padding int) int {
+		request, err := http.NewRequest(http.MethodGet, "http://"+server.Addr().String()+"/ipfs/"+dupyLinks[0].String(), nil)
+		req.NoError(err)
+		request.Header.Set("Accept", trustlesshttp.DefaultContentType().String())
+		request.Header.Set("X-Padding", strings.Repeat("a", padding))
+		res, err := http.DefaultClient.Do(request)
+		req.NoError(err)
+		defer res.Body.Close()
+		_, _ = io.Copy(io.Discard, res.Body)
+		return res.StatusCode
+	}
+
+	req.Equal(http.StatusOK, get(100))
+	// rejected by the handler, within the slack that net/http allows
+	req.Equal(http.StatusRequestHeaderFieldsTooLarge, get(2048))
+	// rejected by net/http before reaching the handler
+	req.Equal(http.StatusRequestHeaderFieldsTooLarge, get(1<<20))
+}
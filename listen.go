@@ -0,0 +1,144 @@
+package frisbii
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// UnixSocketPrefix is the prefix of a listen address that is a path to a Unix
+// domain socket rather than a TCP host and port, e.g. "unix:/run/frisbii.sock".
+const UnixSocketPrefix = "unix:"
+
+// UnixSocketMode is the file mode applied to a Unix domain socket created for
+// a "unix:" listen address, restricting access to the owner and group.
+const UnixSocketMode fs.FileMode = 0660
+
+// connLimitLogInterval is the minimum interval between logging that the
+// connection limit has been reached, so a server that is persistently at its
+// limit doesn't flood the log.
+const connLimitLogInterval = time.Minute
+
+// listen creates a listener for address, which is either a TCP host and port,
+// or a Unix domain socket path with a UnixSocketPrefix. The keepAlive period
+// applies to accepted TCP connections, see net.ListenConfig, and where
+// maxConns is greater than 0 no more than that many connections are open at
+// once.
+func listen(address string, keepAlive time.Duration, maxConns int) (net.Listener, error) {
+	var listener net.Listener
+	var err error
+	if path, ok := strings.CutPrefix(address, UnixSocketPrefix); ok {
+		listener, err = listenUnix(path)
+	} else {
+		lc := net.ListenConfig{KeepAlive: keepAlive}
+		listener, err = lc.Listen(context.Background(), "tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if maxConns > 0 {
+		listener = newLimitListener(listener, maxConns)
+	}
+	return listener, nil
+}
+
+// listenUnix listens on a Unix domain socket at path. A socket file left
+// behind by a previous process that didn't shut down cleanly is removed, but
+// a socket that is still being listened on, or a file at path that isn't a
+// socket, is an error. The socket file is removed when the listener is closed.
+func listenUnix(path string) (net.Listener, error) {
+	if path == "" {
+		return nil, errors.New("missing Unix domain socket path")
+	}
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("cannot listen on %s: file exists and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("cannot listen on %s: socket is in use", path)
+		}
+		logger.Infof("Removing stale socket %s", path)
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, UnixSocketMode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// limitListener is a net.Listener that accepts no more than a fixed number of
+// connections at once. Once the limit is reached, Accept blocks until an open
+// connection is closed, so further connections wait in the listen backlog
+// rather than being accepted and dropped.
+type limitListener struct {
+	net.Listener
+	sem       chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	lastLog   atomic.Int64
+}
+
+func newLimitListener(listener net.Listener, maxConns int) *limitListener {
+	return &limitListener{
+		Listener: listener,
+		sem:      make(chan struct{}, maxConns),
+		done:     make(chan struct{}),
+	}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	select {
+	case l.sem <- struct{}{}:
+	default:
+		if now := time.Now().UnixNano(); now-l.lastLog.Load() >= int64(connLimitLogInterval) {
+			l.lastLog.Store(now)
+			logger.Warnf("Connection limit of %d reached, new connections will wait for an open connection to close", cap(l.sem))
+		}
+		select {
+		case l.sem <- struct{}{}:
+		case <-l.done:
+			return nil, net.ErrClosed
+		}
+	}
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		<-l.sem
+		return nil, err
+	}
+	return &limitListenerConn{Conn: conn, release: func() { <-l.sem }}, nil
+}
+
+func (l *limitListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// limitListenerConn releases its slot in a limitListener when it is closed.
+type limitListenerConn struct {
+	net.Conn
+	releaseOnce sync.Once
+	release     func()
+}
+
+func (c *limitListenerConn) Close() error {
+	err := c.Conn.Close()
+	c.releaseOnce.Do(c.release)
+	return err
+}
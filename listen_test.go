@@ -0,0 +1,167 @@
+package frisbii_test
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipld/frisbii"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+	trustlesstestutil "github.com/ipld/go-trustless-utils/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrisbiiServerUnixSocket(t *testing.T) {
+	store := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	dupyLinks, _ := mkDupy(lsys)
+
+	// keep the path short, sockets have a path length limit of ~100 bytes
+	dir, err := os.MkdirTemp("", "frisbii")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	t.Run("serve", func(t *testing.T) {
+		req := require.New(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		socketPath := filepath.Join(dir, "serve.sock")
+
+		server, err := frisbii.NewFrisbiiServer(ctx, lsys, frisbii.UnixSocketPrefix+socketPath)
+		req.NoError(err)
+		req.Equal("unix", server.Addr().Network())
+		go server.Serve()
+
+		fi, err := os.Stat(socketPath)
+		req.NoError(err)
+		req.True(fi.Mode()&fs.ModeSocket != 0)
+		req.Equal(frisbii.UnixSocketMode, fi.Mode().Perm())
+
+		// a second server can't take over a socket in use
+		_, err = frisbii.NewFrisbiiServer(ctx, lsys, frisbii.UnixSocketPrefix+socketPath)
+		req.ErrorContains(err, "socket is in use")
+
+		client := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socketPath)
+			},
+		}}
+		request, err := http.NewRequest(http.MethodGet, "http://localhost/ipfs/"+dupyLinks[0].String(), nil)
+		req.NoError(err)
+		request.Header.Set("Accept", trustlesshttp.DefaultContentType().String())
+		res, err := client.Do(request)
+		req.NoError(err)
+		res.Body.Close()
+		req.Equal(http.StatusOK, res.StatusCode)
+		client.CloseIdleConnections()
+
+		req.NoError(server.Close())
+		_, err = os.Stat(socketPath)
+		req.ErrorIs(err, fs.ErrNotExist)
+	})
+
+	t.Run("stale socket", func(t *testing.T) {
+		req := require.New(t)
+		socketPath := filepath.Join(dir, "stale.sock")
+		listener, err := net.Listen("unix", socketPath)
+		req.NoError(err)
+		// simulate an unclean shutdown, leaving the socket file behind
+		listener.(*net.UnixListener).SetUnlinkOnClose(false)
+		req.NoError(listener.Close())
+		_, err = os.Stat(socketPath)
+		req.NoError(err)
+
+		server, err := frisbii.NewFrisbiiServer(context.Background(), lsys, frisbii.UnixSocketPrefix+socketPath)
+		req.NoError(err)
+		req.NoError(server.Close())
+	})
+
+	t.Run("not a socket", func(t *testing.T) {
+		req := require.New(t)
+		filePath := filepath.Join(dir, "file.sock")
+		req.NoError(os.WriteFile(filePath, []byte("not a socket"), 0644))
+
+		_, err := frisbii.NewFrisbiiServer(context.Background(), lsys, frisbii.UnixSocketPrefix+filePath)
+		req.ErrorContains(err, "file exists and is not a socket")
+		_, err = os.Stat(filePath)
+		req.NoError(err)
+	})
+}
+
+func TestFrisbiiServerMaxConnections(t *testing.T) {
+	const maxConns = 4
+	const clients = 40
+
+	countFds := func() int {
+		entries, err := os.ReadDir("/proc/self/fd")
+		if err != nil {
+			t.Skip("unable to count open file descriptors:", err)
+		}
+		return len(entries)
+	}
+
+	req := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	dupyLinks, _ := mkDupy(lsys)
+
+	server, err := frisbii.NewFrisbiiServer(ctx, lsys, "localhost:0", frisbii.WithMaxConnections(maxConns), frisbii.WithTCPKeepAlive(time.Second))
+	req.NoError(err)
+	defer server.Close()
+	go server.Serve()
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	get := func(timeout time.Duration) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+server.Addr().String()+"/ipfs/"+dupyLinks[0].String(), nil)
+		req.NoError(err)
+		request.Header.Set("Accept", trustlesshttp.DefaultContentType().String())
+		res, err := client.Do(request)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		_, err = io.Copy(io.Discard, res.Body)
+		return err
+	}
+	req.NoError(get(5 * time.Second))
+
+	baseline := countFds()
+	// idle connections that never send a request hold their slots open
+	conns := make([]net.Conn, 0, clients)
+	for i := 0; i < clients; i++ {
+		conn, err := net.Dial("tcp", server.Addr().String())
+		req.NoError(err)
+		conns = append(conns, conn)
+	}
+	time.Sleep(200 * time.Millisecond)
+	// each client connection is one fd, and the server holds one for each
+	// connection it has accepted
+	req.LessOrEqual(countFds()-baseline-clients, maxConns)
+
+	// a request waits for a slot rather than being refused
+	req.ErrorIs(get(200*time.Millisecond), context.DeadlineExceeded)
+	done := make(chan error, 1)
+	go func() { done <- get(10 * time.Second) }()
+	for _, conn := range conns {
+		conn.Close()
+	}
+	req.NoError(<-done)
+}
@@ -9,8 +9,12 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/ipfs/go-cid"
 )
 
 var _ http.Handler = (*LogMiddleware)(nil)
@@ -33,9 +37,12 @@ type LogHandler func(
 // it wraps requests in a LoggingResponseWriter that can be used to log
 // standardised messages to the writer.
 type LogMiddleware struct {
-	next       http.Handler
-	logWriter  io.Writer
-	logHandler LogHandler
+	next        http.Handler
+	logWriter   io.Writer
+	logHandler  LogHandler
+	redactQuery bool
+	minStatus   int
+	observer    RequestObserver
 }
 
 // NewLogMiddleware creates a new LogMiddleware to insert into an HTTP call
@@ -44,21 +51,59 @@ type LogMiddleware struct {
 // The WithLogWriter option can be used to set the writer to log to.
 //
 // The WithLogHandler option can be used to set a custom log handler.
+//
+// The WithRequestObserver option can be used to receive a RequestEvent for
+// each request.
 func NewLogMiddleware(next http.Handler, httpOptions ...HttpOption) *LogMiddleware {
 	cfg := toConfig(httpOptions)
+	var logWriter io.Writer
+	if cfg.LogWriter != nil {
+		logWriter = newLogSink(cfg.LogWriter, cfg.LogFallbackWriter)
+	}
 	return &LogMiddleware{
-		next:       next,
-		logWriter:  cfg.LogWriter,
-		logHandler: cfg.LogHandler,
+		next:        next,
+		logWriter:   logWriter,
+		logHandler:  cfg.LogHandler,
+		redactQuery: cfg.LogRedactQuery,
+		minStatus:   cfg.LogMinStatus,
+		observer:    cfg.RequestObserver,
 	}
 }
 
 func (lm *LogMiddleware) ServeHTTP(res http.ResponseWriter, req *http.Request) {
-	if lm.logHandler != nil || lm.logWriter != nil {
+	if lm.logHandler != nil || lm.logWriter != nil || lm.observer != nil {
 		lres := NewLoggingResponseWriter(res, req, lm.logWriter, lm.logHandler)
+		lres.redactQuery = lm.redactQuery
+		lres.minStatus = lm.minStatus
 		start := time.Now()
 		defer func() {
-			lres.Log(lres.status, start, lres.sentBytes, lres.CompressionRatio(), "")
+			// observe panics too, then let net/http deal with them as it normally
+			// would
+			r := recover()
+			if r != nil {
+				lres.err = fmt.Errorf("panic: %v", r)
+				if lres.status == 0 {
+					lres.status = http.StatusInternalServerError
+				}
+			}
+			finish := func() {
+				lres.Log(lres.status, start, lres.sentBytes, lres.CompressionRatio(), "")
+				if lm.observer != nil {
+					lm.observer.ObserveRequest(lres.event(start))
+				}
+			}
+			if lres.hijacked != nil && r == nil {
+				// the connection outlives the handler, so bytes may still be sent
+				lres.hijacked.onClosed(func(written int) {
+					lres.sentBytes += written
+					finish()
+				})
+			} else {
+				finish()
+			}
+			if r != nil {
+				panic(r)
+			}
 		}()
 		res = lres
 	}
@@ -69,13 +114,19 @@ var _ http.ResponseWriter = (*LoggingResponseWriter)(nil)
 
 type LoggingResponseWriter struct {
 	http.ResponseWriter
-	logWriter  io.Writer
-	logHandler LogHandler
-	req        *http.Request
-	status     int
-	wroteBytes int
-	sentBytes  int
-	wrote      bool
+	logWriter   io.Writer
+	logHandler  LogHandler
+	req         *http.Request
+	redactQuery bool
+	minStatus   int
+	rootCid     cid.Cid
+	err         error
+	status      int
+	wroteBytes  int
+	sentBytes   int
+	firstByte   time.Time
+	wrote       bool
+	hijacked    *hijackedConn
 }
 
 // NewLoggingResponseWriter creates a new LoggingResponseWriter that is used
@@ -103,11 +154,27 @@ func (w *LoggingResponseWriter) WroteBytes(n int) {
 	w.wroteBytes += n
 }
 
-func (w *LoggingResponseWriter) CompressionRatio() string {
+// CompressionRatioFloat returns the compression ratio of the response, the
+// number of bytes written in to the response, see WroteBytes, divided by the
+// number of bytes sent, and true; or 0 and false where the response wasn't
+// compressed, i.e. nothing was sent, WroteBytes wasn't called, or the two
+// sizes are the same.
+func (w *LoggingResponseWriter) CompressionRatioFloat() (float64, bool) {
 	if w.sentBytes == 0 || w.wroteBytes == 0 || w.wroteBytes == w.sentBytes {
+		return 0, false
+	}
+	return float64(w.wroteBytes) / float64(w.sentBytes), true
+}
+
+// CompressionRatio returns the CompressionRatioFloat formatted to two decimal
+// places, as it appears in the request log, or "-" where the response wasn't
+// compressed or the ratio rounds to zero.
+func (w *LoggingResponseWriter) CompressionRatio() string {
+	ratio, ok := w.CompressionRatioFloat()
+	if !ok {
 		return "-"
 	}
-	s := fmt.Sprintf("%.2f", float64(w.wroteBytes)/float64(w.sentBytes))
+	s := fmt.Sprintf("%.2f", ratio)
 	if s == "0.00" {
 		return "-"
 	}
@@ -124,26 +191,32 @@ func (w *LoggingResponseWriter) Log(
 	if w.wrote {
 		return
 	}
+	if msg == "" && status < w.minStatus {
+		// filtered out of the access log; errors are always logged
+		w.wrote = true
+		return
+	}
 	duration := time.Since(start)
+	ttfb := w.timeToFirstByte(start, duration)
 	w.wrote = true
-	remoteAddr := w.req.RemoteAddr
-	if ss := strings.Split(remoteAddr, ":"); len(ss) > 0 {
-		remoteAddr = ss[0]
-	}
+	remoteAddr := remoteHost(w.req.RemoteAddr)
+	logUrl := w.logUrl()
 	if w.logWriter != nil {
 		fmt.Fprintf(
 			w.logWriter,
-			"%s %s %s \"%s\" %d %d %d %s %s %s\n",
+			"%s %s %s \"%s\" %d %d %d %s %s %s %s %s\n",
 			start.Format(time.RFC3339),
 			remoteAddr,
 			w.req.Method,
-			w.req.URL,
+			&logUrl,
 			status,
 			duration.Milliseconds(),
 			bytes,
 			CompressionRatio,
 			strconv.Quote(w.req.UserAgent()),
 			strconv.Quote(msg),
+			formatMillis(duration),
+			formatMillis(ttfb),
 		)
 	}
 	if w.logHandler != nil {
@@ -151,7 +224,7 @@ func (w *LoggingResponseWriter) Log(
 			start,
 			remoteAddr,
 			w.req.Method,
-			*w.req.URL,
+			logUrl,
 			status,
 			duration,
 			bytes,
@@ -162,21 +235,151 @@ func (w *LoggingResponseWriter) Log(
 	}
 }
 
+// timeToFirstByte returns the time from start until the first byte of the
+// response body was written, or the total duration where no body has been
+// written since start, as is the case for 304s, HEAD requests and most errors.
+func (w *LoggingResponseWriter) timeToFirstByte(start time.Time, duration time.Duration) time.Duration {
+	if w.firstByte.IsZero() || w.firstByte.Before(start) {
+		return duration
+	}
+	return w.firstByte.Sub(start)
+}
+
+// formatMillis formats a duration as milliseconds with microsecond precision.
+func formatMillis(d time.Duration) string {
+	return strconv.FormatFloat(float64(d.Microseconds())/1000, 'f', 3, 64)
+}
+
+// maxLogWriteFailures is the number of consecutive failed writes to a request
+// log writer after which it is abandoned.
+const maxLogWriteFailures = 3
+
+// logSink is the request log writer of a LogMiddleware, which handles errors
+// from the underlying writer so that a broken log doesn't go unnoticed or
+// affect the serving of requests. After maxLogWriteFailures consecutive
+// failures it switches to its fallback writer, and after as many failures of
+// the fallback, it discards logs. Each switch is logged once.
+type logSink struct {
+	lk       sync.Mutex
+	writers  []io.Writer // the writer in use, followed by its fallback, if any
+	failures int
+}
+
+func newLogSink(w io.Writer, fallback io.Writer) *logSink {
+	writers := []io.Writer{w}
+	if fallback != nil {
+		writers = append(writers, fallback)
+	}
+	return &logSink{writers: writers}
+}
+
+// Write writes p to the writer in use, always reporting success to the
+// caller; write errors are handled by the sink. The write that causes a switch
+// to the fallback writer is retried with it.
+func (s *logSink) Write(p []byte) (int, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	for len(s.writers) > 0 {
+		_, err := s.writers[0].Write(p)
+		if err == nil {
+			s.failures = 0
+			break
+		}
+		if s.failures++; s.failures < maxLogWriteFailures {
+			break
+		}
+		s.writers = s.writers[1:]
+		s.failures = 0
+		if len(s.writers) == 0 {
+			logger.Errorf("Request log writer failed %d times, disabling request logging: %s", maxLogWriteFailures, err)
+		} else {
+			logger.Errorf("Request log writer failed %d times, logging requests to the fallback writer instead: %s", maxLogWriteFailures, err)
+		}
+	}
+	return len(p), nil
+}
+
+// remoteHost returns the host portion of a request's RemoteAddr, which is
+// typically in the form host:port, or [host]:port for IPv6. If the address
+// can't be parsed it is returned as-is. Requests received over a Unix domain
+// socket have no remote address (typically "" or "@"), these are reported as
+// "-".
+func remoteHost(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	if remoteAddr == "" || remoteAddr == "@" {
+		return "-"
+	}
+	return remoteAddr
+}
+
+// logUrl returns the request URL as it should appear in logs.
+func (w *LoggingResponseWriter) logUrl() url.URL {
+	logUrl := *w.req.URL
+	if w.redactQuery {
+		logUrl.RawQuery = redactQuery(logUrl.Query())
+	}
+	return logUrl
+}
+
+// safeQueryParams are query parameters whose values are logged even when
+// query redaction is enabled.
+var safeQueryParams = map[string]struct{}{
+	"format":        {},
+	"dag-scope":     {},
+	"entity-bytes":  {},
+	QueryCarVersion: {},
+	QueryCarOrder:   {},
+	QueryCarDups:    {},
+}
+
+const redactedQueryValue = "REDACTED"
+
+// redactQuery encodes the query with the values of all parameters that aren't
+// in safeQueryParams replaced with a placeholder.
+func redactQuery(query url.Values) string {
+	for key, values := range query {
+		if _, ok := safeQueryParams[key]; ok {
+			continue
+		}
+		for i := range values {
+			values[i] = redactedQueryValue
+		}
+	}
+	return query.Encode()
+}
+
 func (w *LoggingResponseWriter) LogError(status int, err error) {
+	w.err = err
 	msg := err.Error()
-	// unwrap error and find the msg at the bottom error
-	for {
-		if e := errors.Unwrap(err); e != nil {
-			msg = e.Error()
-			err = e
-		} else {
-			break
+	// unwrap error and find the msg at the bottom error, unless it's a failure
+	// to load a block, where the CID and path of the block are worth keeping,
+	// or a refused request, which is logged with what was refused and why
+	var te *TraversalError
+	var re refusalError
+	if errors.As(err, &te) {
+		msg = te.Error()
+	} else if errors.As(err, &re) {
+		msg = re.logMessage()
+	} else {
+		for {
+			if e := errors.Unwrap(err); e != nil {
+				msg = e.Error()
+				err = e
+			} else {
+				break
+			}
 		}
 	}
+	body := msg
+	if re != nil {
+		body = re.Error() // the client isn't told why
+	}
 	w.Log(status, time.Now(), 0, "-", msg)
 	w.status = status
 	if w.sentBytes == 0 {
-		http.Error(w.ResponseWriter, strconv.Quote(msg), status)
+		http.Error(w.ResponseWriter, strconv.Quote(body), status)
 	}
 }
 
@@ -189,14 +392,112 @@ func (w *LoggingResponseWriter) Write(b []byte) (int, error) {
 	if w.status == 0 {
 		w.status = http.StatusOK
 	}
+	if w.firstByte.IsZero() && len(b) > 0 {
+		w.firstByte = time.Now()
+	}
 	n, err := w.ResponseWriter.Write(b)
 	w.sentBytes += n
 	return n, err
 }
 
+// Hijack takes over the connection, as for a connection upgrade. Bytes
+// written to the returned connection, directly or through the returned
+// bufio.ReadWriter, are counted as sent, and the request is logged once the
+// handler has returned and the connection has been closed; a hijacked
+// connection that is never closed is never logged. Where no status has been
+// written, a hijacked connection is logged with a 101 Switching Protocols.
 func (w *LoggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
-	if hj, ok := w.ResponseWriter.(http.Hijacker); ok {
-		return hj.Hijack()
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("http.Hijacker not implemented")
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	hc := &hijackedConn{Conn: conn}
+	if brw != nil {
+		// route the buffered writer through the counting conn, having sent, and
+		// counted, anything it already holds
+		buffered := brw.Writer.Buffered()
+		if err := brw.Writer.Flush(); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		hc.written.Add(int64(buffered))
+		brw.Writer.Reset(hc)
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	w.hijacked = hc
+	return hc, brw, nil
+}
+
+var _ net.Conn = (*hijackedConn)(nil)
+
+// hijackedConn is a hijacked connection that counts the bytes written to it,
+// and reports them once it has been closed and the handler that hijacked it
+// has returned, whichever is later. Deadlines and the other methods of
+// net.Conn are those of the underlying connection.
+type hijackedConn struct {
+	net.Conn
+	written atomic.Int64
+
+	lk     sync.Mutex
+	closed bool
+	done   func(written int)
+}
+
+func (c *hijackedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+// Close closes the underlying connection; only the first call reports the
+// bytes written.
+func (c *hijackedConn) Close() error {
+	err := c.Conn.Close()
+	c.lk.Lock()
+	done := c.done
+	first := !c.closed
+	c.closed = true
+	c.lk.Unlock()
+	if first && done != nil {
+		done(int(c.written.Load()))
+	}
+	return err
+}
+
+// onClosed calls done with the number of bytes written once the connection
+// has been closed, which may be immediately. It's called once the handler has
+// returned, so that done isn't called while the handler may still be using the
+// LoggingResponseWriter.
+func (c *hijackedConn) onClosed(done func(written int)) {
+	c.lk.Lock()
+	closed := c.closed
+	c.done = done
+	c.lk.Unlock()
+	if closed {
+		done(int(c.written.Load()))
+	}
+}
+
+// unwrapLoggingResponseWriter returns the LoggingResponseWriter that res is, or
+// that res wraps for the purpose of compression, or nil if there isn't one.
+func unwrapLoggingResponseWriter(res http.ResponseWriter) *LoggingResponseWriter {
+	switch rw := res.(type) {
+	case *LoggingResponseWriter:
+		return rw
+	case *gziphandler.GzipResponseWriter:
+		if lrw, ok := rw.ResponseWriter.(*LoggingResponseWriter); ok {
+			return lrw
+		}
+	case *zstdResponseWriter:
+		if lrw, ok := rw.ResponseWriter.(*LoggingResponseWriter); ok {
+			return lrw
+		}
 	}
-	return nil, nil, errors.New("http.Hijacker not implemented")
+	return nil
 }
@@ -0,0 +1,427 @@
+package frisbii_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/frisbii"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogMiddlewareRedactQuery(t *testing.T) {
+	const secret = "sekr1t-t0ken"
+	const target = "/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi?dag-scope=entity&format=car&token=" + secret
+
+	for _, tc := range []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{
+			name: "Log",
+			handler: func(res http.ResponseWriter, req *http.Request) {
+				res.WriteHeader(http.StatusOK)
+				_, _ = res.Write([]byte("ok"))
+			},
+		},
+		{
+			name: "LogError",
+			handler: func(res http.ResponseWriter, req *http.Request) {
+				res.(frisbii.ErrorLogger).LogError(http.StatusNotFound, errors.New("not found"))
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, redact := range []bool{true, false} {
+				req := require.New(t)
+				var logBuf bytes.Buffer
+				mw := frisbii.NewLogMiddleware(tc.handler, frisbii.WithLogWriter(&logBuf), frisbii.WithLogRedactQuery(redact))
+				mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+
+				line := logBuf.String()
+				req.Contains(line, "dag-scope=entity")
+				req.Contains(line, "format=car")
+				if redact {
+					req.NotContains(line, secret)
+					req.Contains(line, "token=REDACTED")
+				} else {
+					req.Contains(line, "token="+secret)
+				}
+			}
+		})
+	}
+}
+
+func TestLogMiddlewareMinStatus(t *testing.T) {
+	target := "/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi"
+	okHandler := func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusOK)
+	}
+	notFoundHandler := func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusNotFound)
+	}
+	errorHandler := func(res http.ResponseWriter, req *http.Request) {
+		res.(frisbii.ErrorLogger).LogError(http.StatusBadRequest, errors.New("bork"))
+	}
+
+	for _, tc := range []struct {
+		name      string
+		minStatus int
+		handler   http.HandlerFunc
+		logged    bool
+	}{
+		{"no filter, ok", 0, okHandler, true},
+		{"no filter, not found", 0, notFoundHandler, true},
+		{"filtered, ok", 400, okHandler, false},
+		{"filtered, not found", 400, notFoundHandler, true},
+		{"filtered, error", 500, errorHandler, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+			var logBuf bytes.Buffer
+			mw := frisbii.NewLogMiddleware(tc.handler, frisbii.WithLogWriter(&logBuf), frisbii.WithLogMinStatus(tc.minStatus))
+			mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+			if tc.logged {
+				req.Contains(logBuf.String(), target)
+			} else {
+				req.Empty(logBuf.String())
+			}
+		})
+	}
+}
+
+func TestLogMiddlewareRemoteAddr(t *testing.T) {
+	for _, tc := range []struct {
+		remoteAddr string
+		expected   string
+	}{
+		{"192.0.2.1:54321", "192.0.2.1"},
+		{"[::1]:54321", "::1"},
+		{"[2001:db8::68]:443", "2001:db8::68"},
+		{"not-an-address", "not-an-address"},
+		{"@", "-"},
+		{"", "-"},
+	} {
+		t.Run(tc.remoteAddr, func(t *testing.T) {
+			req := require.New(t)
+			var logBuf bytes.Buffer
+			var handlerAddr string
+			mw := frisbii.NewLogMiddleware(
+				http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+					res.WriteHeader(http.StatusOK)
+				}),
+				frisbii.WithLogWriter(&logBuf),
+				frisbii.WithLogHandler(func(_ time.Time, remoteAddr string, _ string, _ url.URL, _ int, _ time.Duration, _ int, _ string, _ string, _ string) {
+					handlerAddr = remoteAddr
+				}),
+			)
+			request := httptest.NewRequest(http.MethodGet, "/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi", nil)
+			request.RemoteAddr = tc.remoteAddr
+			mw.ServeHTTP(httptest.NewRecorder(), request)
+
+			req.Equal(tc.expected, handlerAddr)
+			req.Equal(tc.expected, strings.Fields(logBuf.String())[1])
+		})
+	}
+}
+
+func TestLogMiddlewareTimeToFirstByte(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	target := "/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi"
+
+	for _, tc := range []struct {
+		name      string
+		handler   http.HandlerFunc
+		wroteBody bool
+	}{
+		{
+			name: "body",
+			handler: func(res http.ResponseWriter, req *http.Request) {
+				_, _ = res.Write([]byte("ok"))
+				time.Sleep(delay)
+				_, _ = res.Write([]byte("ok"))
+			},
+			wroteBody: true,
+		},
+		{
+			name: "no body",
+			handler: func(res http.ResponseWriter, req *http.Request) {
+				time.Sleep(delay)
+				res.WriteHeader(http.StatusNotModified)
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+			var logBuf bytes.Buffer
+			var event frisbii.RequestEvent
+			mw := frisbii.NewLogMiddleware(
+				tc.handler,
+				frisbii.WithLogWriter(&logBuf),
+				frisbii.WithRequestObserver(frisbii.RequestObserverFunc(func(e frisbii.RequestEvent) { event = e })),
+			)
+			mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+
+			fields := strings.Fields(logBuf.String())
+			req.Len(fields, 12)
+			duration, err := strconv.ParseFloat(fields[10], 64)
+			req.NoError(err)
+			ttfb, err := strconv.ParseFloat(fields[11], 64)
+			req.NoError(err)
+			req.GreaterOrEqual(duration, float64(delay.Milliseconds()))
+			req.Regexp(`^\d+\.\d{3}$`, fields[11])
+
+			req.GreaterOrEqual(event.Duration, delay)
+			if tc.wroteBody {
+				req.Less(ttfb, duration)
+				req.Less(event.TimeToFirstByte, delay)
+			} else {
+				req.Equal(duration, ttfb)
+				req.Equal(event.Duration, event.TimeToFirstByte)
+			}
+		})
+	}
+}
+
+// failingWriter fails every write for which fail returns true.
+type failingWriter struct {
+	writes int
+	fail   func(write int) bool
+	buf    bytes.Buffer
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if w.fail(w.writes) {
+		return 0, errors.New("write /dev/stdout: broken pipe")
+	}
+	return w.buf.Write(p)
+}
+
+func TestLogMiddlewareFailingWriter(t *testing.T) {
+	const requests = 5
+	target := "/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi"
+	alwaysFail := func(int) bool { return true }
+
+	for _, tc := range []struct {
+		name            string
+		fail            func(write int) bool
+		noFallback      bool
+		expectWrites    int
+		expectLines     int
+		expectFallbacks int
+	}{
+		{
+			name:            "fall back",
+			fail:            alwaysFail,
+			expectWrites:    3,
+			expectFallbacks: 3, // including the third, retried
+		},
+		{
+			name:         "disable without fallback",
+			fail:         alwaysFail,
+			noFallback:   true,
+			expectWrites: 3,
+		},
+		{
+			name:         "intermittent failures",
+			fail:         func(write int) bool { return write%2 == 0 },
+			expectWrites: requests,
+			expectLines:  3,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+			writer := &failingWriter{fail: tc.fail}
+			var fallback bytes.Buffer
+			opts := []frisbii.HttpOption{frisbii.WithLogWriter(writer), frisbii.WithLogFallbackWriter(&fallback)}
+			if tc.noFallback {
+				opts[1] = frisbii.WithLogFallbackWriter(nil)
+			}
+			mw := frisbii.NewLogMiddleware(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				_, _ = res.Write([]byte("ok"))
+			}), opts...)
+
+			for i := 0; i < requests; i++ {
+				rec := httptest.NewRecorder()
+				mw.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+				req.Equal(http.StatusOK, rec.Code)
+				req.Equal("ok", rec.Body.String())
+			}
+
+			req.Equal(tc.expectWrites, writer.writes)
+			req.Equal(tc.expectLines, strings.Count(writer.buf.String(), "\n"))
+			req.Equal(tc.expectFallbacks, strings.Count(fallback.String(), "\n"))
+		})
+	}
+}
+
+func TestLogMiddlewareTraversalError(t *testing.T) {
+	c := cid.MustParse("bafkreifzjut3te2nhyekklss27nh3k72ysco7y32koao5eei66wof36n5e")
+	for _, tc := range []struct {
+		name      string
+		err       error
+		expectMsg string
+	}{
+		{
+			name:      "bottom error",
+			err:       fmt.Errorf("error traversing node: %w", errors.New("bork")),
+			expectMsg: "bork",
+		},
+		{
+			name: "traversal error",
+			err: fmt.Errorf("error traversing node at %q: %w", "sub/file", &frisbii.TraversalError{
+				Cid:  c,
+				Path: datamodel.ParsePath("sub/file"),
+				Err:  fmt.Errorf("storage: %w", errors.New("bork")),
+			}),
+			expectMsg: `failed to load block ` + c.String() + ` at path "sub/file": storage: bork`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+			var logBuf bytes.Buffer
+			handler := func(res http.ResponseWriter, req *http.Request) {
+				res.(frisbii.ErrorLogger).LogError(http.StatusInternalServerError, tc.err)
+			}
+			mw := frisbii.NewLogMiddleware(http.HandlerFunc(handler), frisbii.WithLogWriter(&logBuf))
+			mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ipfs/"+c.String(), nil))
+			req.Contains(logBuf.String(), strconv.Quote(tc.expectMsg))
+		})
+	}
+}
+
+func TestLogMiddlewareHijack(t *testing.T) {
+	const upgrade = "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n"
+	payload := bytes.Repeat([]byte("x"), 10000)
+	expectBytes := len(upgrade) + 2*len(payload)
+
+	for _, tc := range []struct {
+		name           string
+		closeInHandler bool
+	}{
+		{"closed by handler", true},
+		{"closed after handler returns", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+
+			type logged struct {
+				status int
+				bytes  int
+			}
+			loggedCh := make(chan logged, 1)
+			logHandler := func(_ time.Time, _ string, _ string, _ url.URL, status int, _ time.Duration, bytes int, _ string, _ string, _ string) {
+				loggedCh <- logged{status, bytes}
+			}
+			handlerDone := make(chan struct{})
+			sendErr := make(chan error, 1)
+			handler := func(res http.ResponseWriter, r *http.Request) {
+				conn, brw, err := res.(http.Hijacker).Hijack()
+				req.NoError(err)
+				// deadlines still apply to the underlying connection
+				req.NoError(conn.SetWriteDeadline(time.Now().Add(5 * time.Second)))
+				_, err = brw.WriteString(upgrade)
+				req.NoError(err)
+				_, err = brw.Write(payload)
+				req.NoError(err)
+				req.NoError(brw.Flush())
+				send := func() error {
+					if _, err := conn.Write(payload); err != nil {
+						return err
+					}
+					if err := conn.Close(); err != nil {
+						return err
+					}
+					if err := conn.Close(); err == nil { // already closed, but only logged once
+						return errors.New("expected an error closing the connection twice")
+					}
+					return nil
+				}
+				if tc.closeInHandler {
+					sendErr <- send()
+					return
+				}
+				go func() {
+					<-handlerDone
+					sendErr <- send()
+				}()
+			}
+			mw := frisbii.NewLogMiddleware(http.HandlerFunc(handler), frisbii.WithLogHandler(logHandler))
+			testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+				defer close(handlerDone)
+				mw.ServeHTTP(res, r)
+			}))
+			defer testServer.Close()
+
+			conn, err := net.Dial("tcp", testServer.Listener.Addr().String())
+			req.NoError(err)
+			defer conn.Close()
+			_, err = conn.Write([]byte("GET /ipfs/bafkqaaa HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+			req.NoError(err)
+			received, err := io.ReadAll(conn)
+			req.NoError(err)
+			req.Len(received, expectBytes)
+			req.NoError(<-sendErr)
+
+			select {
+			case l := <-loggedCh:
+				req.Equal(http.StatusSwitchingProtocols, l.status)
+				req.Equal(expectBytes, l.bytes)
+			case <-time.After(5 * time.Second):
+				req.FailNow("request wasn't logged")
+			}
+			select {
+			case <-loggedCh:
+				req.FailNow("request logged more than once")
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func TestLoggingResponseWriterCompressionRatio(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		wrote       int // bytes written in to the response, before compression
+		sent        int // bytes sent
+		expectFloat float64
+		expectOk    bool
+		expectStr   string
+	}{
+		{name: "nothing sent", expectStr: "-"},
+		{name: "nothing written in", sent: 100, expectStr: "-"},
+		{name: "equal", wrote: 100, sent: 100, expectStr: "-"},
+		{name: "compressed", wrote: 300, sent: 100, expectFloat: 3, expectOk: true, expectStr: "3.00"},
+		{name: "rounded", wrote: 1000, sent: 300, expectFloat: 1000.0 / 300, expectOk: true, expectStr: "3.33"},
+		{name: "expanded", wrote: 100, sent: 120, expectFloat: 100.0 / 120, expectOk: true, expectStr: "0.83"},
+		{name: "rounds to zero", wrote: 1, sent: 1000, expectFloat: 0.001, expectOk: true, expectStr: "-"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+			lrw := frisbii.NewLoggingResponseWriter(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil), io.Discard, nil)
+			if tc.sent > 0 {
+				_, err := lrw.Write(make([]byte, tc.sent))
+				req.NoError(err)
+			}
+			if tc.wrote > 0 {
+				lrw.WroteBytes(tc.wrote)
+			}
+			ratio, ok := lrw.CompressionRatioFloat()
+			req.Equal(tc.expectOk, ok)
+			req.InDelta(tc.expectFloat, ratio, 1e-9)
+			req.Equal(tc.expectStr, lrw.CompressionRatio())
+		})
+	}
+}
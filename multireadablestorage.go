@@ -18,16 +18,23 @@ var _ storage.ReadableStorage = (*MultiReadableStorage)(nil)
 
 // MultiReadableStorage manages a list of storage.StreamingReadableStorage
 // stores, providing a unified LinkSystem interface to them.
+//
+// Where stores overlap, a block is read from the first store added that has
+// it, except for a root, which is read first from the first store added with
+// it as a root, its authoritative store. A root that is added with more than
+// one store is listed once by Roots.
 type MultiReadableStorage struct {
-	stores []storage.StreamingReadableStorage
-	roots  []cid.Cid
-	lk     sync.RWMutex
+	stores    []storage.StreamingReadableStorage
+	roots     []cid.Cid
+	rootStore map[string]int // root key to the index of its authoritative store
+	lk        sync.RWMutex
 }
 
 func NewMultiReadableStorage() *MultiReadableStorage {
 	return &MultiReadableStorage{
-		stores: make([]storage.StreamingReadableStorage, 0),
-		roots:  make([]cid.Cid, 0),
+		stores:    make([]storage.StreamingReadableStorage, 0),
+		roots:     make([]cid.Cid, 0),
+		rootStore: make(map[string]int),
 	}
 }
 
@@ -35,7 +42,40 @@ func (m *MultiReadableStorage) AddStore(store storage.StreamingReadableStorage,
 	m.lk.Lock()
 	defer m.lk.Unlock()
 	m.stores = append(m.stores, store)
-	m.roots = append(m.roots, roots...)
+	for _, root := range roots {
+		if _, ok := m.rootStore[root.KeyString()]; ok {
+			continue
+		}
+		m.rootStore[root.KeyString()] = len(m.stores) - 1
+		m.roots = append(m.roots, root)
+	}
+}
+
+// ReplaceStores replaces all of the stores, and their roots, with those that
+// have been added to other, e.g. to apply a reloaded set of CAR files. Reads
+// that are already in progress on a replaced store are unaffected.
+func (m *MultiReadableStorage) ReplaceStores(other *MultiReadableStorage) {
+	other.lk.RLock()
+	stores := append([]storage.StreamingReadableStorage(nil), other.stores...)
+	roots := append([]cid.Cid(nil), other.roots...)
+	rootStore := make(map[string]int, len(other.rootStore))
+	for key, idx := range other.rootStore {
+		rootStore[key] = idx
+	}
+	other.lk.RUnlock()
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	m.stores = stores
+	m.roots = roots
+	m.rootStore = rootStore
+}
+
+// Roots returns the roots of all of the stores that have been added, in the
+// order they were added, without duplicates.
+func (m *MultiReadableStorage) Roots() []cid.Cid {
+	m.lk.RLock()
+	defer m.lk.RUnlock()
+	return append([]cid.Cid(nil), m.roots...)
 }
 
 func (m *MultiReadableStorage) RootsLister() provider.MultihashLister {
@@ -53,7 +93,7 @@ func (m *MultiReadableStorage) RootsLister() provider.MultihashLister {
 func (m *MultiReadableStorage) Has(ctx context.Context, key string) (bool, error) {
 	m.lk.RLock()
 	defer m.lk.RUnlock()
-	for _, store := range m.stores {
+	for _, store := range m.storesFor(key) {
 		if hasStore, ok := store.(storage.Storage); ok {
 			has, err := hasStore.Has(ctx, key)
 			if err != nil {
@@ -85,10 +125,21 @@ func (m *MultiReadableStorage) Get(ctx context.Context, key string) ([]byte, err
 	}
 }
 
+// storesFor returns the stores in the order they should be tried for key, the
+// authoritative store first where key is a root. m.lk must be held.
+func (m *MultiReadableStorage) storesFor(key string) []storage.StreamingReadableStorage {
+	idx, ok := m.rootStore[key]
+	if !ok || idx == 0 {
+		return m.stores
+	}
+	stores := make([]storage.StreamingReadableStorage, 0, len(m.stores))
+	stores = append(stores, m.stores[idx])
+	stores = append(stores, m.stores[:idx]...)
+	return append(stores, m.stores[idx+1:]...)
+}
+
 // TODO: store affinity via context? Once we find a store that has the
 // block, we should prefer it for the rest of the request.
-// TODO: check roots list for the block being requested, if it's in the
-// list, we know which store to go to first.
 
 func (m *MultiReadableStorage) GetStream(ctx context.Context, key string) (io.ReadCloser, error) {
 	if ctx.Err() != nil {
@@ -96,7 +147,7 @@ func (m *MultiReadableStorage) GetStream(ctx context.Context, key string) (io.Re
 	}
 	m.lk.RLock()
 	defer m.lk.RUnlock()
-	for _, store := range m.stores {
+	for _, store := range m.storesFor(key) {
 		rdr, err := store.GetStream(ctx, key)
 		if err != nil {
 			if nf, ok := err.(interface{ NotFound() bool }); ok && nf.NotFound() {
@@ -37,6 +37,8 @@ func TestMultiReadableStorage(t *testing.T) {
 			multistore.AddStore(&trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{Bag: bag}}, []cid.Cid{blocks[ii*20].cid})
 		}
 	}
+	req.Equal([]cid.Cid{blocks[0].cid, blocks[20].cid, blocks[40].cid, blocks[60].cid, blocks[80].cid}, multistore.Roots())
+
 	lsys := cidlink.DefaultLinkSystem()
 	lsys.TrustedStorage = true
 	lsys.SetReadStorage(multistore)
@@ -62,6 +64,54 @@ func TestMultiReadableStorage(t *testing.T) {
 	req.ErrorIs(err, format.ErrNotFound{})
 }
 
+func TestMultiReadableStorageSharedRoots(t *testing.T) {
+	req := require.New(t)
+
+	root, shared, onlyA, onlyB := randBlock(), randBlock(), randBlock(), randBlock()
+	// the stores hold divergent data for the same CIDs, as a corrupt or
+	// mislabelled CAR could, so that which store a block is read from shows
+	bag := func(blocks ...blk) map[string][]byte {
+		b := make(map[string][]byte)
+		for _, blk := range blocks {
+			b[blk.cid.KeyString()] = blk.byts
+		}
+		return b
+	}
+	diverge := func(b blk) blk {
+		return blk{b.cid, append([]byte("diverged"), b.byts...)}
+	}
+	// an earlier store has the root block without listing it as a root
+	other := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{Bag: bag(diverge(root), diverge(shared))}}
+	a := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{Bag: bag(root, shared, onlyA)}}
+	b := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{Bag: bag(diverge(root), shared, onlyB)}}
+
+	multistore := frisbii.NewMultiReadableStorage()
+	multistore.AddStore(other, nil)
+	multistore.AddStore(a, []cid.Cid{root.cid, onlyA.cid})
+	multistore.AddStore(b, []cid.Cid{onlyB.cid, root.cid})
+	req.Equal([]cid.Cid{root.cid, onlyA.cid, onlyB.cid}, multistore.Roots())
+
+	get := func(b blk) []byte {
+		byts, err := multistore.Get(context.Background(), b.cid.KeyString())
+		req.NoError(err)
+		return byts
+	}
+	// the root is read from the first store listing it, other blocks from the
+	// first store that has them
+	req.Equal(root.byts, get(root))
+	req.Equal(diverge(shared).byts, get(shared))
+	req.Equal(onlyA.byts, get(onlyA))
+	req.Equal(onlyB.byts, get(onlyB))
+
+	// the roots survive replacement along with their authoritative stores
+	replaced := frisbii.NewMultiReadableStorage()
+	replaced.ReplaceStores(multistore)
+	req.Equal(multistore.Roots(), replaced.Roots())
+	byts, err := replaced.Get(context.Background(), root.cid.KeyString())
+	req.NoError(err)
+	req.Equal(root.byts, byts)
+}
+
 type blk struct {
 	cid  cid.Cid
 	byts []byte
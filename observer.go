@@ -0,0 +1,84 @@
+package frisbii
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+// RequestEvent describes a completed request, as delivered to a
+// RequestObserver.
+type RequestEvent struct {
+	// Time is the time the request was received.
+	Time       time.Time
+	RemoteAddr string
+	Method     string
+	// URL is the request URL, with query redaction applied if enabled with
+	// WithLogRedactQuery.
+	URL url.URL
+	// Cid is the root CID of the request, or cid.Undef if the request failed
+	// before a valid CID could be parsed.
+	Cid      cid.Cid
+	Status   int
+	Bytes    int
+	Duration time.Duration
+	// TimeToFirstByte is the time from the request being received until the
+	// first byte of the response body was written, or Duration where no body
+	// was written, e.g. for a 304 Not Modified or an error.
+	TimeToFirstByte time.Duration
+	// CompressionRatio is the compression ratio of the response, or "-" if the
+	// response was not compressed.
+	CompressionRatio string
+	// CompressionRatioFloat is the compression ratio of the response as a
+	// number, or 0 if the response was not compressed, see
+	// LoggingResponseWriter#CompressionRatioFloat.
+	CompressionRatioFloat float64
+	UserAgent             string
+	// Err is the error that caused the request to fail, if any. This includes
+	// errors that occur after the response has started streaming, which can
+	// only be signalled to the client by an unclean close, and panics in the
+	// handler.
+	Err error
+}
+
+// RequestObserver can be supplied with WithRequestObserver to be notified of
+// each request once it has completed, including requests that failed or that
+// caused the handler to panic. This is an alternative to parsing the request
+// log, and is not affected by the log filtering options.
+//
+// ObserveRequest is called synchronously on the request goroutine, before the
+// connection is released, so implementations must not block; events that
+// require slow processing should be queued and handled elsewhere.
+type RequestObserver interface {
+	ObserveRequest(RequestEvent)
+}
+
+// RequestObserverFunc is an adapter to allow the use of an ordinary function as
+// a RequestObserver.
+type RequestObserverFunc func(RequestEvent)
+
+// ObserveRequest calls f(event).
+func (f RequestObserverFunc) ObserveRequest(event RequestEvent) {
+	f(event)
+}
+
+func (w *LoggingResponseWriter) event(start time.Time) RequestEvent {
+	duration := time.Since(start)
+	ratio, _ := w.CompressionRatioFloat()
+	return RequestEvent{
+		Time:                  start,
+		RemoteAddr:            w.req.RemoteAddr,
+		Method:                w.req.Method,
+		URL:                   w.logUrl(),
+		Cid:                   w.rootCid,
+		Status:                w.status,
+		Bytes:                 w.sentBytes,
+		Duration:              duration,
+		TimeToFirstByte:       w.timeToFirstByte(start, duration),
+		CompressionRatio:      w.CompressionRatio(),
+		CompressionRatioFloat: ratio,
+		UserAgent:             w.req.UserAgent(),
+		Err:                   w.err,
+	}
+}
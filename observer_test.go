@@ -0,0 +1,107 @@
+package frisbii_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/frisbii"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+	trustlesstestutil "github.com/ipld/go-trustless-utils/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestObserver(t *testing.T) {
+	store := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	dupyLinks, _ := mkDupy(lsys)
+	missing := cid.MustParse("bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi")
+
+	events := make([]frisbii.RequestEvent, 0)
+	observer := frisbii.RequestObserverFunc(func(event frisbii.RequestEvent) {
+		events = append(events, event)
+	})
+
+	for _, tc := range []struct {
+		name           string
+		path           string
+		expectedCid    cid.Cid
+		expectedStatus int
+		expectedErr    string
+	}{
+		{
+			name:           "success",
+			path:           "/ipfs/" + dupyLinks[0].String(),
+			expectedCid:    dupyLinks[0],
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "bad cid",
+			path:           "/ipfs/foobarbaz",
+			expectedStatus: http.StatusBadRequest,
+			expectedErr:    "failed to parse CID path parameter",
+		},
+		{
+			name:           "block not found",
+			path:           "/ipfs/" + missing.String(),
+			expectedCid:    missing,
+			expectedStatus: http.StatusInternalServerError,
+			expectedErr:    "could not find " + missing.String(),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+			events = events[:0]
+
+			opts := []frisbii.HttpOption{frisbii.WithRequestObserver(observer)}
+			handler := frisbii.NewLogMiddleware(frisbii.NewHttpIpfs(context.Background(), lsys, opts...), opts...)
+			request := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			request.Header.Set("Accept", trustlesshttp.DefaultContentType().String())
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, request)
+
+			req.Len(events, 1)
+			event := events[0]
+			req.Equal(http.MethodGet, event.Method)
+			req.Equal(tc.path, event.URL.Path)
+			req.Equal(tc.expectedCid, event.Cid)
+			req.Equal(tc.expectedStatus, event.Status)
+			req.Equal(rec.Body.Len(), event.Bytes)
+			req.Equal("-", event.CompressionRatio)
+			req.Zero(event.CompressionRatioFloat)
+			if tc.expectedErr == "" {
+				req.NoError(event.Err)
+			} else {
+				req.ErrorContains(event.Err, tc.expectedErr)
+			}
+		})
+	}
+
+	t.Run("panic", func(t *testing.T) {
+		req := require.New(t)
+		events = events[:0]
+
+		handler := frisbii.NewLogMiddleware(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			panic("boom")
+		}), frisbii.WithRequestObserver(observer))
+		testServer := httptest.NewServer(handler)
+		defer testServer.Close()
+
+		res, err := http.Get(testServer.URL + "/ipfs/" + dupyLinks[0].String())
+		if err == nil {
+			_, _ = io.ReadAll(res.Body)
+			res.Body.Close()
+		}
+
+		req.Len(events, 1)
+		req.Equal(http.StatusInternalServerError, events[0].Status)
+		req.ErrorContains(events[0].Err, "panic: boom")
+	})
+}
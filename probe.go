@@ -0,0 +1,103 @@
+package frisbii
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// probeRequested returns true if the request asks for an existence check
+// rather than content, via a truthy "probe" query parameter.
+func probeRequested(req *http.Request) (bool, error) {
+	if !req.URL.Query().Has("probe") {
+		return false, nil
+	}
+	probe, err := strconv.ParseBool(req.URL.Query().Get("probe"))
+	if err != nil {
+		return false, errors.New("invalid probe parameter")
+	}
+	return probe, nil
+}
+
+// serveProbe responds to an existence check for the root CID and sub-path at
+// path (with the /ipfs prefix removed). The sub-path is resolved through the
+// UnixFS nodes that lead to the target, but neither the target nor anything
+// below it is read; the target is only checked for presence. Where it is
+// present, a 204 No Content is sent with the resolved CID in the
+// X-Ipfs-Path-Resolved header, otherwise a 404 Not Found.
+func serveProbe(
+	ctx context.Context,
+	lsys linking.LinkSystem,
+	res http.ResponseWriter,
+	cfg *httpOptions,
+	path datamodel.Path,
+	authorize func(cid.Cid) bool,
+	logError func(int, error),
+) {
+	cidSeg, path := path.Shift()
+	root, err := cid.Parse(cidSeg.String())
+	if err != nil {
+		logError(http.StatusBadRequest, errors.New("failed to parse CID path parameter"))
+		return
+	}
+	if lrw := unwrapLoggingResponseWriter(res); lrw != nil {
+		lrw.rootCid = root
+	}
+	if !cfg.servable(root) {
+		// indistinguishable from content that isn't present
+		logError(http.StatusNotFound, fmt.Errorf("root not found: %s", root))
+		return
+	}
+
+	target, err := resolveUnixFSLink(ctx, lsys, root, path)
+	if err != nil {
+		if ctx.Err() != nil {
+			logError(http.StatusInternalServerError, ctx.Err())
+			return
+		}
+		logError(http.StatusNotFound, fmt.Errorf("failed to resolve path: %w", err))
+		return
+	}
+
+	if has, err := hasBlock(ctx, lsys, cfg, target); err != nil {
+		logError(http.StatusInternalServerError, err)
+		return
+	} else if !has {
+		logError(http.StatusNotFound, fmt.Errorf("not found: %s", target))
+		return
+	}
+	if !authorize(root) {
+		return
+	}
+
+	res.Header().Set("X-Ipfs-Path-Resolved", "/ipfs/"+target.String())
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// hasBlock checks for the presence of a block, via the presence check store
+// where there is one, otherwise by opening it from the LinkSystem without
+// reading it.
+func hasBlock(ctx context.Context, lsys linking.LinkSystem, cfg *httpOptions, c cid.Cid) (bool, error) {
+	if cfg.PresenceCheck != nil {
+		return cfg.PresenceCheck.Has(ctx, c.KeyString())
+	}
+	r, err := lsys.StorageReadOpener(linking.LinkContext{Ctx: ctx}, cidlink.Link{Cid: c})
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if closer, ok := r.(io.Closer); ok {
+		closer.Close()
+	}
+	return true, nil
+}
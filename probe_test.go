@@ -0,0 +1,136 @@
+package frisbii_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipld/frisbii"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+	trustlesstestutil "github.com/ipld/go-trustless-utils/testutil"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHttpIpfsProbe(t *testing.T) {
+	store := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	unixfsnode.AddUnixFSReificationToLinkSystem(&lsys)
+
+	// a link to a block that isn't in the store
+	missingHash, err := multihash.Sum([]byte("not stored"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	missingCid := cid.NewCidV1(cid.Raw, missingHash)
+
+	fileLnk := mkUnixfsFile(t, lsys, []byte("hello world"))
+	subdirLnk := mkUnixfsDir(t, lsys, map[string]datamodel.Link{"nested.txt": fileLnk})
+	dirLnk := mkUnixfsDir(t, lsys, map[string]datamodel.Link{
+		"hello.txt": fileLnk,
+		"sub dir":   subdirLnk,
+		"missing":   cidlink.Link{Cid: missingCid},
+	})
+	dirCid := dirLnk.(cidlink.Link).Cid
+	fileCid := fileLnk.(cidlink.Link).Cid
+
+	for _, tc := range []struct {
+		name           string
+		opts           []frisbii.HttpOption
+		path           string
+		expectStatus   int
+		expectResolved cid.Cid
+	}{
+		{
+			name:           "root",
+			path:           "/ipfs/" + dirCid.String() + "?probe=1",
+			expectStatus:   http.StatusNoContent,
+			expectResolved: dirCid,
+		},
+		{
+			name:           "file",
+			path:           "/ipfs/" + dirCid.String() + "/hello.txt?probe=true",
+			expectStatus:   http.StatusNoContent,
+			expectResolved: fileCid,
+		},
+		{
+			name:           "nested file",
+			path:           "/ipfs/" + dirCid.String() + "/sub%20dir/nested.txt?probe=1",
+			expectStatus:   http.StatusNoContent,
+			expectResolved: fileCid,
+		},
+		{
+			name:           "nested file with presence check",
+			opts:           []frisbii.HttpOption{frisbii.WithPresenceCheck(store)},
+			path:           "/ipfs/" + dirCid.String() + "/sub%20dir/nested.txt?probe=1",
+			expectStatus:   http.StatusNoContent,
+			expectResolved: fileCid,
+		},
+		{
+			name:         "missing path segment",
+			path:         "/ipfs/" + dirCid.String() + "/nope.txt?probe=1",
+			expectStatus: http.StatusNotFound,
+		},
+		{
+			name:         "missing target",
+			path:         "/ipfs/" + dirCid.String() + "/missing?probe=1",
+			expectStatus: http.StatusNotFound,
+		},
+		{
+			name:         "missing target with presence check",
+			opts:         []frisbii.HttpOption{frisbii.WithPresenceCheck(store)},
+			path:         "/ipfs/" + dirCid.String() + "/missing?probe=1",
+			expectStatus: http.StatusNotFound,
+		},
+		{
+			name:         "missing root",
+			path:         "/ipfs/" + missingCid.String() + "?probe=1",
+			expectStatus: http.StatusNotFound,
+		},
+		{
+			name:         "unservable root",
+			opts:         []frisbii.HttpOption{frisbii.WithServableRoots([]cid.Cid{fileCid})},
+			path:         "/ipfs/" + dirCid.String() + "?probe=1",
+			expectStatus: http.StatusNotFound,
+		},
+		{
+			name:         "bad CID",
+			path:         "/ipfs/nope?probe=1",
+			expectStatus: http.StatusBadRequest,
+		},
+		{
+			name:         "bad probe value",
+			path:         "/ipfs/" + dirCid.String() + "?probe=maybe",
+			expectStatus: http.StatusBadRequest,
+		},
+		{
+			name:         "probe disabled",
+			path:         "/ipfs/" + dirCid.String() + "?probe=0",
+			expectStatus: http.StatusOK,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+
+			handler := frisbii.NewHttpIpfs(context.Background(), lsys, tc.opts...)
+			request := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			request.Header.Set("Accept", trustlesshttp.DefaultContentType().String())
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, request)
+
+			req.Equal(tc.expectStatus, rec.Code)
+			if tc.expectResolved.Defined() {
+				req.Equal("/ipfs/"+tc.expectResolved.String(), rec.Header().Get("X-Ipfs-Path-Resolved"))
+				req.Empty(rec.Body.Bytes())
+			} else {
+				req.Empty(rec.Header().Get("X-Ipfs-Path-Resolved"))
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package frisbii
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const responseCacheTmpPrefix = "tmp-"
+
+// ResponseCache is a size-bounded, on-disk cache of complete CAR responses,
+// keyed by the ETag of the request that produced them. Since the ETag of a
+// request describes the root, path, scope, byte range and duplicates mode, and
+// the CAR for a given request is deterministic, an identical request can be
+// served directly from the cache rather than traversing the DAG again.
+//
+// Entries are evicted in least-recently-used order when the total size of the
+// cache exceeds its maximum size. A ResponseCache is safe for concurrent use.
+type ResponseCache struct {
+	dir      string
+	maxBytes int64
+
+	lk      sync.Mutex
+	lru     *list.List // of *responseCacheItem, most recently used at the front
+	entries map[string]*list.Element
+	size    int64
+	nextTmp int
+	// generation is incremented on Clear() so that responses collected before
+	// then aren't added to the cache
+	generation int
+}
+
+type responseCacheItem struct {
+	key  string
+	path string
+	size int64
+}
+
+// NewResponseCache creates a ResponseCache that stores responses in dir, which
+// will be created if it doesn't exist, and evicts responses once their total
+// size exceeds maxBytes. Any responses left in dir by a previous cache are
+// removed, other files and directories in dir are left alone, but dir should
+// be dedicated to the use of a single ResponseCache.
+func NewResponseCache(dir string, maxBytes int64) (*ResponseCache, error) {
+	if maxBytes <= 0 {
+		return nil, errors.New("response cache size must be greater than zero")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	rc := &ResponseCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+	// we don't know what the content of a previous cache was derived from, so
+	// start clean
+	if err := rc.removeAll(false); err != nil {
+		return nil, err
+	}
+	return rc, nil
+}
+
+// Size returns the total size, in bytes, of the responses currently held in
+// the cache.
+func (rc *ResponseCache) Size() int64 {
+	rc.lk.Lock()
+	defer rc.lk.Unlock()
+	return rc.size
+}
+
+// Clear removes all responses from the cache. This should be called whenever
+// the data backing the cached responses changes, such as when CAR files are
+// added to or removed from the LinkSystem.
+func (rc *ResponseCache) Clear() error {
+	rc.lk.Lock()
+	defer rc.lk.Unlock()
+	rc.lru.Init()
+	rc.entries = make(map[string]*list.Element)
+	rc.size = 0
+	rc.generation++
+	return rc.removeAll(true)
+}
+
+// removeAll removes the responses in the cache directory, optionally leaving
+// temporary files for in-progress responses to be cleaned up by their writers.
+// Only files named as the cache names them are removed, the directory may be
+// one that's shared, e.g. by mistake, so nothing else is touched.
+func (rc *ResponseCache) removeAll(keepTmp bool) error {
+	dirEntries, err := os.ReadDir(rc.dir)
+	if err != nil {
+		return err
+	}
+	for _, de := range dirEntries {
+		if !de.Type().IsRegular() {
+			continue
+		}
+		tmp := isResponseCacheTmpFilename(de.Name())
+		if (keepTmp && tmp) || (!tmp && !isResponseCacheFilename(de.Name())) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(rc.dir, de.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func responseCacheFilename(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// isResponseCacheFilename returns true if name is one that
+// responseCacheFilename could have produced.
+func isResponseCacheFilename(name string) bool {
+	if len(name) != sha256.Size*2 || strings.ToLower(name) != name {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// isResponseCacheTmpFilename returns true if name is one that create could
+// have produced for an in-progress response.
+func isResponseCacheTmpFilename(name string) bool {
+	n, ok := strings.CutPrefix(name, responseCacheTmpPrefix)
+	if !ok || n == "" {
+		return false
+	}
+	for _, r := range n {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// serve copies the cached response for key to w, if there is one. The
+// returned bool indicates whether there was a cached response; if it is true,
+// the returned error indicates a failure to send the cached response.
+func (rc *ResponseCache) serve(key string, w io.Writer) (bool, error) {
+	rc.lk.Lock()
+	elem, ok := rc.entries[key]
+	if !ok {
+		rc.lk.Unlock()
+		return false, nil
+	}
+	rc.lru.MoveToFront(elem)
+	// open while holding the lock so it can't be evicted out from under us, once
+	// open we can keep reading even if it's removed
+	f, err := os.Open(elem.Value.(*responseCacheItem).path)
+	rc.lk.Unlock()
+	if err != nil {
+		logger.Warnw("unable to open cached response", "err", err)
+		return false, nil
+	}
+	defer f.Close()
+	if _, err := io.Copy(w, f); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// create returns a writer that collects a response for key, the response will
+// only be added to the cache if commit() is called on the returned writer.
+func (rc *ResponseCache) create(key string) *responseCacheWriter {
+	rc.lk.Lock()
+	rc.nextTmp++
+	tmpPath := filepath.Join(rc.dir, fmt.Sprintf("%s%d", responseCacheTmpPrefix, rc.nextTmp))
+	generation := rc.generation
+	rc.lk.Unlock()
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		logger.Warnw("unable to create cached response", "err", err)
+		f = nil
+	}
+	return &responseCacheWriter{rc: rc, key: key, generation: generation, f: f, failed: err != nil}
+}
+
+func (rc *ResponseCache) add(key string, generation int, tmpPath string, size int64) error {
+	rc.lk.Lock()
+	defer rc.lk.Unlock()
+	if _, ok := rc.entries[key]; ok || generation != rc.generation {
+		// a concurrent identical request beat us to it, or the cache was
+		// cleared while this response was being collected
+		return os.Remove(tmpPath)
+	}
+	path := filepath.Join(rc.dir, responseCacheFilename(key))
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	rc.entries[key] = rc.lru.PushFront(&responseCacheItem{key: key, path: path, size: size})
+	rc.size += size
+	for rc.size > rc.maxBytes {
+		elem := rc.lru.Back()
+		item := elem.Value.(*responseCacheItem)
+		rc.lru.Remove(elem)
+		delete(rc.entries, item.key)
+		rc.size -= item.size
+		if err := os.Remove(item.path); err != nil {
+			logger.Warnw("unable to remove evicted cached response", "err", err)
+		}
+	}
+	return nil
+}
+
+var _ io.Writer = (*responseCacheWriter)(nil)
+
+// responseCacheWriter collects a response destined for a ResponseCache. Writes
+// never fail so that they don't interrupt the response being sent to the
+// client; if the response can't be cached, it is discarded on commit().
+type responseCacheWriter struct {
+	rc         *ResponseCache
+	key        string
+	generation int
+	f          *os.File
+	size       int64
+	failed     bool
+}
+
+func (w *responseCacheWriter) Write(p []byte) (int, error) {
+	if w.failed {
+		return len(p), nil
+	}
+	w.size += int64(len(p))
+	if w.size > w.rc.maxBytes {
+		w.failed = true // would evict everything else, including itself
+		return len(p), nil
+	}
+	if _, err := w.f.Write(p); err != nil {
+		logger.Warnw("unable to write cached response", "err", err)
+		w.failed = true
+	}
+	return len(p), nil
+}
+
+// commit adds the collected response to the cache.
+func (w *responseCacheWriter) commit() {
+	if w.f == nil {
+		return
+	}
+	err := w.f.Close()
+	if err == nil && !w.failed {
+		err = w.rc.add(w.key, w.generation, w.f.Name(), w.size)
+	} else {
+		os.Remove(w.f.Name())
+	}
+	if err != nil {
+		logger.Warnw("unable to add cached response", "err", err)
+	}
+}
+
+// abort discards the collected response.
+func (w *responseCacheWriter) abort() {
+	if w.f == nil {
+		return
+	}
+	w.f.Close()
+	os.Remove(w.f.Name())
+}
@@ -0,0 +1,170 @@
+package frisbii_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/frisbii"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+	trustlesstestutil "github.com/ipld/go-trustless-utils/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseCache(t *testing.T) {
+	store := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	dupyLinks, _ := mkDupy(lsys)
+	root := dupyLinks[0]
+
+	// a LinkSystem with no data, that can only respond from the cache
+	emptyLsys := cidlink.DefaultLinkSystem()
+	emptyLsys.SetReadStorage(&trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{}})
+
+	get := func(t *testing.T, lsys linking.LinkSystem, cache *frisbii.ResponseCache, root cid.Cid, query string) (int, []byte) {
+		handler := frisbii.NewHttpIpfs(context.Background(), lsys, frisbii.WithResponseCache(cache))
+		request := httptest.NewRequest(http.MethodGet, "/ipfs/"+root.String()+query, nil)
+		request.Header.Set("Accept", trustlesshttp.DefaultContentType().String())
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, request)
+		body, err := io.ReadAll(rec.Result().Body)
+		require.NoError(t, err)
+		return rec.Code, body
+	}
+
+	t.Run("serves from cache", func(t *testing.T) {
+		req := require.New(t)
+		dir := t.TempDir()
+		cache, err := frisbii.NewResponseCache(dir, 1<<20)
+		req.NoError(err)
+
+		status, expected := get(t, lsys, cache, root, "")
+		req.Equal(http.StatusOK, status)
+		req.Equal(int64(len(expected)), cache.Size())
+
+		status, body := get(t, emptyLsys, cache, root, "")
+		req.Equal(http.StatusOK, status)
+		req.Equal(expected, body)
+
+		// a different request for the same root isn't a hit
+		status, _ = get(t, emptyLsys, cache, root, "?dag-scope=block")
+		req.Equal(http.StatusInternalServerError, status)
+
+		req.NoError(cache.Clear())
+		req.Equal(int64(0), cache.Size())
+		status, _ = get(t, emptyLsys, cache, root, "")
+		req.Equal(http.StatusInternalServerError, status)
+		entries, err := os.ReadDir(dir)
+		req.NoError(err)
+		req.Empty(entries)
+	})
+
+	t.Run("failures are not cached", func(t *testing.T) {
+		req := require.New(t)
+		cache, err := frisbii.NewResponseCache(t.TempDir(), 1<<20)
+		req.NoError(err)
+
+		status, _ := get(t, emptyLsys, cache, root, "")
+		req.Equal(http.StatusInternalServerError, status)
+		req.Equal(int64(0), cache.Size())
+	})
+
+	t.Run("evicts least recently used", func(t *testing.T) {
+		req := require.New(t)
+
+		type request struct {
+			root  cid.Cid
+			query string
+		}
+		// order the requests by response size, largest first, so that a cache
+		// that can hold the first two can also hold the first and last
+		sizeCache, err := frisbii.NewResponseCache(t.TempDir(), 1<<20)
+		req.NoError(err)
+		requests := []request{{root, ""}, {root, "?dag-scope=entity"}, {dupyLinks[1], ""}}
+		sizes := make(map[request]int)
+		for _, r := range requests {
+			_, body := get(t, lsys, sizeCache, r.root, r.query)
+			sizes[r] = len(body)
+		}
+		sort.SliceStable(requests, func(i, j int) bool { return sizes[requests[i]] > sizes[requests[j]] })
+		a, b, c := requests[0], requests[1], requests[2]
+
+		cache, err := frisbii.NewResponseCache(t.TempDir(), int64(sizes[a]+sizes[b]))
+		req.NoError(err)
+		get(t, lsys, cache, a.root, a.query)
+		get(t, lsys, cache, b.root, b.query)
+		// touch a so that b is the least recently used
+		status, _ := get(t, emptyLsys, cache, a.root, a.query)
+		req.Equal(http.StatusOK, status)
+		get(t, lsys, cache, c.root, c.query)
+		req.Equal(int64(sizes[a]+sizes[c]), cache.Size())
+
+		status, _ = get(t, emptyLsys, cache, b.root, b.query)
+		req.Equal(http.StatusInternalServerError, status)
+		status, _ = get(t, emptyLsys, cache, a.root, a.query)
+		req.Equal(http.StatusOK, status)
+		status, _ = get(t, emptyLsys, cache, c.root, c.query)
+		req.Equal(http.StatusOK, status)
+	})
+
+	t.Run("leaves files it didn't create", func(t *testing.T) {
+		req := require.New(t)
+		dir := t.TempDir()
+		write := func(name string) {
+			req.NoError(os.WriteFile(filepath.Join(dir, name), []byte(name), 0644))
+		}
+		// left by a previous cache
+		write(strings.Repeat("ab", 32))
+		write("tmp-1")
+		// not the cache's
+		write("notes.txt")
+		write("tmp-notes")
+		write(strings.Repeat("AB", 32))
+		req.NoError(os.MkdirAll(filepath.Join(dir, "subdir"), 0755))
+		req.NoError(os.WriteFile(filepath.Join(dir, "subdir", strings.Repeat("cd", 32)), nil, 0644))
+		kept := []string{strings.Repeat("AB", 32), "notes.txt", "subdir", "tmp-notes"}
+
+		names := func() []string {
+			entries, err := os.ReadDir(dir)
+			req.NoError(err)
+			var names []string
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+			return names
+		}
+		cache, err := frisbii.NewResponseCache(dir, 1<<20)
+		req.NoError(err)
+		req.Equal(kept, names())
+
+		status, _ := get(t, lsys, cache, root, "")
+		req.Equal(http.StatusOK, status)
+		req.Len(names(), len(kept)+1)
+		req.NoError(cache.Clear())
+		req.Equal(kept, names())
+		_, err = os.Stat(filepath.Join(dir, "subdir", strings.Repeat("cd", 32)))
+		req.NoError(err)
+	})
+
+	t.Run("too large to cache", func(t *testing.T) {
+		req := require.New(t)
+		cache, err := frisbii.NewResponseCache(t.TempDir(), 10)
+		req.NoError(err)
+
+		status, _ := get(t, lsys, cache, root, "")
+		req.Equal(http.StatusOK, status)
+		req.Equal(int64(0), cache.Size())
+	})
+}
@@ -0,0 +1,38 @@
+package frisbii
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer is obtained from the global OpenTelemetry TracerProvider, which is a
+// no-op unless the application installs one, so spans cost next to nothing
+// when tracing isn't configured.
+var tracer = otel.Tracer("github.com/ipld/frisbii")
+
+// startRequestSpan starts the parent span for an incoming HTTP request,
+// continuing any trace context supplied by the client (e.g. via a
+// `traceparent` header).
+func startRequestSpan(ctx context.Context, req *http.Request) (context.Context, trace.Span) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(req.Header))
+	return tracer.Start(ctx, "HttpIpfs",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.method", req.Method),
+			attribute.String("http.target", req.URL.Path),
+			attribute.String("http.user_agent", req.UserAgent()),
+		),
+	)
+}
+
+func recordSpanError(span trace.Span, status int, err error) {
+	span.SetAttributes(attribute.Int("http.status_code", status))
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
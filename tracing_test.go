@@ -0,0 +1,62 @@
+package frisbii_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipld/frisbii"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+	trustlesstestutil "github.com/ipld/go-trustless-utils/testutil"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestHttpIpfsTracing(t *testing.T) {
+	req := require.New(t)
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+
+	store := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	dupyLinks, _ := mkDupy(lsys)
+
+	handler := frisbii.NewHttpIpfs(context.Background(), lsys)
+	testServer := httptest.NewServer(handler)
+	defer testServer.Close()
+
+	const traceId = "4bf92f3577b34da6a3ce929d0e0e4736"
+	request, err := http.NewRequest(http.MethodGet, testServer.URL+"/ipfs/"+dupyLinks[0].String(), nil)
+	req.NoError(err)
+	request.Header.Set("Accept", trustlesshttp.DefaultContentType().String())
+	request.Header.Set("traceparent", "00-"+traceId+"-00f067aa0ba902b7-01")
+	res, err := http.DefaultClient.Do(request)
+	req.NoError(err)
+	_, err = io.ReadAll(res.Body)
+	req.NoError(err)
+	req.Equal(http.StatusOK, res.StatusCode)
+
+	spans := recorder.Ended()
+	req.Len(spans, 2)
+	req.Equal("StreamCar", spans[0].Name())
+	req.Equal("HttpIpfs", spans[1].Name())
+	req.Equal(traceId, spans[1].SpanContext().TraceID().String())
+	req.Equal("00f067aa0ba902b7", spans[1].Parent().SpanID().String())
+	req.Equal(spans[1].SpanContext().SpanID(), spans[0].Parent().SpanID())
+}
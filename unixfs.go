@@ -0,0 +1,148 @@
+package frisbii
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipfs/go-unixfsnode/directory"
+	"github.com/ipfs/go-unixfsnode/hamt"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var unixfsProtoChooser = dagpb.AddSupportToChooser(basicnode.Chooser)
+
+// unixfsEntry is a single named link within a UnixFS directory.
+type unixfsEntry struct {
+	Name string
+	Cid  cid.Cid
+}
+
+// loadUnixFSNode loads the block for the given CID and, where it is a dag-pb
+// block, interprets it as UnixFS. Non-UnixFS blocks are returned as plain
+// nodes.
+func loadUnixFSNode(ctx context.Context, lsys linking.LinkSystem, c cid.Cid) (datamodel.Node, error) {
+	lnk := cidlink.Link{Cid: c}
+	lctx := linking.LinkContext{Ctx: ctx}
+	proto, err := unixfsProtoChooser(lnk, lctx)
+	if err != nil {
+		return nil, err
+	}
+	node, err := lsys.Load(lctx, lnk, proto)
+	if err != nil {
+		return nil, err
+	}
+	return unixfsnode.Reify(lctx, node, &lsys)
+}
+
+// resolveUnixFSPath walks the given path from the root CID, interpreting each
+// node along the way as UnixFS, and returns the CID and node of the target.
+func resolveUnixFSPath(
+	ctx context.Context,
+	lsys linking.LinkSystem,
+	root cid.Cid,
+	path datamodel.Path,
+) (cid.Cid, datamodel.Node, error) {
+	ctx, span := tracer.Start(ctx, "ResolvePath", trace.WithAttributes(
+		attribute.String("cid", root.String()),
+		attribute.String("path", path.String()),
+	))
+	defer span.End()
+
+	c, err := walkUnixFSPath(ctx, lsys, root, path)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	node, err := loadUnixFSNode(ctx, lsys, c)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	return c, node, nil
+}
+
+// resolveUnixFSLink is resolveUnixFSPath without loading the target, which
+// may not be present, returning only its CID.
+func resolveUnixFSLink(
+	ctx context.Context,
+	lsys linking.LinkSystem,
+	root cid.Cid,
+	path datamodel.Path,
+) (cid.Cid, error) {
+	ctx, span := tracer.Start(ctx, "ResolvePath", trace.WithAttributes(
+		attribute.String("cid", root.String()),
+		attribute.String("path", path.String()),
+	))
+	defer span.End()
+
+	return walkUnixFSPath(ctx, lsys, root, path)
+}
+
+// walkUnixFSPath loads each node from the root CID down to, but not including,
+// the target of the given path, and returns the CID of the target.
+func walkUnixFSPath(ctx context.Context, lsys linking.LinkSystem, c cid.Cid, path datamodel.Path) (cid.Cid, error) {
+	var seg datamodel.PathSegment
+	for path.Len() > 0 {
+		node, err := loadUnixFSNode(ctx, lsys, c)
+		if err != nil {
+			return cid.Undef, err
+		}
+		seg, path = path.Shift()
+		child, err := node.LookupBySegment(seg)
+		if err != nil {
+			return cid.Undef, fmt.Errorf("failed to resolve path segment %q: %w", seg.String(), err)
+		}
+		lnk, err := child.AsLink()
+		if err != nil {
+			return cid.Undef, fmt.Errorf("path segment %q is not a link: %w", seg.String(), err)
+		}
+		cl, ok := lnk.(cidlink.Link)
+		if !ok {
+			return cid.Undef, fmt.Errorf("path segment %q is not a CID link", seg.String())
+		}
+		c = cl.Cid
+	}
+	return c, nil
+}
+
+// isUnixFSDirectory returns true if the node is a plain or sharded UnixFS
+// directory.
+func isUnixFSDirectory(node datamodel.Node) bool {
+	switch node.(type) {
+	case directory.UnixFSBasicDir, hamt.UnixFSHAMTShard:
+		return true
+	}
+	return false
+}
+
+// unixfsDirectoryEntries lists the named entries of a UnixFS directory node.
+func unixfsDirectoryEntries(node datamodel.Node) ([]unixfsEntry, error) {
+	entries := make([]unixfsEntry, 0)
+	itr := node.MapIterator()
+	for !itr.Done() {
+		k, v, err := itr.Next()
+		if err != nil {
+			return nil, err
+		}
+		name, err := k.AsString()
+		if err != nil {
+			return nil, err
+		}
+		lnk, err := v.AsLink()
+		if err != nil {
+			return nil, err
+		}
+		cl, ok := lnk.(cidlink.Link)
+		if !ok {
+			return nil, fmt.Errorf("directory entry %q is not a CID link", name)
+		}
+		entries = append(entries, unixfsEntry{Name: name, Cid: cl.Cid})
+	}
+	return entries, nil
+}
@@ -0,0 +1,281 @@
+package frisbii
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode/file"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/schema"
+)
+
+var _ fs.FS = (*unixfsFS)(nil)
+var _ fs.ReadDirFS = (*unixfsFS)(nil)
+var _ fs.StatFS = (*unixfsFS)(nil)
+
+// errNotUnixFS is returned when opening content that is not a UnixFS file or
+// directory, such as a raw block root or a non-UnixFS DAG.
+var errNotUnixFS = errors.New("not a UnixFS file or directory")
+
+// FS returns a read-only fs.FS view of the UnixFS content held by the stores,
+// which is useful for walking and reading content with the standard library in
+// tests and integrations, without going via HTTP.
+//
+// The top level of the filesystem is a directory containing the roots of the
+// stores that are UnixFS files or directories, each named by its CID string.
+// Any other block in the stores may also be opened by CID, as
+// "<cid>/path/to/file", as it would be requested from the gateway.
+//
+// Only UnixFS content is supported: opening a root that is a raw block, such
+// as a single-block file with raw leaves, or a DAG that isn't a UnixFS
+// directory or file returns an error. UnixFS symlinks are not supported.
+func (m *MultiReadableStorage) FS() fs.FS {
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(m)
+	return &unixfsFS{ctx: context.Background(), lsys: lsys, roots: m.Roots}
+}
+
+type unixfsFS struct {
+	ctx   context.Context
+	lsys  linking.LinkSystem
+	roots func() []cid.Cid
+}
+
+func (ufs *unixfsFS) Open(name string) (fs.File, error) {
+	if name == "." {
+		entries, err := ufs.rootEntries()
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &unixfsDir{ufs: ufs, info: unixfsFileInfo{name: ".", mode: fs.ModeDir | 0555}, entries: entries}, nil
+	}
+	info, node, err := ufs.resolve(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if info.IsDir() {
+		return &unixfsDir{ufs: ufs, info: info, node: node}, nil
+	}
+	rdr, err := unixfsFileReader(node)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &unixfsFile{info: info, rdr: rdr}, nil
+}
+
+func (ufs *unixfsFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	f, err := ufs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dir, ok := f.(*unixfsDir)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return dir.ReadDir(-1)
+}
+
+func (ufs *unixfsFS) Stat(name string) (fs.FileInfo, error) {
+	if name == "." {
+		return unixfsFileInfo{name: ".", mode: fs.ModeDir | 0555}, nil
+	}
+	info, _, err := ufs.resolve(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return info, nil
+}
+
+// resolve finds the UnixFS node at name, which must start with a CID, and
+// describes it.
+func (ufs *unixfsFS) resolve(name string) (unixfsFileInfo, datamodel.Node, error) {
+	if !fs.ValidPath(name) {
+		return unixfsFileInfo{}, nil, fs.ErrInvalid
+	}
+	cidStr, rest, _ := strings.Cut(name, "/")
+	root, err := cid.Parse(cidStr)
+	if err != nil {
+		return unixfsFileInfo{}, nil, fs.ErrNotExist
+	}
+	if root.Prefix().Codec != cid.DagProtobuf {
+		return unixfsFileInfo{}, nil, errNotUnixFS
+	}
+	c, node, err := resolveUnixFSPath(ufs.ctx, ufs.lsys, root, datamodel.ParsePath(rest))
+	if err != nil {
+		if isNotFound(err) {
+			return unixfsFileInfo{}, nil, fs.ErrNotExist
+		}
+		return unixfsFileInfo{}, nil, err
+	}
+	info, err := unixfsStat(name[strings.LastIndex(name, "/")+1:], c, node)
+	return info, node, err
+}
+
+// rootEntries lists the store roots that are UnixFS files or directories.
+func (ufs *unixfsFS) rootEntries() ([]fs.DirEntry, error) {
+	seen := make(map[string]struct{})
+	entries := make([]fs.DirEntry, 0)
+	for _, root := range ufs.roots() {
+		name := root.String()
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		info, _, err := ufs.resolve(name)
+		if err != nil {
+			if errors.Is(err, errNotUnixFS) || errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// isNotFound returns true if err indicates a missing block or path segment.
+func isNotFound(err error) bool {
+	var nf interface{ NotFound() bool }
+	if errors.As(err, &nf) && nf.NotFound() {
+		return true
+	}
+	var ne datamodel.ErrNotExists
+	return errors.As(err, &ne) || errors.Is(err, schema.ErrNoSuchField{})
+}
+
+// unixfsStat describes a UnixFS node, a plain bytes node (i.e. a raw leaf) is
+// treated as a file.
+func unixfsStat(name string, c cid.Cid, node datamodel.Node) (unixfsFileInfo, error) {
+	if isUnixFSDirectory(node) {
+		return unixfsFileInfo{name: name, mode: fs.ModeDir | 0555, cid: c}, nil
+	}
+	if node.Kind() != datamodel.Kind_Bytes {
+		return unixfsFileInfo{}, errNotUnixFS
+	}
+	rdr, err := unixfsFileReader(node)
+	if err != nil {
+		return unixfsFileInfo{}, err
+	}
+	size, err := rdr.Seek(0, io.SeekEnd)
+	if err != nil {
+		return unixfsFileInfo{}, err
+	}
+	return unixfsFileInfo{name: name, size: size, mode: 0444, cid: c}, nil
+}
+
+func unixfsFileReader(node datamodel.Node) (io.ReadSeeker, error) {
+	if lbn, ok := node.(file.LargeBytesNode); ok {
+		return lbn.AsLargeBytes()
+	}
+	byts, err := node.AsBytes()
+	if err != nil {
+		return nil, errNotUnixFS
+	}
+	return bytes.NewReader(byts), nil
+}
+
+var _ fs.FileInfo = unixfsFileInfo{}
+
+type unixfsFileInfo struct {
+	name string
+	size int64
+	mode fs.FileMode
+	cid  cid.Cid
+}
+
+func (fi unixfsFileInfo) Name() string       { return fi.name }
+func (fi unixfsFileInfo) Size() int64        { return fi.size }
+func (fi unixfsFileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi unixfsFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi unixfsFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+
+// Sys returns the CID of the file or directory, or cid.Undef for the top
+// level directory.
+func (fi unixfsFileInfo) Sys() any { return fi.cid }
+
+var _ fs.File = (*unixfsFile)(nil)
+var _ io.Seeker = (*unixfsFile)(nil)
+
+type unixfsFile struct {
+	info unixfsFileInfo
+	rdr  io.ReadSeeker
+}
+
+func (f *unixfsFile) Stat() (fs.FileInfo, error)                   { return f.info, nil }
+func (f *unixfsFile) Read(p []byte) (int, error)                   { return f.rdr.Read(p) }
+func (f *unixfsFile) Seek(offset int64, whence int) (int64, error) { return f.rdr.Seek(offset, whence) }
+func (f *unixfsFile) Close() error                                 { return nil }
+
+var _ fs.ReadDirFile = (*unixfsDir)(nil)
+
+type unixfsDir struct {
+	ufs     *unixfsFS
+	info    unixfsFileInfo
+	node    datamodel.Node // nil for the top level directory
+	entries []fs.DirEntry  // loaded on first ReadDir()
+	offset  int
+}
+
+func (d *unixfsDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *unixfsDir) Close() error               { return nil }
+
+func (d *unixfsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+func (d *unixfsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.entries == nil {
+		if err := d.loadEntries(); err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: d.info.name, Err: err}
+		}
+	}
+	remaining := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(remaining) {
+		n = len(remaining)
+	}
+	d.offset += n
+	return remaining[:n], nil
+}
+
+// loadEntries loads each of the directory's children in order to describe
+// them.
+func (d *unixfsDir) loadEntries() error {
+	ufsEntries, err := unixfsDirectoryEntries(d.node)
+	if err != nil {
+		return err
+	}
+	entries := make([]fs.DirEntry, 0, len(ufsEntries))
+	for _, entry := range ufsEntries {
+		node, err := loadUnixFSNode(d.ufs.ctx, d.ufs.lsys, entry.Cid)
+		if err != nil {
+			return fmt.Errorf("failed to load directory entry %q: %w", entry.Name, err)
+		}
+		info, err := unixfsStat(entry.Name, entry.Cid, node)
+		if err != nil {
+			return fmt.Errorf("directory entry %q: %w", entry.Name, err)
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	d.entries = entries
+	return nil
+}
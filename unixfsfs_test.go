@@ -0,0 +1,110 @@
+package frisbii_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/frisbii"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	trustlesstestutil "github.com/ipld/go-trustless-utils/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiReadableStorageFS(t *testing.T) {
+	req := require.New(t)
+
+	store := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+
+	bigContent := make([]byte, 3<<20) // multi-block
+	_, err := rand.Read(bigContent)
+	req.NoError(err)
+	big := mkUnixfsFile(t, lsys, bigContent)
+	small := mkUnixfsFile(t, lsys, []byte("small file"))
+	nested := mkUnixfsFile(t, lsys, []byte("nested file"))
+	sub := mkUnixfsDir(t, lsys, map[string]datamodel.Link{"nested.txt": nested})
+	empty := mkUnixfsDir(t, lsys, map[string]datamodel.Link{})
+	dir := mkUnixfsDir(t, lsys, map[string]datamodel.Link{
+		"big.bin":   big,
+		"small.txt": small,
+		"sub":       sub,
+		"empty":     empty,
+	})
+	dirCid := dir.(cidlink.Link).Cid
+	bigCid := big.(cidlink.Link).Cid
+	rawCid := small.(cidlink.Link).Cid
+	req.Equal(uint64(cid.Raw), rawCid.Prefix().Codec)
+
+	multistore := frisbii.NewMultiReadableStorage()
+	multistore.AddStore(store, []cid.Cid{dirCid, bigCid, rawCid})
+	fsys := multistore.FS()
+
+	req.NoError(fstest.TestFS(fsys,
+		dirCid.String()+"/small.txt",
+		dirCid.String()+"/big.bin",
+		dirCid.String()+"/sub/nested.txt",
+		bigCid.String(),
+	))
+
+	// the raw root isn't listed
+	entries, err := fs.ReadDir(fsys, ".")
+	req.NoError(err)
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	req.ElementsMatch([]string{dirCid.String(), bigCid.String()}, names)
+
+	entries, err = fs.ReadDir(fsys, dirCid.String())
+	req.NoError(err)
+	req.Len(entries, 4)
+	req.Equal("big.bin", entries[0].Name())
+	req.Equal("empty", entries[1].Name())
+	req.True(entries[1].IsDir())
+	req.Equal("small.txt", entries[2].Name())
+	req.Equal("sub", entries[3].Name())
+	req.True(entries[3].IsDir())
+
+	byts, err := fs.ReadFile(fsys, dirCid.String()+"/big.bin")
+	req.NoError(err)
+	req.True(bytes.Equal(bigContent, byts))
+	byts, err = fs.ReadFile(fsys, dirCid.String()+"/sub/nested.txt")
+	req.NoError(err)
+	req.Equal("nested file", string(byts))
+
+	info, err := fs.Stat(fsys, dirCid.String()+"/small.txt")
+	req.NoError(err)
+	req.Equal(int64(len("small file")), info.Size())
+	req.Equal(rawCid, info.Sys())
+
+	f, err := fsys.Open(dirCid.String() + "/big.bin")
+	req.NoError(err)
+	_, err = f.(io.Seeker).Seek(1<<20, io.SeekStart)
+	req.NoError(err)
+	buf := make([]byte, 1024)
+	_, err = io.ReadFull(f, buf)
+	req.NoError(err)
+	req.Equal(bigContent[1<<20:1<<20+1024], buf)
+	req.NoError(f.Close())
+
+	_, err = fsys.Open(dirCid.String() + "/nope")
+	req.True(errors.Is(err, fs.ErrNotExist))
+	_, err = fsys.Open(cid.NewCidV1(cid.DagProtobuf, randBlock().cid.Hash()).String())
+	req.True(errors.Is(err, fs.ErrNotExist))
+	_, err = fsys.Open("not-a-cid")
+	req.True(errors.Is(err, fs.ErrNotExist))
+	_, err = fsys.Open(rawCid.String())
+	req.ErrorContains(err, "not a UnixFS file or directory")
+	_, err = fsys.Open("/" + dirCid.String())
+	req.True(errors.Is(err, fs.ErrInvalid))
+}
@@ -0,0 +1,70 @@
+package frisbii
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal/selector"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	trustlessutils "github.com/ipld/go-trustless-utils"
+)
+
+// parseUrlPath splits the escaped form of a request URL's path into segments
+// before decoding each of them, so that an encoded "/" ("%2F") is part of a
+// segment rather than a separator. Empty segments are ignored.
+func parseUrlPath(u *url.URL) (datamodel.Path, error) {
+	escaped := strings.FieldsFunc(u.EscapedPath(), func(r rune) bool { return r == '/' })
+	segments := make([]datamodel.PathSegment, 0, len(escaped))
+	for _, seg := range escaped {
+		unescaped, err := url.PathUnescape(seg)
+		if err != nil {
+			return datamodel.Path{}, fmt.Errorf("invalid path segment: %w", err)
+		}
+		segments = append(segments, datamodel.PathSegmentOfString(unescaped))
+	}
+	return datamodel.NewPath(segments), nil
+}
+
+// requestSelector returns the selector for request, with the UnixFS path
+// taken from path rather than the request's Path, which can't represent
+// segments that contain a "/". This is otherwise the same as
+// request.Selector().
+func requestSelector(request trustlessutils.Request, path datamodel.Path) datamodel.Node {
+	request.Path = ""
+	var ss builder.SelectorSpec = selectorNode{request.Selector()}
+	ssb := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any)
+	for path.Len() > 0 {
+		// as per unixfsnode.UnixFSPathSelectorBuilder, wrapping as we walk back
+		// up the path
+		seg, inner := path.Last(), ss
+		ss = ssb.ExploreInterpretAs("unixfs", ssb.ExploreFields(func(efsb builder.ExploreFieldsSpecBuilder) {
+			efsb.Insert(seg.String(), inner)
+		}))
+		path = path.Pop()
+	}
+	return ss.Node()
+}
+
+// requestEtag returns the Etag for request, for path, which, unlike the
+// request's Path, distinguishes between a "/" within a segment and a
+// separator.
+func requestEtag(request trustlessutils.Request, path datamodel.Path) string {
+	request.Path = strings.TrimPrefix(urlPathEscape(path), "/")
+	return request.Etag()
+}
+
+// selectorNode is a builder.SelectorSpec for an existing selector node.
+type selectorNode struct {
+	node datamodel.Node
+}
+
+func (sn selectorNode) Node() datamodel.Node {
+	return sn.node
+}
+
+func (sn selectorNode) Selector() (selector.Selector, error) {
+	return selector.CompileSelector(sn.node)
+}
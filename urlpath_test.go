@@ -0,0 +1,109 @@
+package frisbii_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-unixfsnode"
+	"github.com/ipld/frisbii"
+	"github.com/ipld/go-car/v2"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+	trustlesstestutil "github.com/ipld/go-trustless-utils/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHttpIpfsEscapedPaths(t *testing.T) {
+	store := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.TrustedStorage = true
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	unixfsnode.AddUnixFSReificationToLinkSystem(&lsys)
+
+	file := func(content string) cid.Cid {
+		return mkUnixfsFile(t, lsys, []byte(content)).(cidlink.Link).Cid
+	}
+	spaceCid, unicodeCid, slashCid, nestedCid, percentCid := file("space"), file("unicode"), file("slash"), file("nested"), file("percent")
+	subdirLnk := mkUnixfsDir(t, lsys, map[string]datamodel.Link{"b": cidlink.Link{Cid: nestedCid}})
+	dirLnk := mkUnixfsDir(t, lsys, map[string]datamodel.Link{
+		"my file.txt": cidlink.Link{Cid: spaceCid},
+		"ünïcødé.txt": cidlink.Link{Cid: unicodeCid},
+		"a/b":         cidlink.Link{Cid: slashCid},
+		"a":           subdirLnk,
+		"100%.txt":    cidlink.Link{Cid: percentCid},
+	})
+	dirCid := dirLnk.(cidlink.Link).Cid
+	handler := frisbii.NewHttpIpfs(context.Background(), lsys, frisbii.WithDirectoryIndex(true))
+
+	for _, tc := range []struct {
+		name       string
+		path       string
+		expectCid  cid.Cid
+		expectPath string
+	}{
+		{"space", "/my%20file.txt", spaceCid, "/my%20file.txt"},
+		{"unicode, escaped", "/%C3%BCn%C3%AFc%C3%B8d%C3%A9.txt", unicodeCid, "/%C3%BCn%C3%AFc%C3%B8d%C3%A9.txt"},
+		{"unicode, unescaped", "/ünïcødé.txt", unicodeCid, "/%C3%BCn%C3%AFc%C3%B8d%C3%A9.txt"},
+		{"encoded slash", "/a%2Fb", slashCid, "/a%2Fb"},
+		{"lowercase encoded slash", "/a%2fb", slashCid, "/a%2Fb"},
+		{"separator", "/a/b", nestedCid, "/a/b"},
+		{"encoded percent", "/100%25.txt", percentCid, "/100%25.txt"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+			request := httptest.NewRequest(http.MethodGet, "/ipfs/"+dirCid.String()+tc.path+"?dag-scope=entity", nil)
+			request.Header.Set("Accept", trustlesshttp.DefaultContentType().String())
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, request)
+			req.Equal(http.StatusOK, rec.Code, rec.Body.String())
+			req.Equal("/ipfs/"+dirCid.String()+tc.expectPath, rec.Header().Get("X-Ipfs-Path"))
+
+			br, err := car.NewBlockReader(bytes.NewReader(rec.Body.Bytes()))
+			req.NoError(err)
+			var last cid.Cid
+			for {
+				blk, err := br.Next()
+				if err == io.EOF {
+					break
+				}
+				req.NoError(err)
+				last = blk.Cid()
+			}
+			req.Equal(tc.expectCid, last)
+		})
+	}
+
+	t.Run("distinct etags", func(t *testing.T) {
+		req := require.New(t)
+		etag := func(path string) string {
+			request := httptest.NewRequest(http.MethodGet, "/ipfs/"+dirCid.String()+path, nil)
+			request.Header.Set("Accept", trustlesshttp.DefaultContentType().String())
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, request)
+			req.Equal(http.StatusOK, rec.Code)
+			return rec.Header().Get("Etag")
+		}
+		req.NotEqual(etag("/a%2Fb"), etag("/a/b"))
+	})
+
+	t.Run("directory index", func(t *testing.T) {
+		req := require.New(t)
+		request := httptest.NewRequest(http.MethodGet, "/ipfs/"+dirCid.String(), nil)
+		request.Header.Set("Accept", browserAccept)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, request)
+		req.Equal(http.StatusOK, rec.Code)
+		body := rec.Body.String()
+		req.Contains(body, `<a href="/ipfs/`+dirCid.String()+`/a%2Fb">a/b</a>`)
+		req.Contains(body, `<a href="/ipfs/`+dirCid.String()+`/my%20file.txt">my file.txt</a>`)
+		req.Contains(body, `<a href="/ipfs/`+dirCid.String()+`/%C3%BCn%C3%AFc%C3%B8d%C3%A9.txt">ünïcødé.txt</a>`)
+	})
+}
@@ -0,0 +1,152 @@
+package frisbii
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const contentEncodingZstd = "zstd"
+
+// zstdEncoderLevel maps a gzip compression level, as used for
+// WithCompressionLevel, to the nearest zstd encoder level.
+func zstdEncoderLevel(level int) zstd.EncoderLevel {
+	switch {
+	case level == gzip.DefaultCompression:
+		return zstd.SpeedDefault
+	case level <= 3:
+		return zstd.SpeedFastest
+	case level <= 6:
+		return zstd.SpeedDefault
+	case level <= 8:
+		return zstd.SpeedBetterCompression
+	default:
+		return zstd.SpeedBestCompression
+	}
+}
+
+// acceptsZstd returns true if the request's Accept-Encoding header lists zstd
+// with a non-zero quality.
+func acceptsZstd(req *http.Request) bool {
+	for _, header := range req.Header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(header, ",") {
+			name, params, _ := strings.Cut(coding, ";")
+			if !strings.EqualFold(strings.TrimSpace(name), contentEncodingZstd) {
+				continue
+			}
+			if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if qv, err := strconv.ParseFloat(q, 64); err == nil && qv == 0 {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// newZstdHandler wraps next such that responses are compressed with zstd for
+// clients that accept it. zstd is preferred over gzip, so when it's used the
+// Accept-Encoding header is removed from the request that next sees to
+// prevent any further compression.
+func newZstdHandler(level int, next http.Handler) http.Handler {
+	// each response is its own stream, so an encoder is limited to a single
+	// goroutine rather than one per CPU
+	encoders := sync.Pool{New: func() any {
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstdEncoderLevel(level)), zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			panic(err) // only for invalid options
+		}
+		return enc
+	}}
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if !acceptsZstd(req) {
+			next.ServeHTTP(res, req)
+			return
+		}
+		req = req.Clone(req.Context())
+		req.Header.Del("Accept-Encoding")
+		zrw := &zstdResponseWriter{ResponseWriter: res, encoders: &encoders}
+		defer zrw.Close()
+		next.ServeHTTP(zrw, req)
+	})
+}
+
+var _ http.ResponseWriter = (*zstdResponseWriter)(nil)
+var _ http.Flusher = (*zstdResponseWriter)(nil)
+var _ http.Hijacker = (*zstdResponseWriter)(nil)
+
+// zstdResponseWriter compresses successful responses with zstd. Other
+// responses, such as errors, are small and are passed through uncompressed.
+type zstdResponseWriter struct {
+	http.ResponseWriter
+	encoders    *sync.Pool
+	enc         *zstd.Encoder
+	wroteHeader bool
+	hijacked    bool
+}
+
+func (w *zstdResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if status >= 200 && status < 300 && status != http.StatusNoContent {
+		w.Header().Set("Content-Encoding", contentEncodingZstd)
+		w.Header().Del("Content-Length")
+		w.enc = w.encoders.Get().(*zstd.Encoder)
+		w.enc.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *zstdResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.enc == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.enc.Write(b)
+}
+
+// Close completes the compressed stream, if there is one.
+func (w *zstdResponseWriter) Close() error {
+	if w.enc == nil {
+		return nil
+	}
+	var err error
+	if !w.hijacked {
+		err = w.enc.Close()
+	}
+	w.enc.Reset(nil)
+	w.encoders.Put(w.enc)
+	w.enc = nil
+	return err
+}
+
+func (w *zstdResponseWriter) Flush() {
+	if w.enc != nil {
+		if err := w.enc.Flush(); err != nil {
+			return
+		}
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *zstdResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := w.ResponseWriter.(http.Hijacker); ok {
+		w.hijacked = true
+		return hj.Hijack()
+	}
+	return nil, nil, errors.New("http.Hijacker not implemented")
+}
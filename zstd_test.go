@@ -0,0 +1,84 @@
+package frisbii_test
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipld/frisbii"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	trustlesshttp "github.com/ipld/go-trustless-utils/http"
+	trustlesstestutil "github.com/ipld/go-trustless-utils/testutil"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHttpIpfsZstd(t *testing.T) {
+	store := &trustlesstestutil.CorrectedMemStore{ParentStore: &memstore.Store{}}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	dupyLinks, _ := mkDupy(lsys)
+
+	handler := frisbii.NewHttpIpfs(context.Background(), lsys, frisbii.WithCompressionLevel(gzip.DefaultCompression))
+
+	for _, tc := range []struct {
+		name             string
+		path             string
+		acceptEncoding   string
+		expectedStatus   int
+		expectedEncoding string
+	}{
+		{
+			name:             "zstd",
+			path:             "/ipfs/" + dupyLinks[0].String(),
+			acceptEncoding:   "zstd",
+			expectedStatus:   http.StatusOK,
+			expectedEncoding: "zstd",
+		},
+		{
+			name:             "zstd with quality",
+			path:             "/ipfs/" + dupyLinks[0].String(),
+			acceptEncoding:   "gzip;q=1.0, zstd;q=0.5",
+			expectedStatus:   http.StatusOK,
+			expectedEncoding: "zstd",
+		},
+		{
+			name:             "zstd refused",
+			path:             "/ipfs/" + dupyLinks[0].String(),
+			acceptEncoding:   "gzip, zstd;q=0",
+			expectedStatus:   http.StatusOK,
+			expectedEncoding: "gzip",
+		},
+		{
+			name:           "errors are not compressed",
+			path:           "/ipfs/foobarbaz",
+			acceptEncoding: "zstd",
+			expectedStatus: http.StatusBadRequest,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := require.New(t)
+			request := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			request.Header.Set("Accept", trustlesshttp.DefaultContentType().String())
+			request.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, request)
+
+			req.Equal(tc.expectedStatus, rec.Code)
+			req.Equal(tc.expectedEncoding, rec.Header().Get("Content-Encoding"))
+			if tc.expectedEncoding == "zstd" {
+				zrdr, err := zstd.NewReader(rec.Body)
+				req.NoError(err)
+				defer zrdr.Close()
+				body, err := io.ReadAll(zrdr)
+				req.NoError(err)
+				req.NotEmpty(body)
+			}
+		})
+	}
+}